@@ -0,0 +1,474 @@
+package filesystem
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// ACLType names the two ACLs a node can have by their extended attribute
+type ACLType string
+
+const (
+	// ACLAccess is checked on access to the node itself
+	ACLAccess ACLType = "system.posix_acl_access"
+	// ACLDefault is what nodes created in a directory start out with
+	ACLDefault ACLType = "system.posix_acl_default"
+)
+
+// ACLTag is what an ACL entry applies to
+type ACLTag int
+
+const (
+	ACLUserObj ACLTag = iota
+	ACLUser
+	ACLGroupObj
+	ACLGroup
+	ACLMask
+	ACLOther
+)
+
+var aclTagNames = map[ACLTag]string{
+	ACLUserObj:  "user",
+	ACLUser:     "user",
+	ACLGroupObj: "group",
+	ACLGroup:    "group",
+	ACLMask:     "mask",
+	ACLOther:    "other",
+}
+
+// ACLEntry grants Perm to whoever Tag and Id select
+type ACLEntry struct {
+	Tag ACLTag
+	// Id is the uid or gid of ACLUser and ACLGroup entries
+	Id int
+	// Perm combines AccessRead, AccessWrite and AccessExecute
+	Perm uint32
+}
+
+// ACL is a POSIX.1e access control list. A valid one has exactly one
+// ACLUserObj, ACLGroupObj and ACLOther entry, and an ACLMask entry as soon
+// as it names users or groups. The owner, mask and other entries mirror the
+// permission bits of the node, the mask taking the place of the group bits.
+type ACL []ACLEntry
+
+// ParseACL reads the text form getfacl prints, entries like user:1000:r-x
+// separated by commas or newlines. Ids are numeric.
+func ParseACL(text string) (ACL, error) {
+	var acl ACL
+
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		field, _, _ = strings.Cut(field, "#")
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		parts := strings.Split(field, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("acl entry %q: want tag:id:permissions", field)
+		}
+
+		entry := ACLEntry{}
+
+		switch parts[0] {
+		case "user", "u":
+			entry.Tag = ACLUserObj
+		case "group", "g":
+			entry.Tag = ACLGroupObj
+		case "mask", "m":
+			entry.Tag = ACLMask
+		case "other", "o":
+			entry.Tag = ACLOther
+		default:
+			return nil, fmt.Errorf("acl entry %q: unknown tag", field)
+		}
+
+		if parts[1] != "" {
+			id, err := strconv.Atoi(parts[1])
+			if err != nil || id < 0 || entry.Tag != ACLUserObj && entry.Tag != ACLGroupObj {
+				return nil, fmt.Errorf("acl entry %q: bad id", field)
+			}
+
+			entry.Tag, entry.Id = entry.Tag+1, id
+		}
+
+		for _, permission := range parts[2] {
+			switch permission {
+			case 'r':
+				entry.Perm |= AccessRead
+			case 'w':
+				entry.Perm |= AccessWrite
+			case 'x':
+				entry.Perm |= AccessExecute
+			case '-':
+			default:
+				return nil, fmt.Errorf("acl entry %q: bad permissions", field)
+			}
+		}
+
+		acl = append(acl, entry)
+	}
+
+	return acl, acl.validate()
+}
+
+func (a ACL) String() string {
+	entries := make([]string, 0, len(a))
+
+	for _, entry := range a.sorted() {
+		id := ""
+		if entry.Tag == ACLUser || entry.Tag == ACLGroup {
+			id = strconv.Itoa(entry.Id)
+		}
+
+		perm := []byte("---")
+		if entry.Perm&AccessRead != 0 {
+			perm[0] = 'r'
+		}
+		if entry.Perm&AccessWrite != 0 {
+			perm[1] = 'w'
+		}
+		if entry.Perm&AccessExecute != 0 {
+			perm[2] = 'x'
+		}
+
+		entries = append(entries, aclTagNames[entry.Tag]+":"+id+":"+string(perm))
+	}
+
+	return strings.Join(entries, ",")
+}
+
+func (a ACL) sorted() ACL {
+	sorted := slices.Clone(a)
+
+	slices.SortFunc(sorted, func(x ACLEntry, y ACLEntry) int {
+		if x.Tag != y.Tag {
+			return int(x.Tag) - int(y.Tag)
+		}
+
+		return x.Id - y.Id
+	})
+
+	return sorted
+}
+
+func (a ACL) validate() error {
+	counts := make(map[ACLTag]int)
+	named := make(map[ACLEntry]bool)
+
+	for _, entry := range a {
+		if entry.Perm&^(AccessRead|AccessWrite|AccessExecute) != 0 {
+			return syscall.EINVAL
+		}
+
+		switch entry.Tag {
+		case ACLUser, ACLGroup:
+			key := ACLEntry{Tag: entry.Tag, Id: entry.Id}
+			if named[key] {
+				return syscall.EINVAL
+			}
+
+			named[key] = true
+		case ACLUserObj, ACLGroupObj, ACLMask, ACLOther:
+		default:
+			return syscall.EINVAL
+		}
+
+		counts[entry.Tag]++
+	}
+
+	if counts[ACLUserObj] != 1 || counts[ACLGroupObj] != 1 || counts[ACLOther] != 1 || counts[ACLMask] > 1 {
+		return syscall.EINVAL
+	}
+
+	if len(named) > 0 && counts[ACLMask] == 0 {
+		return syscall.EINVAL
+	}
+
+	return nil
+}
+
+// extended tells whether the ACL holds more than the permission bits do
+func (a ACL) extended() bool {
+	return len(a) > 3
+}
+
+func (a ACL) find(tag ACLTag) *ACLEntry {
+	for index := range a {
+		if a[index].Tag == tag {
+			return &a[index]
+		}
+	}
+
+	return nil
+}
+
+// groupClass is the entry standing in for the group permission bits
+func (a ACL) groupClass() *ACLEntry {
+	if mask := a.find(ACLMask); mask != nil {
+		return mask
+	}
+
+	return a.find(ACLGroupObj)
+}
+
+// perm returns the permission bits the ACL amounts to
+func (a ACL) perm() fs.FileMode {
+	return fs.FileMode(a.find(ACLUserObj).Perm<<6 | a.groupClass().Perm<<3 | a.find(ACLOther).Perm)
+}
+
+// withPerm returns the ACL with its owner, group class and other entries
+// combined with the permission bits of perm by combine
+func (a ACL) withPerm(perm fs.FileMode, combine func(current uint32, bits uint32) uint32) ACL {
+	updated := slices.Clone(a)
+
+	owner, group, other := updated.find(ACLUserObj), updated.groupClass(), updated.find(ACLOther)
+	owner.Perm = combine(owner.Perm, uint32(perm>>6)&7)
+	group.Perm = combine(group.Perm, uint32(perm>>3)&7)
+	other.Perm = combine(other.Perm, uint32(perm)&7)
+
+	return updated
+}
+
+func aclFromMode(mode fs.FileMode) ACL {
+	return ACL{
+		{Tag: ACLUserObj, Perm: uint32(mode>>6) & 7},
+		{Tag: ACLGroupObj, Perm: uint32(mode>>3) & 7},
+		{Tag: ACLOther, Perm: uint32(mode) & 7},
+	}
+}
+
+// permits runs the POSIX.1e access check algorithm for cred
+func (a ACL) permits(node interfaces.Node, f *FileSystem, mask uint32) bool {
+	limit := uint32(7)
+	if entry := a.find(ACLMask); entry != nil {
+		limit = entry.Perm
+	}
+
+	if node.GetUid() == f.cred.Uid {
+		return a.find(ACLUserObj).Perm&mask == mask
+	}
+
+	for _, entry := range a {
+		if entry.Tag == ACLUser && entry.Id == f.cred.Uid {
+			return entry.Perm&limit&mask == mask
+		}
+	}
+
+	matched := false
+	for _, entry := range a {
+		if entry.Tag == ACLGroupObj && f.inGroup(node.GetGid()) || entry.Tag == ACLGroup && f.inGroup(entry.Id) {
+			matched = true
+
+			if entry.Perm&limit&mask == mask {
+				return true
+			}
+		}
+	}
+
+	if matched {
+		return false
+	}
+
+	return a.find(ACLOther).Perm&mask == mask
+}
+
+func aclType(key string) (ACLType, bool) {
+	switch ACLType(key) {
+	case ACLAccess, ACLDefault:
+		return ACLType(key), true
+	}
+
+	return "", false
+}
+
+// GetACL returns the ACL of kind on id. A node without an access ACL has
+// one made up of its permission bits, a default ACL is nil when absent.
+func (f *FileSystem) GetACL(id uint64, kind ACLType) (ACL, error) {
+	if _, ok := aclType(string(kind)); !ok {
+		return nil, syscall.EINVAL
+	}
+
+	node, err := f.Open(id)
+	if err != nil {
+		return nil, err
+	}
+
+	acl, err := f.storedACL(node, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	if acl == nil && kind == ACLAccess {
+		return aclFromMode(node.GetMode()), nil
+	}
+
+	return acl, nil
+}
+
+// SetACL replaces the ACL of kind on id, an empty ACL removes it. Setting
+// the access ACL sets the permission bits along with it. Only the owner and
+// root may set ACLs, default ACLs only exist on directories.
+func (f *FileSystem) SetACL(id uint64, kind ACLType, acl ACL) error {
+	if isMountedId(id) || isGeneratedId(id) {
+		return syscall.EROFS
+	}
+
+	if _, ok := aclType(string(kind)); !ok {
+		return syscall.EINVAL
+	}
+
+	node, err := f.Open(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.checkFlags(id, FlagImmutable|FlagAppend)
+	if err != nil {
+		return err
+	}
+
+	err = f.requireOwner(node)
+	if err != nil {
+		return err
+	}
+
+	if kind == ACLDefault && !node.GetMode().IsDir() {
+		return syscall.EACCES
+	}
+
+	if len(acl) == 0 {
+		return f.removeStoredACL(node, kind)
+	}
+
+	err = acl.validate()
+	if err != nil {
+		return err
+	}
+
+	if kind == ACLDefault {
+		return f.storeACL(node, kind, acl)
+	}
+
+	if acl.extended() {
+		err = f.storeACL(node, kind, acl)
+	} else {
+		err = f.removeStoredACL(node, kind)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	node.SetMode(uint32(node.GetMode()&^fs.ModePerm | acl.perm()))
+
+	return f.Save(node)
+}
+
+// storedACL returns the ACL of kind stored for node, nil when there is none.
+// It is looked up on every check, another process may have set it.
+func (f *FileSystem) storedACL(node interfaces.Node, kind ACLType) (ACL, error) {
+	if isMountedId(node.GetId()) || isGeneratedId(node.GetId()) {
+		return nil, nil
+	}
+
+	attribute, err := f.nodeAttributeRepository.GetByNodeAndKey(node, string(kind))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if attribute == nil {
+		return nil, nil
+	}
+
+	return ParseACL(attribute.GetValue())
+}
+
+func (f *FileSystem) storeACL(node interfaces.Node, kind ACLType, acl ACL) error {
+	return f.setXattr(node.GetId(), string(kind), acl.String())
+}
+
+func (f *FileSystem) removeStoredACL(node interfaces.Node, kind ACLType) error {
+	attribute, err := f.nodeAttributeRepository.GetByNodeAndKey(node, string(kind))
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if attribute == nil {
+		return nil
+	}
+
+	return f.database.DeleteNodeAttribute(attribute.GetEntity().GetId())
+}
+
+// chmodACL brings a stored access ACL in line with new permission bits
+func (f *FileSystem) chmodACL(node interfaces.Node) error {
+	acl, err := f.storedACL(node, ACLAccess)
+	if err != nil || acl == nil {
+		return err
+	}
+
+	return f.storeACL(node, ACLAccess, acl.withPerm(node.GetMode().Perm(), func(_ uint32, bits uint32) uint32 { return bits }))
+}
+
+// inheritACL works out the ACLs of a node created in parent with mode. When
+// parent has a default ACL it takes the place of the umask: the new node
+// gets it as access ACL, limited by the permissions a plain create asks for,
+// and directories get it as their default ACL too.
+func (f *FileSystem) inheritACL(parent interfaces.Node, mode uint32) (uint32, ACL, ACL, error) {
+	fileMode := fs.FileMode(mode)
+	if fileMode&fs.ModeSymlink != 0 {
+		return mode, nil, nil, nil
+	}
+
+	inherited, err := f.storedACL(parent, ACLDefault)
+	if err != nil || inherited == nil {
+		return mode, nil, nil, err
+	}
+
+	requested := fs.FileMode(0666)
+	if fileMode.IsDir() {
+		requested = 0777
+	}
+
+	access := inherited.withPerm(requested, func(current uint32, bits uint32) uint32 { return current & bits })
+	mode = uint32(fileMode&^fs.ModePerm | access.perm())
+
+	if !fileMode.IsDir() {
+		inherited = nil
+	}
+
+	return mode, access, inherited, nil
+}
+
+// applyACL stores the ACLs inheritACL worked out on the node created as name in parent
+func (f *FileSystem) applyACL(parent interfaces.Node, name string, access ACL, inherited ACL) error {
+	if access == nil {
+		return nil
+	}
+
+	node, err := f.nodeRepository.GetByParentAndName(parent, name)
+	if err != nil {
+		return err
+	}
+
+	if access.extended() {
+		err = f.storeACL(node, ACLAccess, access)
+		if err != nil {
+			return err
+		}
+	}
+
+	if inherited != nil {
+		return f.storeACL(node, ACLDefault, inherited)
+	}
+
+	return nil
+}
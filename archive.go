@@ -0,0 +1,388 @@
+package filesystem
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// nodeFileInfo presents a node as an fs.FileInfo so the archive packages can build headers from it
+type nodeFileInfo struct {
+	node interfaces.Node
+	size int64
+}
+
+var _ fs.FileInfo = &nodeFileInfo{}
+
+func (i *nodeFileInfo) Name() string      { return i.node.GetName() }
+func (i *nodeFileInfo) Size() int64       { return i.size }
+func (i *nodeFileInfo) Mode() fs.FileMode { return i.node.GetMode() }
+func (i *nodeFileInfo) IsDir() bool       { return i.node.GetMode().IsDir() }
+func (i *nodeFileInfo) Sys() any          { return i.node }
+// ModTime falls back to the Unix epoch for nodes without a usable time, the
+// zero time does not fit in a zip header and ends up in 2049
+func (i *nodeFileInfo) ModTime() time.Time {
+	modTime, ok := nodeTime(i.node.GetModTime())
+	if !ok {
+		return time.Unix(0, 0).UTC()
+	}
+
+	return modTime
+}
+
+// archiveEntry is a node on its way into an archive
+type archiveEntry struct {
+	node interfaces.Node
+	// name is the slash separated path relative to the archived directory
+	name string
+	// target is the link target for symlinks, relative when it stays inside the archive
+	target     string
+	attributes map[string]string
+}
+
+// archiveEntries walks the subtree below root in lexical order, parents before
+// children. Names that cannot be a member name fail the walk with EINVAL.
+func (f *FileSystem) archiveEntries(root interfaces.Node, fn func(archiveEntry) error) error {
+	var walk func(parent interfaces.Node, prefix string) error
+	walk = func(parent interfaces.Node, prefix string) error {
+		children, err := f.ReadDir(parent.GetId())
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(children, func(i, j int) bool { return children[i].GetName() < children[j].GetName() })
+
+		for _, child := range children {
+			if !memberName(child.GetName()) {
+				return &fs.PathError{Op: "export", Path: child.GetPath(), Err: syscall.EINVAL}
+			}
+
+			entry := archiveEntry{node: child, name: prefix + child.GetName()}
+
+			if child.GetMode()&fs.ModeSymlink != 0 {
+				target, err := f.ReadLink(child.GetId())
+				if err == syscall.ENOENT {
+					continue
+				}
+
+				if err != nil {
+					return err
+				}
+
+				entry.target = archiveLinkTarget(root, child, target)
+			}
+
+			keys, err := f.ListXattr(child.GetId())
+			if err != nil {
+				return err
+			}
+
+			for _, key := range keys {
+				value, err := f.GetXattr(child.GetId(), key)
+				if err != nil {
+					return err
+				}
+
+				if entry.attributes == nil {
+					entry.attributes = make(map[string]string)
+				}
+
+				entry.attributes[key] = value
+			}
+
+			err = fn(entry)
+			if err != nil {
+				return err
+			}
+
+			if child.GetMode().IsDir() {
+				err = walk(child, entry.name+"/")
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return walk(root, "")
+}
+
+// memberName reports whether a node name can become one element of a member
+// name, others would extract somewhere else or outside the directory, which
+// cleanName keeps archives from doing on import
+func memberName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// archiveLinkTarget keeps links within the archived tree relative so they
+// survive being extracted elsewhere, other targets stay absolute VFS paths
+func archiveLinkTarget(root interfaces.Node, link interfaces.Node, target string) string {
+	if root.GetPath() != "/" && target != root.GetPath() && !strings.HasPrefix(target, root.GetPath()+"/") {
+		return target
+	}
+
+	from := splitPath(path.Dir(link.GetPath()))
+	to := splitPath(target)
+
+	common := 0
+	for common < len(from) && common < len(to) && from[common] == to[common] {
+		common++
+	}
+
+	parts := make([]string, 0, len(from)+len(to))
+	for range from[common:] {
+		parts = append(parts, "..")
+	}
+
+	parts = append(parts, to[common:]...)
+
+	if len(parts) == 0 {
+		return "."
+	}
+
+	return strings.Join(parts, "/")
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return nil
+	}
+
+	return strings.Split(name, "/")
+}
+
+// archiveMetadata is what an archive entry carries besides its content
+type archiveMetadata struct {
+	mode       fs.FileMode
+	uid        int
+	gid        int
+	modTime    time.Time
+	accessTime time.Time
+	attributes map[string]string
+}
+
+// archiveImport extracts entries below a directory, creating missing parents on the way
+type archiveImport struct {
+	fileSystem *FileSystem
+	root       interfaces.Node
+	nodes      map[string]interfaces.Node
+	symlinks   []archiveSymlink
+}
+
+type archiveSymlink struct {
+	name     string
+	target   string
+	metadata archiveMetadata
+}
+
+func (f *FileSystem) newArchiveImport(parentId uint64) (*archiveImport, error) {
+	root, err := f.getNode(parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !root.GetMode().IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+
+	return &archiveImport{
+		fileSystem: f,
+		root:       root,
+		nodes:      map[string]interfaces.Node{"": root},
+	}, nil
+}
+
+// cleanName rejects entry names that would escape the directory being extracted into
+func cleanName(name string) (string, error) {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", &fs.PathError{Op: "import", Path: name, Err: syscall.EINVAL}
+		}
+	}
+
+	return strings.Trim(path.Clean("/"+name), "/"), nil
+}
+
+func (i *archiveImport) directory(name string) (interfaces.Node, error) {
+	if node, ok := i.nodes[name]; ok {
+		return node, nil
+	}
+
+	parent, err := i.directory(parentName(name))
+	if err != nil {
+		return nil, err
+	}
+
+	node, _, err := i.fileSystem.LookupOrMkDir(parent.GetId(), path.Base(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if !node.GetMode().IsDir() {
+		return nil, &fs.PathError{Op: "import", Path: node.GetPath(), Err: syscall.ENOTDIR}
+	}
+
+	i.nodes[name] = node
+
+	return node, nil
+}
+
+func parentName(name string) string {
+	parent := path.Dir(name)
+	if parent == "." {
+		return ""
+	}
+
+	return parent
+}
+
+func (i *archiveImport) file(name string, content io.Reader, metadata archiveMetadata) error {
+	parent, err := i.directory(parentName(name))
+	if err != nil {
+		return err
+	}
+
+	node, _, err := i.fileSystem.LookupOrTouch(parent.GetId(), path.Base(name))
+	if err != nil {
+		return err
+	}
+
+	if !node.GetMode().IsRegular() {
+		return &fs.PathError{Op: "import", Path: node.GetPath(), Err: syscall.EISDIR}
+	}
+
+	handle, err := i.fileSystem.OpenFile(node.GetId())
+	if err != nil {
+		return err
+	}
+
+	err = handle.Truncate(0)
+	if err == nil {
+		_, err = io.CopyBuffer(handle, content, make([]byte, handleBufferSize))
+	}
+
+	closeErr := handle.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	i.nodes[name] = node
+
+	return i.metadata(node, metadata)
+}
+
+// hardlink copies the content of an entry extracted earlier, the VFS has no hard links
+func (i *archiveImport) hardlink(name string, target string, metadata archiveMetadata) error {
+	targetNode, ok := i.nodes[target]
+	if !ok || !targetNode.GetMode().IsRegular() {
+		return &fs.PathError{Op: "import", Path: name, Err: syscall.ENOENT}
+	}
+
+	handle, err := i.fileSystem.OpenFile(targetNode.GetId())
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	return i.file(name, handle, metadata)
+}
+
+func (i *archiveImport) symlink(name string, target string, metadata archiveMetadata) {
+	i.symlinks = append(i.symlinks, archiveSymlink{name: name, target: target, metadata: metadata})
+}
+
+// finish links the symlinks once every entry they might point at exists
+func (i *archiveImport) finish() error {
+	for _, symlink := range i.symlinks {
+		parent, err := i.directory(parentName(symlink.name))
+		if err != nil {
+			return err
+		}
+
+		target := symlink.target
+		if !path.IsAbs(target) {
+			target = path.Join(parent.GetPath(), target)
+		}
+
+		targetNode, err := i.fileSystem.LookupPath(target)
+		if err == syscall.ENOENT {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := path.Base(symlink.name)
+
+		existing, err := i.fileSystem.Lookup(parent.GetId(), name)
+		switch err {
+		case nil:
+			err = i.fileSystem.RemoveFile(existing.GetId())
+			if err != nil {
+				return err
+			}
+		case syscall.ENOENT:
+		default:
+			return err
+		}
+
+		err = i.fileSystem.Link(targetNode.GetId(), name, parent.GetId())
+		if err != nil {
+			return err
+		}
+
+		node, err := i.fileSystem.Lookup(parent.GetId(), name)
+		if err != nil {
+			return err
+		}
+
+		err = i.metadata(node, symlink.metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *archiveImport) metadata(node interfaces.Node, metadata archiveMetadata) error {
+	accessTime := metadata.accessTime
+	if accessTime.IsZero() {
+		accessTime = metadata.modTime
+	}
+
+	node.SetMode(uint32(metadata.mode))
+	node.SetUid(metadata.uid)
+	node.SetGid(metadata.gid)
+	node.SetModTime(metadata.modTime.UTC().Format(TimeFormat))
+	node.SetAccessTime(accessTime.UTC().Format(TimeFormat))
+	node.SetCreateTime(time.Now().UTC().Format(TimeFormat))
+
+	err := i.fileSystem.Save(node)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range metadata.attributes {
+		err := i.fileSystem.SetXattr(node.GetId(), key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
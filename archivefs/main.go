@@ -0,0 +1,386 @@
+// Package archivefs presents a zip or tar archive as a read-only file system.
+// The archive is indexed once, contents are read from it on demand.
+package archivefs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sushydev/vfs_go/interfaces"
+	database_node "github.com/sushydev/vfs_go/internal/database/node"
+	"github.com/sushydev/vfs_go/internal/filesystem/node"
+)
+
+// IdBits is the number of bits entry ids are confined to, the root is always 0
+const IdBits = 40
+
+const idMask = 1<<IdBits - 1
+
+type entry struct {
+	id       uint64
+	parentId uint64
+	name     string
+	path     string
+	mode     fs.FileMode
+	size     int64
+	uid      int
+	gid      int
+	modTime  time.Time
+	target   string
+	children []uint64
+	open     func() (io.ReadCloser, error)
+}
+
+type FileSystem struct {
+	entries map[uint64]*entry
+	paths   map[string]uint64
+}
+
+var _ interfaces.FileSystem = &FileSystem{}
+
+// New indexes the archive in r, the format is detected from its magic number
+func New(r io.ReaderAt, size int64) (*FileSystem, error) {
+	f := &FileSystem{
+		entries: map[uint64]*entry{0: {name: "", path: "/", mode: fs.ModeDir | 0555}},
+		paths:   map[string]uint64{"/": 0},
+	}
+
+	magic := make([]byte, 262)
+	n, _ := r.ReadAt(magic, 0)
+	magic = magic[:n]
+
+	var err error
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		err = f.indexZip(r, size)
+	case len(magic) >= 262 && bytes.HasPrefix(magic[257:], []byte("ustar")):
+		err = f.indexTar(r, size)
+	default:
+		return nil, errors.New("archivefs: unsupported archive format")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range f.entries {
+		sort.Slice(entry.children, func(i, j int) bool {
+			return f.entries[entry.children[i]].name < f.entries[entry.children[j]].name
+		})
+	}
+
+	return f, nil
+}
+
+// entryId derives the id from the path so it stays the same across reopens,
+// collisions are resolved by probing in the order entries are added
+func (f *FileSystem) entryId(name string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+
+	id := hash.Sum64() & idMask
+	for id == 0 || f.entries[id] != nil {
+		id = (id + 1) & idMask
+	}
+
+	return id
+}
+
+// add registers an entry and any parent directory the archive does not list itself
+func (f *FileSystem) add(name string, template entry) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return
+	}
+
+	parentId, ok := f.paths[path.Dir(name)]
+	if !ok {
+		f.add(path.Dir(name), entry{mode: fs.ModeDir | 0555, modTime: template.modTime})
+		parentId = f.paths[path.Dir(name)]
+	}
+
+	if id, ok := f.paths[name]; ok {
+		// An explicit directory entry listed after one of its children
+		existing := f.entries[id]
+		existing.mode, existing.uid, existing.gid, existing.modTime = template.mode, template.uid, template.gid, template.modTime
+		return
+	}
+
+	added := template
+	added.id = f.entryId(name)
+	added.parentId = parentId
+	added.name = path.Base(name)
+	added.path = name
+
+	f.entries[added.id] = &added
+	f.paths[name] = added.id
+	f.entries[parentId].children = append(f.entries[parentId].children, added.id)
+}
+
+func (f *FileSystem) indexZip(r io.ReaderAt, size int64) error {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reader.File {
+		if strings.Contains("/"+file.Name+"/", "/../") {
+			continue
+		}
+
+		template := entry{
+			mode:    file.Mode(),
+			size:    int64(file.UncompressedSize64),
+			modTime: file.Modified,
+			open:    file.Open,
+		}
+
+		if template.mode&fs.ModeSymlink != 0 {
+			content, err := file.Open()
+			if err != nil {
+				return err
+			}
+
+			target, err := io.ReadAll(io.LimitReader(content, 4096))
+			content.Close()
+			if err != nil {
+				return err
+			}
+
+			template.target = string(target)
+		}
+
+		f.add(file.Name, template)
+	}
+
+	return nil
+}
+
+// countingReader tracks the offset tar has consumed, tar reads exactly up to
+// the data of an entry so the offset is where that data starts. It seeks so
+// the data of entries is skipped rather than read while indexing.
+type countingReader struct {
+	reader *io.SectionReader
+	offset int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+
+	return n, err
+}
+
+func (r *countingReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := r.reader.Seek(offset, whence)
+	if err == nil {
+		r.offset = position
+	}
+
+	return position, err
+}
+
+func (f *FileSystem) indexTar(r io.ReaderAt, size int64) error {
+	counter := &countingReader{reader: io.NewSectionReader(r, 0, size)}
+	reader := tar.NewReader(counter)
+
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if strings.Contains("/"+header.Name+"/", "/../") {
+			continue
+		}
+
+		template := entry{
+			mode:    header.FileInfo().Mode(),
+			size:    header.Size,
+			uid:     header.Uid,
+			gid:     header.Gid,
+			modTime: header.ModTime,
+			target:  header.Linkname,
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			section := io.NewSectionReader(r, counter.offset, header.Size)
+			template.open = func() (io.ReadCloser, error) {
+				return io.NopCloser(io.NewSectionReader(section, 0, section.Size())), nil
+			}
+		case tar.TypeLink:
+			// Hard links share the data of an entry indexed earlier
+			if id, ok := f.paths[path.Clean("/"+header.Linkname)]; ok {
+				target := f.entries[id]
+				template.mode, template.size, template.open, template.target = target.mode, target.size, target.open, ""
+			}
+		case tar.TypeDir, tar.TypeSymlink:
+		default:
+			continue
+		}
+
+		f.add(header.Name, template)
+	}
+}
+
+func (f *FileSystem) get(id uint64) (*entry, error) {
+	entry, ok := f.entries[id]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	return entry, nil
+}
+
+func (f *FileSystem) toNode(entry *entry) interfaces.Node {
+	timestamp := entry.modTime.UTC().Format(time.RFC3339)
+
+	entity, _ := database_node.New(
+		int64(entry.id),
+		entry.name,
+		int64(entry.parentId),
+		entry.path,
+		int64(uint32(entry.mode)),
+		entry.uid,
+		entry.gid,
+		timestamp,
+		timestamp,
+		timestamp,
+		entry.size,
+		0,
+	)
+
+	node, _ := node.New(entity)
+
+	return node
+}
+
+func (f *FileSystem) Root() (interfaces.Node, error) {
+	return f.toNode(f.entries[0]), nil
+}
+
+func (f *FileSystem) Open(id uint64) (interfaces.Node, error) {
+	entry, err := f.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.toNode(entry), nil
+}
+
+func (f *FileSystem) ReadDir(parentId uint64) ([]interfaces.Node, error) {
+	parent, err := f.get(parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !parent.mode.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+
+	nodes := make([]interfaces.Node, 0, len(parent.children))
+	for _, id := range parent.children {
+		nodes = append(nodes, f.toNode(f.entries[id]))
+	}
+
+	return nodes, nil
+}
+
+func (f *FileSystem) Lookup(parentId uint64, name string) (interfaces.Node, error) {
+	parent, err := f.get(parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !parent.mode.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+
+	id, ok := f.paths[path.Join(parent.path, name)]
+	if !ok || strings.Contains(name, "/") {
+		return nil, syscall.ENOENT
+	}
+
+	return f.toNode(f.entries[id]), nil
+}
+
+func (f *FileSystem) MkDir(parentId uint64, name string) error {
+	return syscall.EROFS
+}
+
+// Size returns the uncompressed size of a file
+func (f *FileSystem) Size(id uint64) (int64, error) {
+	entry, err := f.get(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return entry.size, nil
+}
+
+// OpenReader streams the content of a file straight from the archive
+func (f *FileSystem) OpenReader(id uint64) (io.ReadCloser, error) {
+	entry, err := f.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.mode.IsDir() {
+		return nil, syscall.EISDIR
+	}
+
+	if entry.open == nil {
+		return nil, syscall.EINVAL
+	}
+
+	return entry.open()
+}
+
+func (f *FileSystem) ReadFile(id uint64) ([]byte, error) {
+	reader, err := f.OpenReader(id)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// ReadLink returns the absolute path of the target within the archive
+func (f *FileSystem) ReadLink(id uint64) (string, error) {
+	entry, err := f.get(id)
+	if err != nil {
+		return "", err
+	}
+
+	if entry.mode&fs.ModeSymlink == 0 {
+		return "", syscall.EINVAL
+	}
+
+	target := entry.target
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(entry.path), target)
+	}
+
+	if _, ok := f.paths[path.Clean(target)]; !ok {
+		return "", syscall.ENOENT
+	}
+
+	return path.Clean(target), nil
+}
@@ -0,0 +1,491 @@
+// Package cache is a read-through block cache in front of a content provider.
+// Content is fetched in fixed size blocks that are kept in memory or in a
+// cache directory, the least recently used blocks are evicted once the cache
+// outgrows its size limit. Sequential reads fetch the blocks that follow ahead
+// of time.
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+const (
+	DefaultBlockSize = 1 << 20
+	DefaultMaxSize   = 256 << 20
+	DefaultInfoTTL   = time.Second
+)
+
+// blockPrefix starts the name of every block file, the cache only adopts and
+// deletes files in its directory that carry it
+const blockPrefix = "block-"
+
+type Options struct {
+	// BlockSize is the unit content is fetched and cached in, DefaultBlockSize when zero
+	BlockSize int64
+	// Directory keeps blocks on disk and across restarts, blocks are kept in memory when empty
+	Directory string
+	// MaxSize is the number of bytes cached before blocks are evicted, DefaultMaxSize when zero
+	MaxSize int64
+	// ReadAhead is the number of blocks fetched ahead of sequential reads
+	ReadAhead int
+	// InfoTTL is how long what the provider said about a key is trusted
+	// before it is asked again, DefaultInfoTTL when zero. A negative InfoTTL
+	// asks the provider on every read.
+	InfoTTL time.Duration
+}
+
+// Metrics counts what the cache did since it was created
+type Metrics struct {
+	Hits       uint64
+	Misses     uint64
+	ReadAheads uint64
+	Evictions  uint64
+	Blocks     int
+	Bytes      int64
+}
+
+type block struct {
+	name    string
+	size    int64
+	content []byte
+}
+
+// info is what the provider said about a key and when
+type info struct {
+	content interfaces.ContentInfo
+	fetched time.Time
+}
+
+// fetch is a block being read from the provider, concurrent readers wait for it
+type fetch struct {
+	done    chan struct{}
+	content []byte
+	err     error
+}
+
+type Cache struct {
+	provider interfaces.ContentProvider
+	options  Options
+
+	mutex    sync.Mutex
+	lru      *list.List
+	blocks   map[string]*list.Element
+	fetches  map[string]*fetch
+	infos    map[string]info
+	previous map[string]int64
+	size     int64
+	metrics  Metrics
+	// recent holds the content of the block read last from disk, small reads tend to hit it repeatedly
+	recent  *block
+	pending sync.WaitGroup
+}
+
+var _ interfaces.ContentProvider = &Cache{}
+
+// New wraps provider, blocks left in the cache directory by an earlier run are reused
+func New(provider interfaces.ContentProvider, options Options) (*Cache, error) {
+	if options.BlockSize <= 0 {
+		options.BlockSize = DefaultBlockSize
+	}
+
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultMaxSize
+	}
+
+	if options.InfoTTL == 0 {
+		options.InfoTTL = DefaultInfoTTL
+	}
+
+	c := &Cache{
+		provider: provider,
+		options:  options,
+		lru:      list.New(),
+		blocks:   make(map[string]*list.Element),
+		fetches:  make(map[string]*fetch),
+		infos:    make(map[string]info),
+		previous: make(map[string]int64),
+	}
+
+	if options.Directory != "" {
+		err := c.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// load picks up the blocks in the cache directory, oldest first so they are
+// evicted first. Files the cache did not write are left alone.
+func (c *Cache) load() error {
+	err := os.MkdirAll(c.options.Directory, 0700)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(c.options.Directory)
+	if err != nil {
+		return err
+	}
+
+	type cached struct {
+		name string
+		info os.FileInfo
+	}
+
+	var files []cached
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), blockPrefix) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, cached{name: entry.Name(), info: info})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].info.ModTime().Before(files[j].info.ModTime()) })
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, file := range files {
+		c.blocks[file.name] = c.lru.PushFront(&block{name: file.name, size: file.info.Size()})
+		c.size += file.info.Size()
+	}
+
+	c.evict()
+
+	return nil
+}
+
+func (c *Cache) Size(key string) (int64, error) {
+	info, err := c.info(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size, nil
+}
+
+// Stat always asks the provider, once the version changes reads go to new
+// blocks and the ones of the old version age out of the cache
+func (c *Cache) Stat(key string) (interfaces.ContentInfo, error) {
+	content, err := c.provider.Stat(key)
+	if err != nil {
+		return interfaces.ContentInfo{}, err
+	}
+
+	c.mutex.Lock()
+	c.infos[key] = info{content: content, fetched: time.Now()}
+	c.mutex.Unlock()
+
+	return content, nil
+}
+
+// Invalidate forgets what is known about key so the next read fetches it again
+func (c *Cache) Invalidate(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.infos, key)
+	delete(c.previous, key)
+}
+
+// info returns what the provider said about key, asking it again once that
+// is older than InfoTTL
+func (c *Cache) info(key string) (interfaces.ContentInfo, error) {
+	c.mutex.Lock()
+	known, ok := c.infos[key]
+	c.mutex.Unlock()
+
+	if ok && time.Since(known.fetched) < c.options.InfoTTL {
+		return known.content, nil
+	}
+
+	return c.Stat(key)
+}
+
+func (c *Cache) ReadAt(key string, p []byte, offset int64) (int, error) {
+	info, err := c.info(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("cache: negative offset %d", offset)
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if offset >= info.Size {
+		return 0, io.EOF
+	}
+
+	end := min(offset+int64(len(p)), info.Size)
+	first, last := offset/c.options.BlockSize, (end-1)/c.options.BlockSize
+
+	c.mutex.Lock()
+	previous, seen := c.previous[key]
+	c.previous[key] = last
+	c.mutex.Unlock()
+
+	n := 0
+	for index := first; index <= last; index++ {
+		content, err := c.block(key, info, index, false)
+		if err != nil {
+			return n, err
+		}
+
+		start := offset + int64(n) - index*c.options.BlockSize
+		if start >= int64(len(content)) {
+			return n, io.ErrUnexpectedEOF
+		}
+
+		n += copy(p[n:], content[start:])
+	}
+
+	if seen && (first == previous || first == previous+1) {
+		c.readAhead(key, info, last)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (c *Cache) readAhead(key string, info interfaces.ContentInfo, last int64) {
+	blocks := (info.Size + c.options.BlockSize - 1) / c.options.BlockSize
+
+	for index := last + 1; index <= last+int64(c.options.ReadAhead) && index < blocks; index++ {
+		name := blockName(key, info, index)
+
+		c.mutex.Lock()
+		_, cached := c.blocks[name]
+		_, fetching := c.fetches[name]
+		c.mutex.Unlock()
+
+		if cached || fetching {
+			continue
+		}
+
+		c.pending.Add(1)
+		go func() {
+			defer c.pending.Done()
+			c.block(key, info, index, true)
+		}()
+	}
+}
+
+// Wait blocks until reads ahead that are still running have finished
+func (c *Cache) Wait() {
+	c.pending.Wait()
+}
+
+// blockName identifies a block by key, version and index so a new version never reads stale blocks
+func blockName(key string, info interfaces.ContentInfo, index int64) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d", key, info.Version, info.Size, index)))
+
+	return blockPrefix + hex.EncodeToString(hash[:])
+}
+
+// blockSize is the length block index of a version of key has
+func (c *Cache) blockSize(info interfaces.ContentInfo, index int64) int64 {
+	return min(c.options.BlockSize, info.Size-index*c.options.BlockSize)
+}
+
+func (c *Cache) block(key string, info interfaces.ContentInfo, index int64, ahead bool) ([]byte, error) {
+	name := blockName(key, info, index)
+
+	c.mutex.Lock()
+	if element, ok := c.blocks[name]; ok {
+		if ahead {
+			c.mutex.Unlock()
+			return nil, nil
+		}
+
+		c.lru.MoveToFront(element)
+		c.metrics.Hits++
+		c.mutex.Unlock()
+
+		content, err := c.read(element.Value.(*block))
+		if err == nil && int64(len(content)) == c.blockSize(info, index) {
+			return content, nil
+		}
+
+		// The file went missing or was cut short behind our back, fetch the block again
+		c.mutex.Lock()
+		c.remove(name)
+	}
+
+	if pending, ok := c.fetches[name]; ok {
+		c.mutex.Unlock()
+		<-pending.done
+
+		return pending.content, pending.err
+	}
+
+	pending := &fetch{done: make(chan struct{})}
+	c.fetches[name] = pending
+
+	if ahead {
+		c.metrics.ReadAheads++
+	} else {
+		c.metrics.Misses++
+	}
+	c.mutex.Unlock()
+
+	pending.content, pending.err = c.fetch(key, info, index)
+	if pending.err == nil {
+		pending.err = c.store(name, pending.content)
+	}
+
+	c.mutex.Lock()
+	delete(c.fetches, name)
+	c.mutex.Unlock()
+	close(pending.done)
+
+	return pending.content, pending.err
+}
+
+func (c *Cache) fetch(key string, info interfaces.ContentInfo, index int64) ([]byte, error) {
+	offset := index * c.options.BlockSize
+	content := make([]byte, c.blockSize(info, index))
+
+	n, err := c.provider.ReadAt(key, content, offset)
+	if err == io.EOF && n == len(content) {
+		err = nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+func (c *Cache) read(cached *block) ([]byte, error) {
+	if c.options.Directory == "" {
+		return cached.content, nil
+	}
+
+	c.mutex.Lock()
+	recent := c.recent
+	c.mutex.Unlock()
+
+	if recent != nil && recent.name == cached.name {
+		return recent.content, nil
+	}
+
+	content, err := os.ReadFile(filepath.Join(c.options.Directory, cached.name))
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.recent = &block{name: cached.name, size: cached.size, content: content}
+	c.mutex.Unlock()
+
+	return content, nil
+}
+
+func (c *Cache) store(name string, content []byte) error {
+	cached := &block{name: name, size: int64(len(content))}
+
+	if c.options.Directory == "" {
+		cached.content = content
+	} else {
+		file, err := os.CreateTemp(c.options.Directory, ".block-")
+		if err != nil {
+			return err
+		}
+
+		_, err = file.Write(content)
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+
+		if err == nil {
+			err = os.Rename(file.Name(), filepath.Join(c.options.Directory, name))
+		}
+
+		if err != nil {
+			os.Remove(file.Name())
+			return err
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.blocks[name]; ok {
+		return nil
+	}
+
+	c.blocks[name] = c.lru.PushFront(cached)
+	c.size += cached.size
+	c.evict()
+
+	return nil
+}
+
+// evict drops least recently used blocks until the cache fits its size limit again
+func (c *Cache) evict() {
+	for c.size > c.options.MaxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back().Value.(*block).name)
+		c.metrics.Evictions++
+	}
+}
+
+func (c *Cache) remove(name string) {
+	element, ok := c.blocks[name]
+	if !ok {
+		return
+	}
+
+	cached := element.Value.(*block)
+
+	c.lru.Remove(element)
+	delete(c.blocks, name)
+	c.size -= cached.size
+
+	if c.recent != nil && c.recent.name == name {
+		c.recent = nil
+	}
+
+	if c.options.Directory != "" {
+		os.Remove(filepath.Join(c.options.Directory, name))
+	}
+}
+
+func (c *Cache) Metrics() Metrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	metrics := c.metrics
+	metrics.Blocks = c.lru.Len()
+	metrics.Bytes = c.size
+
+	return metrics
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sushydev/vfs_go"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: vfs fsck [-repair] [-skip-content] [-json] <database>\n")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "fsck":
+		os.Exit(fsck(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func fsck(args []string) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "repair the problems that were found")
+	skipContent := flags.Bool("skip-content", false, "do not verify content hashes")
+	asJson := flags.Bool("json", false, "print the report as json")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	fileSystem, err := filesystem.New(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 8
+	}
+	defer fileSystem.Close()
+
+	report, err := fileSystem.Fsck(filesystem.FsckOptions{
+		Repair:      *repair,
+		SkipContent: *skipContent,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 8
+	}
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		for _, finding := range report.Findings {
+			status := "found"
+			switch {
+			case finding.Repaired:
+				status = "repaired"
+			case finding.Notice:
+				status = "notice"
+			}
+
+			fmt.Printf("%-8s %-20s %6d %s: %s\n", status, finding.Kind, finding.Id, finding.Path, finding.Message)
+		}
+
+		fmt.Printf("%d nodes checked, %d findings\n", report.Nodes, len(report.Findings))
+	}
+
+	// Same convention as e2fsck: 1 when errors were corrected, 4 when errors remain
+	// and 8 when the check itself failed. Notices do not count as errors.
+	switch {
+	case !report.Clean():
+		return 4
+	case report.Problems() > 0:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/interfaces"
+	"github.com/sushydev/vfs_go/service"
+)
+
+func parse(name string, args []string, setup func(flags *flag.FlagSet), min int, max int) ([]string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	if setup != nil {
+		setup(flags)
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if flags.NArg() < min || flags.NArg() > max {
+		return nil, usageError(name)
+	}
+
+	return flags.Args(), nil
+}
+
+func usageError(name string) error {
+	for _, usage := range usages {
+		if strings.HasPrefix(usage, name+" ") {
+			return fmt.Errorf("usage: vfsctl %s", usage)
+		}
+	}
+
+	return fmt.Errorf("usage: vfsctl %s", name)
+}
+
+func argOr(args []string, index int, fallback string) string {
+	if index < len(args) {
+		return args[index]
+	}
+
+	return fallback
+}
+
+func (c *ctl) readDir(node interfaces.Node) ([]interfaces.Node, error) {
+	children, err := c.fileSystem.ReadDir(node.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].GetName() < children[j].GetName() })
+
+	return children, nil
+}
+
+func ls(c *ctl, args []string) error {
+	var long bool
+
+	args, err := parse("ls", args, func(flags *flag.FlagSet) {
+		flags.BoolVar(&long, "l", false, "long listing")
+	}, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(argOr(args, 0, "/"), true)
+	if err != nil {
+		return err
+	}
+
+	nodes := []interfaces.Node{node}
+	if node.GetMode().IsDir() {
+		nodes, err = c.readDir(node)
+		if err != nil {
+			return err
+		}
+	}
+
+	infos := make([]nodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		info, err := c.info(node)
+		if err != nil {
+			return err
+		}
+
+		infos = append(infos, info)
+	}
+
+	c.print(infos, func() {
+		for _, info := range infos {
+			name := info.Name
+			if info.Target != "" {
+				name += " -> " + info.Target
+			}
+
+			if long {
+				fmt.Printf("%s %5d %5d %10d %s %s\n", info.Mode, info.Uid, info.Gid, info.Size, info.ModTime, name)
+			} else {
+				fmt.Println(name)
+			}
+		}
+	})
+
+	return nil
+}
+
+type treeEntry struct {
+	nodeInfo
+	Children []treeEntry `json:"children,omitempty"`
+}
+
+func (c *ctl) tree(node interfaces.Node) (treeEntry, error) {
+	info, err := c.info(node)
+	if err != nil {
+		return treeEntry{}, err
+	}
+
+	entry := treeEntry{nodeInfo: info}
+	if !node.GetMode().IsDir() {
+		return entry, nil
+	}
+
+	children, err := c.readDir(node)
+	if err != nil {
+		return entry, err
+	}
+
+	for _, child := range children {
+		childEntry, err := c.tree(child)
+		if err != nil {
+			return entry, err
+		}
+
+		entry.Children = append(entry.Children, childEntry)
+	}
+
+	return entry, nil
+}
+
+func printTree(entry treeEntry, prefix string) {
+	for index, child := range entry.Children {
+		branch, indent := "├── ", "│   "
+		if index == len(entry.Children)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		name := child.Name
+		if child.Target != "" {
+			name += " -> " + child.Target
+		}
+
+		fmt.Println(prefix + branch + name)
+		printTree(child, prefix+indent)
+	}
+}
+
+func tree(c *ctl, args []string) error {
+	args, err := parse("tree", args, nil, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(argOr(args, 0, "/"), true)
+	if err != nil {
+		return err
+	}
+
+	entry, err := c.tree(node)
+	if err != nil {
+		return err
+	}
+
+	c.print(entry, func() {
+		fmt.Println(entry.Path)
+		printTree(entry, "")
+	})
+
+	return nil
+}
+
+func stat(c *ctl, args []string) error {
+	args, err := parse("stat", args, nil, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(args[0], false)
+	if err != nil {
+		return err
+	}
+
+	info, err := c.info(node)
+	if err != nil {
+		return err
+	}
+
+	c.print(info, func() {
+		fmt.Printf("  Path: %s\n", info.Path)
+		if info.Target != "" {
+			fmt.Printf("Target: %s\n", info.Target)
+		}
+		fmt.Printf("    Id: %d\n", info.Id)
+		fmt.Printf("  Type: %s\n", info.Type)
+		fmt.Printf("  Size: %d\n", info.Size)
+		fmt.Printf("  Mode: %s\n", info.Mode)
+		fmt.Printf("   Uid: %d\n", info.Uid)
+		fmt.Printf("   Gid: %d\n", info.Gid)
+		fmt.Printf("Access: %s\n", info.AccessTime)
+		fmt.Printf("Modify: %s\n", info.ModTime)
+		fmt.Printf("Create: %s\n", info.CreateTime)
+	})
+
+	return nil
+}
+
+func cat(c *ctl, args []string) error {
+	args, err := parse("cat", args, nil, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(args[0], true)
+	if err != nil {
+		return err
+	}
+
+	content, err := c.fileSystem.ReadFile(node.GetId())
+	if err != nil {
+		return err
+	}
+
+	c.print(map[string]string{"path": node.GetPath(), "content": string(content)}, func() {
+		os.Stdout.Write(content)
+	})
+
+	return nil
+}
+
+func put(c *ctl, args []string) error {
+	args, err := parse("put", args, nil, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+	if args[0] == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	parent, name, err := c.resolveParent(args[1])
+	if err != nil {
+		return err
+	}
+
+	node, err := service.FindOrCreateFile(c.fileSystem, parent.GetId(), name)
+	if err != nil {
+		return err
+	}
+
+	written, err := c.fileSystem.WriteFile(node.GetId(), content)
+	if err != nil {
+		return err
+	}
+
+	c.print(map[string]any{"id": node.GetId(), "path": node.GetPath(), "written": written}, func() {})
+
+	return nil
+}
+
+func get(c *ctl, args []string) error {
+	args, err := parse("get", args, nil, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(args[0], true)
+	if err != nil {
+		return err
+	}
+
+	content, err := c.fileSystem.ReadFile(node.GetId())
+	if err != nil {
+		return err
+	}
+
+	if args[1] == "-" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+
+	err = os.WriteFile(args[1], content, 0644)
+	if err != nil {
+		return err
+	}
+
+	c.print(map[string]any{"path": node.GetPath(), "file": args[1], "read": len(content)}, func() {})
+
+	return nil
+}
+
+func mkdir(c *ctl, args []string) error {
+	var parents bool
+
+	args, err := parse("mkdir", args, func(flags *flag.FlagSet) {
+		flags.BoolVar(&parents, "p", false, "create missing parents, no error if the directory exists")
+	}, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var node interfaces.Node
+
+	if parents {
+		node, err = c.fileSystem.Root()
+		if err != nil {
+			return err
+		}
+
+		for _, component := range strings.Split(args[0], "/") {
+			if component == "" || component == "." {
+				continue
+			}
+
+			node, err = service.FindOrCreateDirectory(c.fileSystem, node.GetId(), component)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		parent, name, err := c.resolveParent(args[0])
+		if err != nil {
+			return err
+		}
+
+		err = c.fileSystem.MkDir(parent.GetId(), name)
+		if err != nil {
+			return err
+		}
+
+		node, err = c.fileSystem.Lookup(parent.GetId(), name)
+		if err != nil {
+			return err
+		}
+	}
+
+	info, err := c.info(node)
+	if err != nil {
+		return err
+	}
+
+	c.print(info, func() {})
+
+	return nil
+}
+
+func (c *ctl) remove(node interfaces.Node, recursive bool) error {
+	if !node.GetMode().IsDir() {
+		return c.fileSystem.RemoveFile(node.GetId())
+	}
+
+	if recursive {
+		children, err := c.fileSystem.ReadDir(node.GetId())
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			err := c.remove(child, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.fileSystem.RmDir(node.GetId())
+}
+
+func rm(c *ctl, args []string) error {
+	var recursive bool
+
+	args, err := parse("rm", args, func(flags *flag.FlagSet) {
+		flags.BoolVar(&recursive, "r", false, "remove directories and their contents")
+	}, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(args[0], false)
+	if err != nil {
+		return err
+	}
+
+	if node.GetId() == 0 {
+		return fmt.Errorf("refusing to remove the root directory")
+	}
+
+	if node.GetMode().IsDir() && !recursive {
+		return fmt.Errorf("%s: %w, use -r", args[0], syscall.EISDIR)
+	}
+
+	err = c.remove(node, recursive)
+	if err != nil {
+		return err
+	}
+
+	c.print(map[string]any{"id": node.GetId(), "path": node.GetPath()}, func() {})
+
+	return nil
+}
+
+func mv(c *ctl, args []string) error {
+	args, err := parse("mv", args, nil, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(args[0], false)
+	if err != nil {
+		return err
+	}
+
+	// Moving onto an existing directory moves the node into it
+	parent, name, err := c.resolveParent(args[1])
+	if err != nil {
+		return err
+	}
+
+	destination, err := c.resolve(args[1], true)
+	switch {
+	case err == nil && destination.GetMode().IsDir():
+		parent, name = destination, node.GetName()
+	case err == nil:
+		return fmt.Errorf("%s: %w", args[1], syscall.EEXIST)
+	}
+
+	err = c.fileSystem.Rename(node.GetId(), name, parent.GetId())
+	if err != nil {
+		return err
+	}
+
+	moved, err := c.fileSystem.Open(node.GetId())
+	if err != nil {
+		return err
+	}
+
+	info, err := c.info(moved)
+	if err != nil {
+		return err
+	}
+
+	c.print(info, func() {})
+
+	return nil
+}
+
+func ln(c *ctl, args []string) error {
+	var symbolic bool
+
+	args, err := parse("ln", args, func(flags *flag.FlagSet) {
+		flags.BoolVar(&symbolic, "s", false, "make a symbolic link")
+	}, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	if !symbolic {
+		return fmt.Errorf("hard links are not supported, use -s")
+	}
+
+	target, err := c.resolve(args[0], false)
+	if err != nil {
+		return err
+	}
+
+	parent, name, err := c.resolveParent(args[1])
+	if err != nil {
+		return err
+	}
+
+	err = c.fileSystem.Link(target.GetId(), name, parent.GetId())
+	if err != nil {
+		return err
+	}
+
+	node, err := c.fileSystem.Lookup(parent.GetId(), name)
+	if err != nil {
+		return err
+	}
+
+	info, err := c.info(node)
+	if err != nil {
+		return err
+	}
+
+	c.print(info, func() {})
+
+	return nil
+}
+
+func readlink(c *ctl, args []string) error {
+	args, err := parse("readlink", args, nil, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(args[0], false)
+	if err != nil {
+		return err
+	}
+
+	target, err := c.fileSystem.ReadLink(node.GetId())
+	if err != nil {
+		return err
+	}
+
+	c.print(map[string]string{"path": node.GetPath(), "target": target}, func() {
+		fmt.Println(target)
+	})
+
+	return nil
+}
+
+func xattr(c *ctl, args []string) error {
+	args, err := parse("xattr", args, nil, 2, 4)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(args[1], false)
+	if err != nil {
+		return err
+	}
+
+	wants := map[string]int{"list": 2, "get": 3, "set": 4, "rm": 3}
+	if count, ok := wants[args[0]]; !ok || count != len(args) {
+		return usageError("xattr")
+	}
+
+	switch args[0] {
+	case "list":
+		keys, err := c.fileSystem.ListXattr(node.GetId())
+		if err != nil {
+			return err
+		}
+
+		attributes := make(map[string]string, len(keys))
+		for _, key := range keys {
+			value, err := c.fileSystem.GetXattr(node.GetId(), key)
+			if err != nil {
+				return err
+			}
+
+			attributes[key] = value
+		}
+
+		c.print(attributes, func() {
+			for _, key := range keys {
+				fmt.Printf("%s=%q\n", key, attributes[key])
+			}
+		})
+	case "get":
+		value, err := c.fileSystem.GetXattr(node.GetId(), args[2])
+		if err != nil {
+			return err
+		}
+
+		c.print(map[string]string{args[2]: value}, func() {
+			fmt.Println(value)
+		})
+	case "set":
+		err := c.fileSystem.SetXattr(node.GetId(), args[2], args[3])
+		if err != nil {
+			return err
+		}
+
+		c.print(map[string]string{args[2]: args[3]}, func() {})
+	case "rm":
+		err := c.fileSystem.RemoveXattr(node.GetId(), args[2])
+		if err != nil {
+			return err
+		}
+
+		c.print(map[string]string{}, func() {})
+	}
+
+	return nil
+}
+
+type diskUsage struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+	Files int    `json:"files"`
+}
+
+func (c *ctl) du(node interfaces.Node, report func(diskUsage)) (diskUsage, error) {
+	total := diskUsage{Path: node.GetPath()}
+
+	if !node.GetMode().IsDir() {
+		if nodeType(node) == "file" {
+			size, err := c.size(node)
+			if err != nil {
+				return total, err
+			}
+
+			total.Bytes, total.Files = size, 1
+		}
+
+		return total, nil
+	}
+
+	children, err := c.readDir(node)
+	if err != nil {
+		return total, err
+	}
+
+	for _, child := range children {
+		childUsage, err := c.du(child, report)
+		if err != nil {
+			return total, err
+		}
+
+		total.Bytes += childUsage.Bytes
+		total.Files += childUsage.Files
+	}
+
+	report(total)
+
+	return total, nil
+}
+
+func du(c *ctl, args []string) error {
+	var summarize bool
+
+	args, err := parse("du", args, func(flags *flag.FlagSet) {
+		flags.BoolVar(&summarize, "s", false, "only display a total for the argument")
+	}, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.resolve(argOr(args, 0, "/"), true)
+	if err != nil {
+		return err
+	}
+
+	var usages []diskUsage
+
+	total, err := c.du(node, func(directory diskUsage) {
+		if !summarize {
+			usages = append(usages, directory)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if summarize || !node.GetMode().IsDir() {
+		usages = []diskUsage{total}
+	}
+
+	c.print(usages, func() {
+		for _, usage := range usages {
+			fmt.Printf("%d\t%s\n", usage.Bytes, usage.Path)
+		}
+	})
+
+	return nil
+}
+
+type statFS struct {
+	Nodes         int64 `json:"nodes"`
+	Files         int64 `json:"files"`
+	Directories   int64 `json:"directories"`
+	Bytes         int64 `json:"bytes"`
+	DatabaseBytes int64 `json:"database_bytes"`
+	FreeBytes     int64 `json:"free_bytes"`
+	// Available bytes and nodes under a quota on the root, -1 without one
+	BytesAvailable int64 `json:"bytes_available"`
+	NodesAvailable int64 `json:"nodes_available"`
+}
+
+func df(c *ctl, args []string) error {
+	_, err := parse("df", args, nil, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	fsStats, err := c.fileSystem.StatFS()
+	if err != nil {
+		return err
+	}
+
+	stats := statFS{
+		Nodes:          fsStats.Nodes,
+		Files:          fsStats.Files,
+		Directories:    fsStats.Directories,
+		Bytes:          fsStats.Bytes,
+		DatabaseBytes:  fsStats.DatabaseBytes,
+		FreeBytes:      fsStats.FreeBytes,
+		BytesAvailable: fsStats.BytesAvailable,
+		NodesAvailable: fsStats.NodesAvailable,
+	}
+
+	c.print(stats, func() {
+		fmt.Printf("      Nodes: %d\n", stats.Nodes)
+		fmt.Printf("      Files: %d\n", stats.Files)
+		fmt.Printf("Directories: %d\n", stats.Directories)
+		fmt.Printf("       Used: %d\n", stats.Bytes)
+		fmt.Printf("   Database: %d\n", stats.DatabaseBytes)
+		fmt.Printf("       Free: %d\n", stats.FreeBytes)
+		if stats.BytesAvailable >= 0 {
+			fmt.Printf("  Available: %d\n", stats.BytesAvailable)
+		}
+		if stats.NodesAvailable >= 0 {
+			fmt.Printf("Nodes avail: %d\n", stats.NodesAvailable)
+		}
+	})
+
+	return nil
+}
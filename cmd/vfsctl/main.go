@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/sushydev/vfs_go"
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+var commands = map[string]func(ctl *ctl, args []string) error{
+	"ls":       ls,
+	"tree":     tree,
+	"stat":     stat,
+	"cat":      cat,
+	"put":      put,
+	"get":      get,
+	"mkdir":    mkdir,
+	"rm":       rm,
+	"mv":       mv,
+	"ln":       ln,
+	"readlink": readlink,
+	"xattr":    xattr,
+	"du":       du,
+	"df":       df,
+}
+
+var usages = []string{
+	"ls [-l] [path]",
+	"tree [path]",
+	"stat <path>",
+	"cat <path>",
+	"put <host file|-> <path>",
+	"get <path> <host file|->",
+	"mkdir [-p] <path>",
+	"rm [-r] <path>",
+	"mv <source> <destination>",
+	"ln -s <target> <path>",
+	"readlink <path>",
+	"xattr <list|get|set|rm> <path> [key] [value]",
+	"du [-s] [path]",
+	"df",
+}
+
+type ctl struct {
+	fileSystem *filesystem.FileSystem
+	json       bool
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: vfsctl [-db path] [-json] [-read-only] <command> [arguments]\n\ncommands:\n")
+
+	for _, usage := range usages {
+		fmt.Fprintf(os.Stderr, "  %s\n", usage)
+	}
+
+	os.Exit(2)
+}
+
+func main() {
+	databasePath := os.Getenv("VFS_DB")
+	if databasePath == "" {
+		databasePath = "vfs.db"
+	}
+
+	flag.StringVar(&databasePath, "db", databasePath, "path to the database, defaults to $VFS_DB or vfs.db")
+	asJson := flag.Bool("json", false, "print machine readable json")
+	readOnly := flag.Bool("read-only", false, "open the database read-only")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+	}
+
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+	}
+
+	fileSystem, err := filesystem.NewWith(databasePath, filesystem.Options{ReadOnly: *readOnly})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vfsctl: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = command(&ctl{fileSystem: fileSystem, json: *asJson}, flag.Args()[1:])
+	fileSystem.Close()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vfsctl: %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// resolve walks path from the root, symlinks are only followed for the final
+// component and only when follow is set
+func (c *ctl) resolve(name string, follow bool) (interfaces.Node, error) {
+	node, err := c.fileSystem.Root()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, component := range strings.Split(path.Clean("/"+name), "/") {
+		if component == "" {
+			continue
+		}
+
+		node, err = c.fileSystem.Lookup(node.GetId(), component)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	for hops := 0; follow && node.GetMode()&fs.ModeSymlink != 0; hops++ {
+		if hops > 40 {
+			return nil, fmt.Errorf("%s: %w", name, syscall.ELOOP)
+		}
+
+		target, err := c.fileSystem.ReadLink(node.GetId())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		node, err = c.resolve(target, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// resolveParent returns the directory that holds path and the base name within it
+func (c *ctl) resolveParent(name string) (interfaces.Node, string, error) {
+	cleaned := path.Clean("/" + name)
+	if cleaned == "/" {
+		return nil, "", fmt.Errorf("%s: %w", name, syscall.EINVAL)
+	}
+
+	parent, err := c.resolve(path.Dir(cleaned), true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !parent.GetMode().IsDir() {
+		return nil, "", fmt.Errorf("%s: %w", path.Dir(cleaned), syscall.ENOTDIR)
+	}
+
+	return parent, path.Base(cleaned), nil
+}
+
+func (c *ctl) print(value any, text func()) {
+	if !c.json {
+		text()
+		return
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+type nodeInfo struct {
+	Id         uint64 `json:"id"`
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Type       string `json:"type"`
+	Mode       string `json:"mode"`
+	Uid        int    `json:"uid"`
+	Gid        int    `json:"gid"`
+	Size       int64  `json:"size"`
+	ModTime    string `json:"mod_time"`
+	CreateTime string `json:"create_time"`
+	AccessTime string `json:"access_time"`
+	Target     string `json:"target,omitempty"`
+}
+
+func nodeType(node interfaces.Node) string {
+	switch {
+	case node.GetMode().IsDir():
+		return "directory"
+	case node.GetMode()&fs.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "file"
+	}
+}
+
+func (c *ctl) info(node interfaces.Node) (nodeInfo, error) {
+	info := nodeInfo{
+		Id:         node.GetId(),
+		Name:       node.GetName(),
+		Path:       node.GetPath(),
+		Type:       nodeType(node),
+		Mode:       node.GetMode().String(),
+		Uid:        node.GetUid(),
+		Gid:        node.GetGid(),
+		ModTime:    node.GetModTime(),
+		CreateTime: node.GetCreateTime(),
+		AccessTime: node.GetAccessTime(),
+	}
+
+	switch info.Type {
+	case "file":
+		size, err := c.size(node)
+		if err != nil {
+			return info, err
+		}
+
+		info.Size = size
+	case "symlink":
+		target, err := c.fileSystem.ReadLink(node.GetId())
+		if err != nil && err != syscall.ENOENT {
+			return info, err
+		}
+
+		info.Target = target
+	}
+
+	return info, nil
+}
+
+// size is the stored size, provider backed and generated files have none
+// stored and are asked for it instead
+func (c *ctl) size(node interfaces.Node) (int64, error) {
+	if node.GetSize() != 0 {
+		return node.GetSize(), nil
+	}
+
+	info, err := c.fileSystem.StatRemote(node.GetId())
+	if err == syscall.EINVAL {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size, nil
+}
@@ -0,0 +1,78 @@
+package filesystem
+
+import (
+	"syscall"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// NodeFlag is an attribute flag of a node, the values are those chattr uses
+type NodeFlag uint32
+
+const (
+	// FlagImmutable nodes cannot be written, changed, renamed or removed.
+	// Immutable directories take no new entries and keep the ones they have.
+	FlagImmutable NodeFlag = 0x10
+	// FlagAppend files only grow at their end and cannot be renamed or
+	// removed either. Append only directories take new entries but keep the
+	// ones they have.
+	FlagAppend NodeFlag = 0x20
+)
+
+// SetFlags replaces the attribute flags of id. Like chattr only root may
+// set or clear them, they hold for root as much as for anyone else.
+func (f *FileSystem) SetFlags(id uint64, flags NodeFlag) error {
+	err := f.requireWritable()
+	if err != nil {
+		return err
+	}
+
+	err = f.requireRoot()
+	if err != nil {
+		return err
+	}
+
+	if flags&^(FlagImmutable|FlagAppend) != 0 {
+		return syscall.EINVAL
+	}
+
+	if isMountedId(id) || isGeneratedId(id) {
+		return syscall.EROFS
+	}
+
+	node, err := f.Open(id)
+	if err != nil {
+		return err
+	}
+
+	return f.database.SetNodeFlags(node.GetEntity(), int64(flags))
+}
+
+// requireWritable fails with EROFS when the VFS was opened read-only
+func (f *FileSystem) requireWritable() error {
+	if f.readOnly {
+		return syscall.EROFS
+	}
+
+	return nil
+}
+
+// checkFlags opens id to change it, which fails with EROFS when the VFS is
+// read-only and with EPERM when the node has any of flags
+func (f *FileSystem) checkFlags(id uint64, flags NodeFlag) (interfaces.Node, error) {
+	err := f.requireWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := f.open(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if NodeFlag(node.GetFlags())&flags != 0 {
+		return nil, syscall.EPERM
+	}
+
+	return node, nil
+}
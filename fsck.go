@@ -0,0 +1,557 @@
+package filesystem
+
+import (
+	"fmt"
+	"sort"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/interfaces"
+	"github.com/sushydev/vfs_go/internal/database"
+	database_interfaces "github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+const LostAndFound = "lost+found"
+
+type FsckKind string
+
+const (
+	FsckMissingParent      FsckKind = "missing-parent"
+	FsckParentNotDirectory FsckKind = "parent-not-directory"
+	FsckCycle              FsckKind = "cycle"
+	FsckDuplicateName      FsckKind = "duplicate-name"
+	FsckPathMismatch       FsckKind = "path-mismatch"
+	FsckDanglingSymlink    FsckKind = "dangling-symlink"
+	FsckMissingSymlink     FsckKind = "missing-symlink"
+	FsckHashMismatch       FsckKind = "hash-mismatch"
+	FsckMissingHash        FsckKind = "missing-hash"
+	FsckOrphanContent      FsckKind = "orphan-content"
+	FsckMisplacedContent   FsckKind = "misplaced-content"
+	FsckOrphanAttribute    FsckKind = "orphan-attribute"
+)
+
+type FsckOptions struct {
+	// Repair fixes what can be fixed, orphaned nodes are moved to /lost+found
+	Repair bool
+	// SkipContent skips reading every content row to verify its hash
+	SkipContent bool
+}
+
+type FsckFinding struct {
+	Kind     FsckKind `json:"kind"`
+	Id       uint64   `json:"id"`
+	Path     string   `json:"path,omitempty"`
+	Message  string   `json:"message"`
+	Repaired bool     `json:"repaired"`
+	// Notice marks findings that are expected in a healthy tree and need no repair
+	Notice bool `json:"notice,omitempty"`
+}
+
+type FsckReport struct {
+	Nodes    int           `json:"nodes"`
+	Findings []FsckFinding `json:"findings"`
+}
+
+func (r *FsckReport) Clean() bool {
+	for _, finding := range r.Findings {
+		if !finding.Repaired && !finding.Notice {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Problems counts the findings that are not notices, repaired or not
+func (r *FsckReport) Problems() int {
+	problems := 0
+	for _, finding := range r.Findings {
+		if !finding.Notice {
+			problems++
+		}
+	}
+
+	return problems
+}
+
+func (r *FsckReport) notice(kind FsckKind, id uint64, path string, format string, args ...any) {
+	r.add(kind, id, path, false, format, args...)
+	r.Findings[len(r.Findings)-1].Notice = true
+}
+
+func (r *FsckReport) add(kind FsckKind, id uint64, path string, repaired bool, format string, args ...any) {
+	r.Findings = append(r.Findings, FsckFinding{
+		Kind:     kind,
+		Id:       id,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+		Repaired: repaired,
+	})
+}
+
+type fsck struct {
+	fileSystem *FileSystem
+	options    FsckOptions
+	report     *FsckReport
+	nodes      map[uint64]interfaces.Node
+	lostFound  interfaces.Node
+}
+
+// Fsck verifies the consistency of the tree and its related tables within
+// the namespace f works on, rows that lost their node are only looked for by
+// the default namespace
+func (f *FileSystem) Fsck(options FsckOptions) (*FsckReport, error) {
+	err := f.requireRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Repair {
+		err = f.requireWritable()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	check := &fsck{
+		fileSystem: f,
+		options:    options,
+		report:     &FsckReport{},
+	}
+
+	steps := []func() error{
+		check.checkTree,
+		check.checkPaths,
+		check.checkSymlinks,
+		check.checkOrphans,
+		check.checkContents,
+	}
+
+	for _, step := range steps {
+		err := step()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return check.report, nil
+}
+
+func (c *fsck) loadNodes() error {
+	nodes, err := c.fileSystem.nodeRepository.GetAll()
+	if err != nil {
+		return err
+	}
+
+	c.nodes = make(map[uint64]interfaces.Node, len(nodes))
+	for _, node := range nodes {
+		c.nodes[node.GetId()] = node
+	}
+
+	c.report.Nodes = len(nodes)
+
+	return nil
+}
+
+func (c *fsck) sortedIds() []uint64 {
+	ids := make([]uint64, 0, len(c.nodes))
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
+// checkTree verifies that every node hangs off a directory that leads back to the root
+func (c *fsck) checkTree() error {
+	err := c.loadNodes()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.nodes[c.fileSystem.rootId]; !ok {
+		return fmt.Errorf("root node is missing")
+	}
+
+	names := make(map[uint64]map[string][]uint64)
+
+	for _, id := range c.sortedIds() {
+		if id == c.fileSystem.rootId {
+			continue
+		}
+
+		node := c.nodes[id]
+		parent, ok := c.nodes[node.GetParentId()]
+
+		switch {
+		case !ok:
+			err = c.adopt(node, FsckMissingParent, "parent %d does not exist", node.GetParentId())
+		case !parent.GetMode().IsDir():
+			err = c.adopt(node, FsckParentNotDirectory, "parent %d is not a directory", node.GetParentId())
+		case c.inCycle(node):
+			err = c.adopt(node, FsckCycle, "parent chain does not lead to the root")
+		default:
+			if names[parent.GetId()] == nil {
+				names[parent.GetId()] = make(map[string][]uint64)
+			}
+
+			names[parent.GetId()][node.GetName()] = append(names[parent.GetId()][node.GetName()], id)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, siblings := range names {
+		for name, ids := range siblings {
+			for _, id := range ids[1:] {
+				node := c.nodes[id]
+				repaired := false
+
+				if c.options.Repair {
+					node.SetName(fmt.Sprintf("%s.%d", name, id))
+
+					err := c.fileSystem.database.SaveNode(node.GetEntity())
+					if err != nil {
+						return err
+					}
+
+					repaired = true
+				}
+
+				c.report.add(FsckDuplicateName, id, node.GetPath(), repaired, "name %q is used by %d nodes in directory %d", name, len(ids), node.GetParentId())
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *fsck) inCycle(node interfaces.Node) bool {
+	seen := map[uint64]bool{node.GetId(): true}
+
+	for current := node; current.GetId() != c.fileSystem.rootId; {
+		parent, ok := c.nodes[current.GetParentId()]
+		if !ok {
+			return false
+		}
+
+		if seen[parent.GetId()] {
+			return true
+		}
+
+		seen[parent.GetId()] = true
+		current = parent
+	}
+
+	return false
+}
+
+// adopt reports a node that is detached from the tree and moves it into /lost+found when repairing
+func (c *fsck) adopt(node interfaces.Node, kind FsckKind, format string, args ...any) error {
+	if !c.options.Repair {
+		c.report.add(kind, node.GetId(), node.GetPath(), false, format, args...)
+		return nil
+	}
+
+	lostFound, err := c.getLostFound()
+	if err != nil {
+		return err
+	}
+
+	node.SetParentId(lostFound.GetId())
+	node.SetName(fmt.Sprintf("#%d", node.GetId()))
+
+	c.report.add(kind, node.GetId(), node.GetPath(), true, format, args...)
+
+	node.SetPath(getPath(lostFound, node.GetName()))
+
+	return c.fileSystem.database.SaveNode(node.GetEntity())
+}
+
+func (c *fsck) getLostFound() (interfaces.Node, error) {
+	if c.lostFound != nil {
+		return c.lostFound, nil
+	}
+
+	node, err := c.fileSystem.Lookup(c.fileSystem.rootId, LostAndFound)
+	if err == syscall.ENOENT {
+		err = c.fileSystem.MkDir(c.fileSystem.rootId, LostAndFound)
+		if err != nil {
+			return nil, err
+		}
+
+		node, err = c.fileSystem.Lookup(c.fileSystem.rootId, LostAndFound)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !node.GetMode().IsDir() {
+		return nil, fmt.Errorf("/%s is not a directory", LostAndFound)
+	}
+
+	c.nodes[node.GetId()] = node
+	c.lostFound = node
+
+	return node, nil
+}
+
+// checkPaths verifies that the stored path matches the chain of parent names
+func (c *fsck) checkPaths() error {
+	expected := map[uint64]string{c.fileSystem.rootId: "/"}
+
+	var resolve func(node interfaces.Node) (string, bool)
+	resolve = func(node interfaces.Node) (string, bool) {
+		if path, ok := expected[node.GetId()]; ok {
+			return path, true
+		}
+
+		parent, ok := c.nodes[node.GetParentId()]
+		if !ok || c.inCycle(node) {
+			return "", false
+		}
+
+		parentPath, ok := resolve(parent)
+		if !ok {
+			return "", false
+		}
+
+		path := parentPath + "/" + node.GetName()
+		if parentPath == "/" {
+			path = "/" + node.GetName()
+		}
+
+		expected[node.GetId()] = path
+
+		return path, true
+	}
+
+	// Resolve every node before saving so parents are fixed before their children
+	ids := c.sortedIds()
+	for _, id := range ids {
+		resolve(c.nodes[id])
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool { return len(expected[ids[i]]) < len(expected[ids[j]]) })
+
+	for _, id := range ids {
+		node := c.nodes[id]
+
+		path, ok := expected[id]
+		if !ok || node.GetPath() == path {
+			continue
+		}
+
+		repaired := false
+
+		if c.options.Repair {
+			node.SetPath(path)
+
+			err := c.fileSystem.database.SaveNode(node.GetEntity())
+			if err != nil {
+				return err
+			}
+
+			repaired = true
+		}
+
+		c.report.add(FsckPathMismatch, id, path, repaired, "stored path does not match parent chain %q", path)
+	}
+
+	return nil
+}
+
+// checkSymlinks verifies that every symlink node points at an existing node
+func (c *fsck) checkSymlinks() error {
+	symlinks, err := c.fileSystem.database.GetDanglingSymlinks()
+	if err != nil {
+		return err
+	}
+
+	if c.orphans() {
+		orphans, err := c.fileSystem.database.GetOrphanSymlinks()
+		if err != nil {
+			return err
+		}
+
+		symlinks = append(symlinks, orphans...)
+	}
+
+	for _, symlink := range symlinks {
+		source, hasSource := c.nodes[uint64(symlink.GetSourceNodeId())]
+
+		path := ""
+		if hasSource {
+			path = source.GetPath()
+		}
+
+		repaired := false
+
+		if c.options.Repair {
+			err := c.fileSystem.database.DeleteSymlink(symlink)
+			if err != nil {
+				return err
+			}
+
+			if hasSource {
+				err = c.removeNode(source)
+				if err != nil {
+					return err
+				}
+			}
+
+			repaired = true
+		}
+
+		c.report.add(FsckDanglingSymlink, uint64(symlink.GetSourceNodeId()), path, repaired, "symlink %d points from %d to missing node %d", symlink.GetId(), symlink.GetSourceNodeId(), symlink.GetTargetNodeId())
+	}
+
+	nodes, err := c.fileSystem.database.GetSymlinkNodesWithoutTarget()
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range nodes {
+		node, ok := c.nodes[uint64(entity.GetId())]
+		if !ok {
+			continue
+		}
+
+		// Removing the target of a symlink removes its row along with it,
+		// the link is left dangling just like on a disk and is kept
+		c.report.notice(FsckMissingSymlink, node.GetId(), node.GetPath(), "symlink node has no target")
+	}
+
+	return nil
+}
+
+func (c *fsck) removeNode(node interfaces.Node) error {
+	err := c.fileSystem.database.DeleteNode(node.GetEntity())
+	if err != nil {
+		return err
+	}
+
+	delete(c.nodes, node.GetId())
+
+	return nil
+}
+
+// orphans tells whether rows without a node are looked for, they belong to no
+// namespace so only checking the default one reports and repairs them
+func (c *fsck) orphans() bool {
+	return c.fileSystem.namespace == DefaultNamespace
+}
+
+// rowCheck finds related rows by id that are removed when repairing
+type rowCheck struct {
+	kind    FsckKind
+	query   func() ([]int64, error)
+	remove  func(int64) error
+	message string
+}
+
+// checkOrphans looks for related rows whose node no longer exists
+func (c *fsck) checkOrphans() error {
+	checks := []rowCheck{
+		{FsckMisplacedContent, c.fileSystem.database.GetMisplacedNodeContentIds, c.fileSystem.database.DeleteNodeContent, "content row %d belongs to a node that is not a regular file"},
+	}
+
+	if c.orphans() {
+		checks = append(checks,
+			rowCheck{FsckOrphanContent, c.fileSystem.database.GetOrphanNodeContentIds, c.fileSystem.database.DeleteNodeContent, "content row %d belongs to a missing node"},
+			rowCheck{FsckOrphanAttribute, c.fileSystem.database.GetOrphanNodeAttributeIds, c.fileSystem.database.DeleteNodeAttribute, "attribute row %d belongs to a missing node"},
+		)
+	}
+
+	for _, check := range checks {
+		ids, err := check.query()
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			repaired := false
+
+			if c.options.Repair {
+				err := check.remove(id)
+				if err != nil {
+					return err
+				}
+
+				repaired = true
+			}
+
+			c.report.add(check.kind, uint64(id), "", repaired, check.message, id)
+		}
+	}
+
+	return nil
+}
+
+// checkContents verifies the stored hash of every content row
+func (c *fsck) checkContents() error {
+	if c.options.SkipContent {
+		return nil
+	}
+
+	type mismatch struct {
+		id     int64
+		nodeId uint64
+		hash   string
+		stored string
+	}
+
+	var mismatches []mismatch
+
+	err := c.fileSystem.database.EachNodeContent(func(nodeContent database_interfaces.NodeContent) error {
+		hash := database.HashContent(nodeContent.GetContent())
+		if hash == nodeContent.GetHash() {
+			return nil
+		}
+
+		mismatches = append(mismatches, mismatch{
+			id:     nodeContent.GetId(),
+			nodeId: uint64(nodeContent.GetNodeId()),
+			hash:   hash,
+			stored: nodeContent.GetHash(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, mismatch := range mismatches {
+		path := ""
+		if node, ok := c.nodes[mismatch.nodeId]; ok {
+			path = node.GetPath()
+		}
+
+		// Content written before hashes were stored can be hashed now, a real
+		// mismatch means the content changed behind our back and is only reported
+		if mismatch.stored != "" {
+			c.report.add(FsckHashMismatch, mismatch.nodeId, path, false, "content row %d hashes to %s, expected %s", mismatch.id, mismatch.hash, mismatch.stored)
+			continue
+		}
+
+		repaired := false
+
+		if c.options.Repair {
+			err := c.fileSystem.database.UpdateNodeContentHash(mismatch.id, mismatch.hash)
+			if err != nil {
+				return err
+			}
+
+			repaired = true
+		}
+
+		c.report.add(FsckMissingHash, mismatch.nodeId, path, repaired, "content row %d has no stored hash", mismatch.id)
+	}
+
+	return nil
+}
@@ -0,0 +1,438 @@
+package filesystem
+
+import (
+	"context"
+	"io"
+	gopath "path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sushydev/vfs_go/interfaces"
+	database_node "github.com/sushydev/vfs_go/internal/database/node"
+	"github.com/sushydev/vfs_go/internal/filesystem/node"
+)
+
+// Ids of generated files carry this bit, they are handed out as generated
+// files are first seen and only stay the same while the VFS is open
+const generatedIdFlag = 1 << 62
+
+// GeneratorContext tells a generator which file it is producing
+type GeneratorContext struct {
+	context.Context
+
+	FileSystem *FileSystem
+	// Directory is the directory the generated file appears in
+	Directory interfaces.Node
+	Name      string
+}
+
+// Generator produces the content of a generated file. It is called on the
+// first read and again on the first read after the file was invalidated.
+type Generator func(ctx *GeneratorContext) ([]byte, error)
+
+type generator struct {
+	name string
+	fn   Generator
+}
+
+type generatorKey struct {
+	parentId uint64
+	name     string
+}
+
+// generated is one generated file, a pattern generator has one per matching directory
+type generated struct {
+	id        uint64
+	generator *generator
+	parentId  uint64
+	name      string
+	modTime   time.Time
+	content   []byte
+	valid     bool
+}
+
+type patternGenerator struct {
+	pattern string
+	generator
+}
+
+type generatorTable struct {
+	mutex     sync.Mutex
+	direct    map[generatorKey]*generator
+	patterns  []*patternGenerator
+	instances map[generatorKey]*generated
+	byId      map[uint64]*generated
+	next      uint64
+}
+
+func newGeneratorTable() *generatorTable {
+	return &generatorTable{
+		direct:    make(map[generatorKey]*generator),
+		instances: make(map[generatorKey]*generated),
+		byId:      make(map[uint64]*generated),
+	}
+}
+
+func isGeneratedId(id uint64) bool {
+	return id&mountIdFlag == 0 && id&generatedIdFlag != 0
+}
+
+// RegisterGenerator makes name in the directory parentId a generated file.
+// A stored node with the same name takes precedence over it.
+func (f *FileSystem) RegisterGenerator(parentId uint64, name string, fn Generator) error {
+	parent, err := f.getNode(parentId)
+	if err != nil {
+		return err
+	}
+
+	if !parent.GetMode().IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return syscall.EINVAL
+	}
+
+	f.generators.mutex.Lock()
+	defer f.generators.mutex.Unlock()
+
+	key := generatorKey{parentId: parentId, name: name}
+
+	f.generators.direct[key] = &generator{name: name, fn: fn}
+	f.generators.drop(key)
+
+	return nil
+}
+
+// RegisterGeneratorPattern adds a generated file named name to every
+// directory whose absolute path matches pattern, see path.Match
+func (f *FileSystem) RegisterGeneratorPattern(pattern string, name string, fn Generator) error {
+	_, err := gopath.Match(pattern, "/")
+	if err != nil {
+		return err
+	}
+
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return syscall.EINVAL
+	}
+
+	f.generators.mutex.Lock()
+	defer f.generators.mutex.Unlock()
+
+	f.generators.patterns = append(f.generators.patterns, &patternGenerator{pattern: pattern, generator: generator{name: name, fn: fn}})
+
+	return nil
+}
+
+// UnregisterGenerator removes the generator for name in the directory parentId
+func (f *FileSystem) UnregisterGenerator(parentId uint64, name string) error {
+	f.generators.mutex.Lock()
+	defer f.generators.mutex.Unlock()
+
+	key := generatorKey{parentId: parentId, name: name}
+	if _, ok := f.generators.direct[key]; !ok {
+		return syscall.ENOENT
+	}
+
+	delete(f.generators.direct, key)
+	f.generators.drop(key)
+
+	return nil
+}
+
+// InvalidateGenerated makes the generated files of the directory parentId
+// produce their content again on the next read. Changes made through the VFS
+// invalidate the directory they happen in by themselves, this is for inputs
+// from elsewhere.
+func (f *FileSystem) InvalidateGenerated(parentId uint64) {
+	f.generators.invalidate(parentId)
+}
+
+func (t *generatorTable) drop(key generatorKey) {
+	if instance, ok := t.instances[key]; ok {
+		delete(t.instances, key)
+		delete(t.byId, instance.id)
+	}
+}
+
+func (t *generatorTable) invalidate(parentId uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key, instance := range t.instances {
+		if key.parentId != parentId {
+			continue
+		}
+
+		instance.valid = false
+		instance.content = nil
+		instance.modTime = time.Now()
+	}
+}
+
+// find returns the generated file name in parent, if any generator makes one
+func (t *generatorTable) find(parent interfaces.Node, name string) *generated {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.instance(parent, name)
+}
+
+func (t *generatorTable) instance(parent interfaces.Node, name string) *generated {
+	key := generatorKey{parentId: parent.GetId(), name: name}
+
+	if instance, ok := t.instances[key]; ok {
+		return instance
+	}
+
+	generator, ok := t.direct[key]
+	if !ok {
+		for _, pattern := range t.patterns {
+			if matched, _ := gopath.Match(pattern.pattern, parent.GetPath()); matched && pattern.name == name {
+				generator = &pattern.generator
+				break
+			}
+		}
+	}
+
+	if generator == nil {
+		return nil
+	}
+
+	t.next++
+
+	instance := &generated{
+		id:        generatedIdFlag | t.next,
+		generator: generator,
+		parentId:  parent.GetId(),
+		name:      name,
+		modTime:   time.Now(),
+	}
+
+	t.instances[key] = instance
+	t.byId[instance.id] = instance
+
+	return instance
+}
+
+// list returns the generated files of parent by name
+func (t *generatorTable) list(parent interfaces.Node) []*generated {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	names := make(map[string]bool)
+	for key := range t.direct {
+		if key.parentId == parent.GetId() {
+			names[key.name] = true
+		}
+	}
+
+	for _, pattern := range t.patterns {
+		if matched, _ := gopath.Match(pattern.pattern, parent.GetPath()); matched {
+			names[pattern.name] = true
+		}
+	}
+
+	instances := make([]*generated, 0, len(names))
+	for name := range names {
+		instances = append(instances, t.instance(parent, name))
+	}
+
+	sort.Slice(instances, func(i, j int) bool { return instances[i].name < instances[j].name })
+
+	return instances
+}
+
+func (t *generatorTable) get(id uint64) (*generated, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	instance, ok := t.byId[id]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	return instance, nil
+}
+
+// node presents a generated file as a read-only regular file
+func (f *FileSystem) generatedNode(instance *generated) (interfaces.Node, error) {
+	parent, err := f.getNode(instance.parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	size := f.generatedSize(instance)
+
+	f.generators.mutex.Lock()
+	modTime := instance.modTime.UTC().Format(TimeFormat)
+	f.generators.mutex.Unlock()
+
+	entity, err := database_node.New(
+		int64(instance.id),
+		instance.name,
+		int64(instance.parentId),
+		getPath(parent, instance.name),
+		0444,
+		0,
+		0,
+		modTime,
+		modTime,
+		modTime,
+		size,
+		0,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return node.New(entity)
+}
+
+// generatedSize produces the content of a generated file to tell its size,
+// so stat and listings agree with what reading returns. A generator that
+// looks at its own file through the view it was given sees the size of the
+// content produced before, as does everyone when producing it fails; the
+// error shows on reading.
+func (f *FileSystem) generatedSize(instance *generated) int64 {
+	if !slices.Contains(f.generating, instance.id) {
+		content, _, err := f.generate(instance.id)
+		if err == nil {
+			return int64(len(content))
+		}
+	}
+
+	f.generators.mutex.Lock()
+	defer f.generators.mutex.Unlock()
+
+	return int64(len(instance.content))
+}
+
+// generatedNodes adds the generated files of parent to its stored children
+func (f *FileSystem) generatedNodes(parent interfaces.Node, children []interfaces.Node) ([]interfaces.Node, error) {
+	instances := f.generators.list(parent)
+	if len(instances) == 0 {
+		return children, nil
+	}
+
+	stored := make(map[string]bool, len(children))
+	for _, child := range children {
+		stored[child.GetName()] = true
+	}
+
+	for _, instance := range instances {
+		if stored[instance.name] {
+			continue
+		}
+
+		node, err := f.generatedNode(instance)
+		if err != nil {
+			return nil, err
+		}
+
+		children = append(children, node)
+	}
+
+	return children, nil
+}
+
+// generate returns the content of a generated file, producing it when it is not current
+func (f *FileSystem) generate(id uint64) ([]byte, time.Time, error) {
+	instance, err := f.generators.get(id)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	f.generators.mutex.Lock()
+	if instance.valid {
+		content, modTime := instance.content, instance.modTime
+		f.generators.mutex.Unlock()
+
+		return content, modTime, nil
+	}
+	f.generators.mutex.Unlock()
+
+	parent, err := f.getNode(instance.parentId)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	view := *f
+	view.generating = append(slices.Clone(f.generating), id)
+
+	content, err := instance.generator.fn(&GeneratorContext{
+		Context:    context.Background(),
+		FileSystem: &view,
+		Directory:  parent,
+		Name:       instance.name,
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	f.generators.mutex.Lock()
+	defer f.generators.mutex.Unlock()
+
+	instance.content, instance.valid = content, true
+
+	return content, instance.modTime, nil
+}
+
+// generatedProvider lets handles read generated files like provider backed ones,
+// the key is the id of the generated file
+type generatedProvider struct {
+	fileSystem *FileSystem
+}
+
+var _ interfaces.ContentProvider = &generatedProvider{}
+
+func (p *generatedProvider) content(key string) ([]byte, time.Time, error) {
+	id, err := strconv.ParseUint(key, 10, 64)
+	if err != nil {
+		return nil, time.Time{}, syscall.EINVAL
+	}
+
+	return p.fileSystem.generate(id)
+}
+
+func (p *generatedProvider) Size(key string) (int64, error) {
+	content, _, err := p.content(key)
+
+	return int64(len(content)), err
+}
+
+func (p *generatedProvider) ReadAt(key string, b []byte, offset int64) (int, error) {
+	content, _, err := p.content(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, content[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (p *generatedProvider) Stat(key string) (interfaces.ContentInfo, error) {
+	content, modTime, err := p.content(key)
+	if err != nil {
+		return interfaces.ContentInfo{}, err
+	}
+
+	return interfaces.ContentInfo{
+		Size:    int64(len(content)),
+		ModTime: modTime,
+		Version: strconv.FormatInt(modTime.UnixNano(), 16),
+	}, nil
+}
@@ -0,0 +1,253 @@
+package filesystem
+
+import (
+	"io/fs"
+	"iter"
+	gopath "path"
+	"strings"
+	"time"
+
+	"github.com/sushydev/vfs_go/interfaces"
+	"github.com/sushydev/vfs_go/internal/database"
+)
+
+// findBatchSize is the number of nodes FindNodes fetches per query
+const findBatchSize = 256
+
+// Glob returns the stored nodes whose absolute path matches pattern, in path
+// order. Besides the syntax of path.Match a "**" segment matches any number
+// of directories, including none. Generated files and mounted entries are
+// not stored and so never match.
+func (f *FileSystem) Glob(pattern string) ([]interfaces.Node, error) {
+	pattern = gopath.Clean("/" + pattern)
+	segments := splitPath(pattern)
+
+	for _, segment := range segments {
+		if _, err := gopath.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := database.NodeFilter{Prefix: globPrefix(segments)}
+
+	if !hasDoubleStar(segments) {
+		filter.Depth = len(segments)
+		filter.Glob = sqlGlob(segments)
+	}
+
+	candidates, err := f.nodeRepository.Find(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := f.visibility()
+
+	var nodes []interfaces.Node
+	for _, candidate := range candidates {
+		if !matchSegments(segments, splitPath(candidate.GetPath())) {
+			continue
+		}
+
+		permitted, err := visible(candidate)
+		if err != nil {
+			return nil, err
+		}
+
+		if permitted {
+			nodes = append(nodes, candidate)
+		}
+	}
+
+	return nodes, nil
+}
+
+// globPrefix is the directory spelled out literally at the start of a pattern
+func globPrefix(segments []string) string {
+	prefix := "/"
+	for _, segment := range segments[:max(len(segments)-1, 0)] {
+		if strings.ContainsAny(segment, `*?[\`) {
+			break
+		}
+
+		prefix = gopath.Join(prefix, segment)
+	}
+
+	return prefix
+}
+
+func hasDoubleStar(segments []string) bool {
+	for _, segment := range segments {
+		if segment == "**" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sqlGlob turns segments into a GLOB SQLite can narrow candidates down with.
+// Its wildcards also match slashes and it has no escapes, so segments using
+// escapes or classes are left to matchSegments entirely.
+func sqlGlob(segments []string) string {
+	if len(segments) == 0 {
+		return "/"
+	}
+
+	parts := make([]string, len(segments))
+	for index, segment := range segments {
+		if strings.ContainsAny(segment, `[\`) {
+			parts[index] = "*"
+			continue
+		}
+
+		parts[index] = segment
+	}
+
+	return "/" + strings.Join(parts, "/")
+}
+
+// matchSegments matches a path against a pattern one segment at a time
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchSegments(pattern[1:], name[skip:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if matched, _ := gopath.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// NodeQuery selects nodes for FindNodes, fields left at their zero value do not filter
+type NodeQuery struct {
+	// SubtreeId limits results to the descendants of this directory, the
+	// root of the namespace when zero
+	SubtreeId uint64
+	// Name is a path.Match pattern for the name of results
+	Name string
+	// Type is one of TypeDirectory, TypeFile or TypeSymlink
+	Type    string
+	MinSize int64
+	MaxSize int64
+	Uid     *int
+	Gid     *int
+	// ModifiedAfter is inclusive, ModifiedBefore is exclusive
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+}
+
+// FindNodes streams the stored nodes matching query in path order. Nodes are
+// fetched in batches, so the tree may be changed while iterating.
+func (f *FileSystem) FindNodes(query NodeQuery) iter.Seq2[interfaces.Node, error] {
+	return func(yield func(interfaces.Node, error) bool) {
+		filter, err := f.nodeFilter(query)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		visible := f.visibility()
+
+		for {
+			nodes, err := f.nodeRepository.Find(filter)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, node := range nodes {
+				if query.Name != "" {
+					if matched, _ := gopath.Match(query.Name, node.GetName()); !matched {
+						continue
+					}
+				}
+
+				permitted, err := visible(node)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+
+				if permitted && !yield(node, nil) {
+					return
+				}
+			}
+
+			if len(nodes) < filter.Limit {
+				return
+			}
+
+			filter.After = nodes[len(nodes)-1].GetPath()
+		}
+	}
+}
+
+func (f *FileSystem) nodeFilter(query NodeQuery) (database.NodeFilter, error) {
+	filter := database.NodeFilter{
+		MinSize: query.MinSize,
+		MaxSize: query.MaxSize,
+		Uid:     query.Uid,
+		Gid:     query.Gid,
+		Limit:   findBatchSize,
+	}
+
+	subtreeId := query.SubtreeId
+	if subtreeId == 0 {
+		subtreeId = f.rootId
+	}
+
+	subtree, err := f.Open(subtreeId)
+	if err != nil {
+		return filter, err
+	}
+
+	// The subtree itself is not one of its descendants
+	filter.Prefix, filter.After = subtree.GetPath(), subtree.GetPath()
+
+	if query.Name != "" {
+		if _, err := gopath.Match(query.Name, ""); err != nil {
+			return filter, err
+		}
+
+		if !strings.ContainsAny(query.Name, `[\`) {
+			filter.NameGlob = query.Name
+		}
+	}
+
+	switch query.Type {
+	case "":
+	case TypeDirectory:
+		filter.ModeMask, filter.ModeValue = int64(fs.ModeDir), int64(fs.ModeDir)
+	case TypeFile:
+		filter.ModeMask, filter.ModeValue = int64(fs.ModeType), 0
+	case TypeSymlink:
+		filter.ModeMask, filter.ModeValue = int64(fs.ModeSymlink), int64(fs.ModeSymlink)
+	default:
+		return filter, fs.ErrInvalid
+	}
+
+	if !query.ModifiedAfter.IsZero() {
+		filter.ModifiedAfter = query.ModifiedAfter.UTC().Format(TimeFormat)
+	}
+
+	if !query.ModifiedBefore.IsZero() {
+		filter.ModifiedBefore = query.ModifiedBefore.UTC().Format(TimeFormat)
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,422 @@
+package filesystem
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// Contiguous writes are buffered up to this size before they are spliced into
+// the stored content, every flush rewrites the blob so it should not be small
+const handleBufferSize = 4 << 20
+
+// Handle is an open regular file. Reads and writes go to the database one
+// range at a time so a file never has to fit in memory as a whole.
+type Handle struct {
+	fileSystem    *FileSystem
+	node          interfaces.Node
+	offset        int64
+	pending       []byte
+	pendingOffset int64
+	dirty         bool
+	closed        bool
+	// provider serves reads of provider backed files, which cannot be written
+	provider interfaces.ContentProvider
+	key      string
+	// readable and writable are what the caller of OpenFile was permitted
+	readable bool
+	writable bool
+	// flags are the attribute flags the file had when it was opened
+	flags NodeFlag
+	// lockId numbers the handle in the session once it takes a lock,
+	// lockOwner is who its locks belong to
+	lockId    int64
+	lockOwner string
+	// sessionId is the session the file is recorded open in, 0 when it is not
+	sessionId int64
+}
+
+var _ io.ReadWriteSeeker = &Handle{}
+var _ io.ReaderAt = &Handle{}
+var _ io.WriterAt = &Handle{}
+
+func (f *FileSystem) OpenFile(id uint64) (*Handle, error) {
+	if isMountedId(id) {
+		return nil, syscall.EROFS
+	}
+
+	node, err := f.Open(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.GetMode().IsDir() {
+		return nil, syscall.EISDIR
+	}
+
+	if !node.GetMode().IsRegular() {
+		return nil, syscall.EINVAL
+	}
+
+	readable, err := f.permits(node, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+
+	writable, err := f.permits(node, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	if !readable && !writable {
+		return nil, syscall.EACCES
+	}
+
+	provider, key, err := f.contentProvider(node)
+	if err != nil {
+		return nil, err
+	}
+
+	handle := &Handle{
+		fileSystem: f,
+		node:       node,
+		provider:   provider,
+		key:        key,
+		readable:   readable,
+		writable:   writable,
+		flags:      NodeFlag(node.GetFlags()),
+	}
+
+	// Other processes cannot remove files from a read-only database either,
+	// its handles are not recorded
+	if !f.readOnly && !isGeneratedId(id) {
+		err = f.session.openNode(f.database, handle)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return handle, nil
+}
+
+func (h *Handle) Node() interfaces.Node {
+	return h.node
+}
+
+func (h *Handle) Size() (int64, error) {
+	if h.closed {
+		return 0, os.ErrClosed
+	}
+
+	if h.provider != nil {
+		return h.provider.Size(h.key)
+	}
+
+	size, err := h.fileSystem.database.GetNodeContentSize(h.node.GetEntity())
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	if end := h.pendingOffset + int64(len(h.pending)); len(h.pending) > 0 && end > size {
+		size = end
+	}
+
+	return size, nil
+}
+
+func (h *Handle) ReadAt(p []byte, offset int64) (int, error) {
+	if h.closed {
+		return 0, os.ErrClosed
+	}
+
+	if offset < 0 {
+		return 0, syscall.EINVAL
+	}
+
+	if !h.readable {
+		return 0, syscall.EACCES
+	}
+
+	if h.provider != nil {
+		return h.provider.ReadAt(h.key, p, offset)
+	}
+
+	err := h.flush()
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := h.fileSystem.database.ReadNodeContentAt(h.node.GetEntity(), offset, int64(len(p)))
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	n := copy(p, content)
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (h *Handle) Read(p []byte) (int, error) {
+	n, err := h.ReadAt(p, h.offset)
+	h.offset += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (h *Handle) WriteAt(p []byte, offset int64) (int, error) {
+	if h.closed {
+		return 0, os.ErrClosed
+	}
+
+	if offset < 0 {
+		return 0, syscall.EINVAL
+	}
+
+	if h.provider != nil {
+		return 0, syscall.EROFS
+	}
+
+	err := h.checkWrite(offset)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	err = h.chargeGrowth(offset + int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(h.pending) > 0 && offset != h.pendingOffset+int64(len(h.pending)) {
+		err := h.flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(h.pending) == 0 {
+		h.pendingOffset = offset
+	}
+
+	h.pending = append(h.pending, p...)
+	h.dirty = true
+
+	if len(h.pending) >= handleBufferSize {
+		err := h.flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (h *Handle) Write(p []byte) (int, error) {
+	n, err := h.WriteAt(p, h.offset)
+	h.offset += int64(n)
+
+	return n, err
+}
+
+func (h *Handle) Seek(offset int64, whence int) (int64, error) {
+	if h.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += h.offset
+	case io.SeekEnd:
+		size, err := h.Size()
+		if err != nil {
+			return 0, err
+		}
+
+		offset += size
+	default:
+		return 0, syscall.EINVAL
+	}
+
+	if offset < 0 {
+		return 0, syscall.EINVAL
+	}
+
+	h.offset = offset
+
+	return offset, nil
+}
+
+func (h *Handle) Truncate(size int64) error {
+	if h.closed {
+		return os.ErrClosed
+	}
+
+	if size < 0 {
+		return syscall.EINVAL
+	}
+
+	if h.provider != nil {
+		return syscall.EROFS
+	}
+
+	err := h.checkWrite(-1)
+	if err != nil {
+		return err
+	}
+
+	err = h.chargeGrowth(size)
+	if err != nil {
+		return err
+	}
+
+	err = h.flush()
+	if err != nil {
+		return err
+	}
+
+	h.dirty = true
+
+	return h.fileSystem.database.TruncateNodeContent(h.node.GetEntity(), size)
+}
+
+// checkWrite tells whether the file may be written at offset, append only
+// files only at their end. An offset of -1 stands for truncating.
+func (h *Handle) checkWrite(offset int64) error {
+	if h.fileSystem.readOnly {
+		return syscall.EROFS
+	}
+
+	if !h.writable {
+		return syscall.EACCES
+	}
+
+	if h.flags&FlagImmutable != 0 || h.flags&FlagAppend != 0 && offset < 0 {
+		return syscall.EPERM
+	}
+
+	if h.flags&FlagAppend != 0 {
+		size, err := h.Size()
+		if err != nil {
+			return err
+		}
+
+		if offset < size {
+			return syscall.EPERM
+		}
+	}
+
+	return nil
+}
+
+// chargeGrowth charges the quotas of the file for growing it to end. Usage
+// only counts stored content, so buffered data is charged along with it.
+func (h *Handle) chargeGrowth(end int64) error {
+	active, err := h.fileSystem.database.HasQuotas()
+	if err != nil || !active {
+		return err
+	}
+
+	size, err := h.Size()
+	if err != nil {
+		return err
+	}
+
+	if end <= size {
+		return nil
+	}
+
+	stored, err := h.fileSystem.database.GetNodeContentSize(h.node.GetEntity())
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	return h.fileSystem.chargeQuota(quotaCharge{
+		path:  h.node.GetPath(),
+		uid:   h.node.GetUid(),
+		gid:   h.node.GetGid(),
+		bytes: end - stored,
+	})
+}
+
+// Sync writes buffered data and refreshes the content hash
+func (h *Handle) Sync() error {
+	if h.closed {
+		return os.ErrClosed
+	}
+
+	err := h.flush()
+	if err != nil {
+		return err
+	}
+
+	if !h.dirty {
+		return nil
+	}
+
+	// Read from the database, a handle open for writing only has to keep the
+	// hash up to date as well
+	err = h.fileSystem.database.RehashNodeContent(h.node.GetEntity())
+	if err != nil {
+		return err
+	}
+
+	err = h.fileSystem.indexStored(h.node)
+	if err != nil {
+		return err
+	}
+
+	h.dirty = false
+	h.fileSystem.changed(h.node.GetParentId())
+
+	return nil
+}
+
+func (h *Handle) Close() error {
+	if h.closed {
+		return os.ErrClosed
+	}
+
+	err := h.Sync()
+	h.closed = true
+
+	lockErr := h.fileSystem.session.releaseLocks(h)
+	if err == nil {
+		err = lockErr
+	}
+
+	closeErr := h.fileSystem.session.closeNode(h)
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (h *Handle) flush() error {
+	if len(h.pending) == 0 {
+		return nil
+	}
+
+	err := h.fileSystem.database.WriteNodeContentAt(h.node.GetEntity(), h.pendingOffset, h.pending)
+	if err != nil {
+		return err
+	}
+
+	h.pending = h.pending[:0]
+
+	return nil
+}
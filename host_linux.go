@@ -0,0 +1,77 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"io/fs"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type hostInfo struct {
+	uid        int
+	gid        int
+	accessTime time.Time
+}
+
+func getHostInfo(info fs.FileInfo) hostInfo {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return hostInfo{accessTime: info.ModTime()}
+	}
+
+	return hostInfo{
+		uid:        int(stat.Uid),
+		gid:        int(stat.Gid),
+		accessTime: time.Unix(stat.Atim.Unix()),
+	}
+}
+
+func listHostXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, ignoreHostError(err)
+	}
+
+	names := make([]byte, size)
+
+	size, err = syscall.Listxattr(path, names)
+	if err != nil {
+		return nil, ignoreHostError(err)
+	}
+
+	attributes := make(map[string]string)
+	for _, name := range strings.Split(strings.TrimRight(string(names[:size]), "\x00"), "\x00") {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+
+		value := make([]byte, size)
+
+		size, err = syscall.Getxattr(path, name, value)
+		if err != nil {
+			continue
+		}
+
+		attributes[name] = string(value[:size])
+	}
+
+	return attributes, nil
+}
+
+func setHostXattr(path string, key string, value string) error {
+	return ignoreHostError(syscall.Setxattr(path, key, []byte(value), 0))
+}
+
+// ignoreHostError drops the errors of metadata the host is not able or willing
+// to store, such as ownership without privileges or xattrs on tmpfs
+func ignoreHostError(err error) error {
+	switch err {
+	case syscall.EPERM, syscall.EACCES, syscall.ENOTSUP, syscall.ENODATA:
+		return nil
+	default:
+		return err
+	}
+}
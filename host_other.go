@@ -0,0 +1,35 @@
+//go:build !linux
+
+package filesystem
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+type hostInfo struct {
+	uid        int
+	gid        int
+	accessTime time.Time
+}
+
+func getHostInfo(info fs.FileInfo) hostInfo {
+	return hostInfo{accessTime: info.ModTime()}
+}
+
+func listHostXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func setHostXattr(path string, key string, value string) error {
+	return nil
+}
+
+func ignoreHostError(err error) error {
+	if os.IsPermission(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,630 @@
+package filesystem
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// TimeFormat is the layout node timestamps are read back in, the driver
+// returns TIMESTAMP columns as RFC 3339 whatever layout they were stored in
+const TimeFormat = time.RFC3339
+
+const (
+	TypeDirectory = "directory"
+	TypeFile      = "file"
+	TypeSymlink   = "symlink"
+)
+
+type TransferOptions struct {
+	// Include limits the transferred files to those matching one of the
+	// patterns, directories are always walked unless they are excluded
+	Include []string
+	// Exclude skips files and whole directories matching one of the patterns
+	Exclude []string
+	// DryRun walks the tree and reports progress without changing anything
+	DryRun bool
+	// Progress is called for every entry that is transferred or skipped
+	Progress func(TransferEvent)
+}
+
+// Patterns use path.Match syntax and are matched against the slash separated
+// path relative to the transferred directory as well as against the base name
+func (o TransferOptions) matches(patterns []string, relativePath string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, relativePath); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, path.Base(relativePath)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o TransferOptions) skip(relativePath string, isDir bool) bool {
+	if o.matches(o.Exclude, relativePath) {
+		return true
+	}
+
+	return !isDir && len(o.Include) > 0 && !o.matches(o.Include, relativePath)
+}
+
+type TransferEvent struct {
+	HostPath string
+	NodePath string
+	Type     string
+	Bytes    int64
+	// Skipped holds the reason the entry was not transferred
+	Skipped string
+}
+
+func (o TransferOptions) report(event TransferEvent) {
+	if o.Progress != nil {
+		o.Progress(event)
+	}
+}
+
+func nodeTime(value string) (time.Time, bool) {
+	parsed, err := time.Parse(TimeFormat, value)
+
+	return parsed, err == nil
+}
+
+type hostImport struct {
+	fileSystem *FileSystem
+	options    TransferOptions
+	root       string
+	imported   map[string]uint64
+	symlinks   []hostSymlink
+}
+
+type hostSymlink struct {
+	hostPath string
+	target   string
+	parentId uint64
+	nodePath string
+	info     fs.FileInfo
+}
+
+// ImportDir copies the contents of a host directory into the directory parentId.
+// Modes, ownership, timestamps and xattrs are kept where the host exposes them,
+// symlinks are kept when their target is part of the import. The VFS has no hard
+// links, a file with several links is imported as separate copies.
+func (f *FileSystem) ImportDir(hostPath string, parentId uint64, options TransferOptions) error {
+	parent, err := f.getNode(parentId)
+	if err != nil {
+		return err
+	}
+
+	if !parent.GetMode().IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	root, err := filepath.Abs(hostPath)
+	if err != nil {
+		return err
+	}
+
+	hostImport := &hostImport{
+		fileSystem: f,
+		options:    options,
+		root:       root,
+		imported:   map[string]uint64{root: parentId},
+	}
+
+	err = hostImport.importDir(root, parentId, parent.GetPath())
+	if err != nil {
+		return err
+	}
+
+	return hostImport.importSymlinks()
+}
+
+func (i *hostImport) relative(hostPath string) string {
+	relativePath, _ := filepath.Rel(i.root, hostPath)
+
+	return filepath.ToSlash(relativePath)
+}
+
+func (i *hostImport) importDir(hostDir string, parentId uint64, parentPath string) error {
+	entries, err := os.ReadDir(hostDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		hostPath := filepath.Join(hostDir, entry.Name())
+		nodePath := path.Join(parentPath, entry.Name())
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		event := TransferEvent{HostPath: hostPath, NodePath: nodePath}
+
+		switch {
+		case info.IsDir():
+			event.Type = TypeDirectory
+		case info.Mode().IsRegular():
+			event.Type = TypeFile
+		case info.Mode()&fs.ModeSymlink != 0:
+			event.Type = TypeSymlink
+		default:
+			event.Skipped = "unsupported file type"
+			i.options.report(event)
+			continue
+		}
+
+		if i.options.skip(i.relative(hostPath), info.IsDir()) {
+			event.Skipped = "filtered"
+			i.options.report(event)
+			continue
+		}
+
+		switch event.Type {
+		case TypeDirectory:
+			err = i.importDirectory(hostPath, nodePath, parentId, entry.Name(), info, event)
+		case TypeFile:
+			err = i.importFile(hostPath, parentId, entry.Name(), info, event)
+		case TypeSymlink:
+			err = i.queueSymlink(hostPath, nodePath, parentId, info)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *hostImport) importDirectory(hostPath string, nodePath string, parentId uint64, name string, info fs.FileInfo, event TransferEvent) error {
+	i.options.report(event)
+
+	if i.options.DryRun {
+		i.imported[hostPath] = 0
+		return i.importDir(hostPath, 0, nodePath)
+	}
+
+	node, _, err := i.fileSystem.LookupOrMkDir(parentId, name)
+	if err != nil {
+		return err
+	}
+
+	if !node.GetMode().IsDir() {
+		return &fs.PathError{Op: "import", Path: node.GetPath(), Err: syscall.ENOTDIR}
+	}
+
+	i.imported[hostPath] = node.GetId()
+
+	err = i.importDir(hostPath, node.GetId(), node.GetPath())
+	if err != nil {
+		return err
+	}
+
+	// Applied after the children so their creation does not touch the timestamps
+	return i.importMetadata(hostPath, node, info)
+}
+
+func (i *hostImport) importFile(hostPath string, parentId uint64, name string, info fs.FileInfo, event TransferEvent) error {
+	event.Bytes = info.Size()
+
+	if i.options.DryRun {
+		i.imported[hostPath] = 0
+		i.options.report(event)
+		return nil
+	}
+
+	node, _, err := i.fileSystem.LookupOrTouch(parentId, name)
+	if err != nil {
+		return err
+	}
+
+	if !node.GetMode().IsRegular() {
+		return &fs.PathError{Op: "import", Path: node.GetPath(), Err: syscall.EISDIR}
+	}
+
+	file, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	handle, err := i.fileSystem.OpenFile(node.GetId())
+	if err != nil {
+		return err
+	}
+
+	err = handle.Truncate(0)
+	if err == nil {
+		event.Bytes, err = io.CopyBuffer(handle, file, make([]byte, handleBufferSize))
+	}
+
+	closeErr := handle.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	i.imported[hostPath] = node.GetId()
+	i.options.report(event)
+
+	return i.importMetadata(hostPath, node, info)
+}
+
+func (i *hostImport) queueSymlink(hostPath string, nodePath string, parentId uint64, info fs.FileInfo) error {
+	target, err := os.Readlink(hostPath)
+	if err != nil {
+		return err
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(hostPath), target)
+	}
+
+	i.symlinks = append(i.symlinks, hostSymlink{
+		hostPath: hostPath,
+		target:   filepath.Clean(target),
+		parentId: parentId,
+		nodePath: nodePath,
+		info:     info,
+	})
+
+	return nil
+}
+
+// importSymlinks links the symlinks once every possible target has been imported
+func (i *hostImport) importSymlinks() error {
+	for _, symlink := range i.symlinks {
+		event := TransferEvent{HostPath: symlink.hostPath, NodePath: symlink.nodePath, Type: TypeSymlink}
+
+		targetId, ok := i.imported[symlink.target]
+		if !ok {
+			event.Skipped = "target is not part of the import"
+			i.options.report(event)
+			continue
+		}
+
+		i.options.report(event)
+
+		if i.options.DryRun {
+			continue
+		}
+
+		name := path.Base(symlink.nodePath)
+
+		node, err := i.fileSystem.Lookup(symlink.parentId, name)
+		switch err {
+		case nil:
+			if node.GetMode()&fs.ModeSymlink == 0 {
+				return &fs.PathError{Op: "import", Path: node.GetPath(), Err: syscall.EEXIST}
+			}
+
+			err = i.fileSystem.RemoveFile(node.GetId())
+			if err != nil {
+				return err
+			}
+		case syscall.ENOENT:
+		default:
+			return err
+		}
+
+		err = i.fileSystem.Link(targetId, name, symlink.parentId)
+		if err != nil {
+			return err
+		}
+
+		node, err = i.fileSystem.Lookup(symlink.parentId, name)
+		if err != nil {
+			return err
+		}
+
+		err = i.importMetadata(symlink.hostPath, node, symlink.info)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *hostImport) importMetadata(hostPath string, node interfaces.Node, info fs.FileInfo) error {
+	hostInfo := getHostInfo(info)
+
+	node.SetMode(uint32(info.Mode()))
+	node.SetUid(hostInfo.uid)
+	node.SetGid(hostInfo.gid)
+	node.SetModTime(info.ModTime().UTC().Format(TimeFormat))
+	node.SetAccessTime(hostInfo.accessTime.UTC().Format(TimeFormat))
+	node.SetCreateTime(time.Now().UTC().Format(TimeFormat))
+
+	err := i.fileSystem.Save(node)
+	if err != nil {
+		return err
+	}
+
+	// The xattr syscalls follow symlinks, reading them would read the target
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+
+	attributes, err := listHostXattrs(hostPath)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range attributes {
+		err := i.fileSystem.SetXattr(node.GetId(), key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type hostExport struct {
+	fileSystem *FileSystem
+	options    TransferOptions
+	rootPath   string
+}
+
+// ExportDir writes the contents of the directory id into a host directory,
+// creating it when needed. Metadata is restored as far as the host and the
+// privileges of the process allow, symlinks are written relative and only
+// when their target is part of the export.
+func (f *FileSystem) ExportDir(id uint64, hostPath string, options TransferOptions) error {
+	node, err := f.getNode(id)
+	if err != nil {
+		return err
+	}
+
+	if !node.GetMode().IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	if !options.DryRun {
+		err = os.MkdirAll(hostPath, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	hostExport := &hostExport{
+		fileSystem: f,
+		options:    options,
+		rootPath:   node.GetPath(),
+	}
+
+	return hostExport.exportDir(node, hostPath)
+}
+
+func (e *hostExport) relative(node interfaces.Node) string {
+	if e.rootPath == "/" {
+		return strings.TrimPrefix(node.GetPath(), "/")
+	}
+
+	return strings.TrimPrefix(node.GetPath(), e.rootPath+"/")
+}
+
+// hostName reports whether a node name can be joined to a host directory
+// without naming that directory, its parent or something further down
+func hostName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+
+	return !strings.ContainsRune(name, '/') && !strings.ContainsRune(name, os.PathSeparator)
+}
+
+func (e *hostExport) exportDir(parent interfaces.Node, hostDir string) error {
+	children, err := e.fileSystem.ReadDir(parent.GetId())
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].GetName() < children[j].GetName() })
+
+	for _, child := range children {
+		if !hostName(child.GetName()) {
+			e.options.report(TransferEvent{NodePath: child.GetPath(), Skipped: "unsafe name"})
+			continue
+		}
+
+		hostPath := filepath.Join(hostDir, child.GetName())
+		event := TransferEvent{HostPath: hostPath, NodePath: child.GetPath()}
+
+		switch {
+		case child.GetMode().IsDir():
+			event.Type = TypeDirectory
+		case child.GetMode()&fs.ModeSymlink != 0:
+			event.Type = TypeSymlink
+		case child.GetMode().IsRegular():
+			event.Type = TypeFile
+		default:
+			event.Skipped = "unsupported file type"
+			e.options.report(event)
+			continue
+		}
+
+		if e.options.skip(e.relative(child), child.GetMode().IsDir()) {
+			event.Skipped = "filtered"
+			e.options.report(event)
+			continue
+		}
+
+		switch event.Type {
+		case TypeDirectory:
+			err = e.exportDirectory(child, hostPath, event)
+		case TypeFile:
+			err = e.exportFile(child, hostPath, event)
+		case TypeSymlink:
+			err = e.exportSymlink(child, hostPath, event)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *hostExport) exportDirectory(node interfaces.Node, hostPath string, event TransferEvent) error {
+	e.options.report(event)
+
+	if !e.options.DryRun {
+		err := os.Mkdir(hostPath, 0700)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+
+	err := e.exportDir(node, hostPath)
+	if err != nil {
+		return err
+	}
+
+	return e.exportMetadata(node, hostPath, 0755)
+}
+
+func (e *hostExport) exportFile(node interfaces.Node, hostPath string, event TransferEvent) error {
+	handle, err := e.fileSystem.OpenFile(node.GetId())
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	if e.options.DryRun {
+		event.Bytes, err = handle.Size()
+		if err != nil {
+			return err
+		}
+
+		e.options.report(event)
+		return nil
+	}
+
+	file, err := os.OpenFile(hostPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	event.Bytes, err = io.CopyBuffer(file, handle, make([]byte, 1<<20))
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	e.options.report(event)
+
+	return e.exportMetadata(node, hostPath, 0644)
+}
+
+func (e *hostExport) exportSymlink(node interfaces.Node, hostPath string, event TransferEvent) error {
+	target, err := e.fileSystem.ReadLink(node.GetId())
+	if err != nil && err != syscall.ENOENT {
+		return err
+	}
+
+	if err == syscall.ENOENT || (e.rootPath != "/" && target != e.rootPath && !strings.HasPrefix(target, e.rootPath+"/")) {
+		event.Skipped = "target is not part of the export"
+		e.options.report(event)
+		return nil
+	}
+
+	e.options.report(event)
+
+	if e.options.DryRun {
+		return nil
+	}
+
+	relativeTarget, err := filepath.Rel(path.Dir(node.GetPath()), target)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(hostPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	err = os.Symlink(relativeTarget, hostPath)
+	if err != nil {
+		return err
+	}
+
+	return ignoreHostError(os.Lchown(hostPath, node.GetUid(), node.GetGid()))
+}
+
+// exportMetadata restores mode, ownership, xattrs and timestamps in that order,
+// nodes created without permission bits get defaultPerm so they stay usable
+func (e *hostExport) exportMetadata(node interfaces.Node, hostPath string, defaultPerm fs.FileMode) error {
+	if e.options.DryRun {
+		return nil
+	}
+
+	mode := node.GetMode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	if mode&fs.ModePerm == 0 {
+		mode |= defaultPerm
+	}
+
+	err := os.Chmod(hostPath, mode)
+	if err != nil {
+		return err
+	}
+
+	err = ignoreHostError(os.Chown(hostPath, node.GetUid(), node.GetGid()))
+	if err != nil {
+		return err
+	}
+
+	keys, err := e.fileSystem.ListXattr(node.GetId())
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		value, err := e.fileSystem.GetXattr(node.GetId(), key)
+		if err != nil {
+			return err
+		}
+
+		err = setHostXattr(hostPath, key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	modTime, ok := nodeTime(node.GetModTime())
+	if !ok {
+		return nil
+	}
+
+	accessTime, ok := nodeTime(node.GetAccessTime())
+	if !ok {
+		accessTime = modTime
+	}
+
+	return os.Chtimes(hostPath, accessTime, modTime)
+}
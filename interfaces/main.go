@@ -2,6 +2,7 @@ package interfaces
 
 import (
 	"io/fs"
+	"time"
 
 	database_interfaces "github.com/sushydev/vfs_go/internal/database/interfaces"
 )
@@ -29,6 +30,10 @@ type Node interface {
 	GetModTime() string
 	GetCreateTime() string
 	GetAccessTime() string
+	// GetSize is the length of the stored content of a file
+	GetSize() int64
+	// GetFlags holds attributes like immutable and append only, see chattr
+	GetFlags() uint32
 
 	SetName(name string)
 	SetParentId(parentId uint64)
@@ -55,6 +60,20 @@ type NodeContent interface {
 	GetEntity() database_interfaces.NodeContent
 }
 
+type NodeAttribute interface {
+	Entry
+
+	GetNodeId() uint64
+	GetKey() string
+	GetValue() string
+
+	SetNodeId(nodeId uint64)
+	SetKey(key string)
+	SetValue(value string)
+
+	GetEntity() database_interfaces.NodeAttribute
+}
+
 type Symlink interface {
 	Entry
 
@@ -66,3 +85,19 @@ type Symlink interface {
 
 	GetEntity() database_interfaces.Symlink
 }
+
+// ContentProvider serves the content of provider backed files. The key is
+// whatever the file was created with, its meaning is up to the provider.
+// ReadAt behaves like io.ReaderAt.
+type ContentProvider interface {
+	Size(key string) (int64, error)
+	ReadAt(key string, p []byte, offset int64) (int, error)
+	Stat(key string) (ContentInfo, error)
+}
+
+type ContentInfo struct {
+	Size    int64
+	ModTime time.Time
+	// Version changes whenever the content does, an ETag for instance
+	Version string
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// IsUniqueViolation tells whether err is SQLite refusing a row whose name,
+// path or key another row already has
+func IsUniqueViolation(err error) bool {
+	return hasCode(err, sqlite3.SQLITE_CONSTRAINT_UNIQUE)
+}
+
+// IsForeignKeyViolation tells whether err is SQLite refusing a row that
+// refers to one that does not exist (anymore)
+func IsForeignKeyViolation(err error) bool {
+	return hasCode(err, sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY)
+}
+
+func hasCode(err error, code int) bool {
+	var sqliteErr *sqlite.Error
+
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == code
+}
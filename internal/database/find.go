@@ -0,0 +1,131 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+// NodeFilter narrows down FindNodes, fields left at their zero value do not filter
+type NodeFilter struct {
+	// Prefix limits results to this path and everything below it
+	Prefix string
+	// Depth is the number of path segments results have
+	Depth int
+	// Glob and NameGlob are SQLite GLOB patterns for the path and the name
+	Glob     string
+	NameGlob string
+	// Results have mode & ModeMask == ModeValue
+	ModeMask  int64
+	ModeValue int64
+	MinSize   int64
+	MaxSize   int64
+	Uid       *int
+	Gid       *int
+	// ModifiedAfter and ModifiedBefore are compared with the stored times as they are
+	ModifiedAfter  string
+	ModifiedBefore string
+	// After continues a listing in path order after this path
+	After string
+	Limit int
+}
+
+func (database *Database) FindNodes(filter NodeFilter) ([]interfaces.Node, error) {
+	conditions := []string{"namespace_id = ?", "id NOT IN (SELECT node_id FROM unlinked_nodes)"}
+	args := []any{database.namespace}
+
+	if filter.Prefix != "" && filter.Prefix != "/" {
+		conditions = append(conditions, subtreeCondition)
+		args = append(args, subtreeArgs(filter.Prefix)...)
+	}
+
+	if filter.Depth > 0 {
+		conditions = append(conditions, "path != '/' AND length(path) - length(replace(path, '/', '')) = ?")
+		args = append(args, filter.Depth)
+	}
+
+	if filter.Glob != "" {
+		conditions = append(conditions, "path GLOB ?")
+		args = append(args, filter.Glob)
+	}
+
+	if filter.NameGlob != "" {
+		conditions = append(conditions, "name GLOB ?")
+		args = append(args, filter.NameGlob)
+	}
+
+	if filter.ModeMask != 0 {
+		conditions = append(conditions, "mode & ? = ?")
+		args = append(args, filter.ModeMask, filter.ModeValue)
+	}
+
+	if filter.MinSize > 0 {
+		conditions = append(conditions, "size >= ?")
+		args = append(args, filter.MinSize)
+	}
+
+	if filter.MaxSize > 0 {
+		conditions = append(conditions, "size <= ?")
+		args = append(args, filter.MaxSize)
+	}
+
+	if filter.Uid != nil {
+		conditions = append(conditions, "uid = ?")
+		args = append(args, *filter.Uid)
+	}
+
+	if filter.Gid != nil {
+		conditions = append(conditions, "gid = ?")
+		args = append(args, *filter.Gid)
+	}
+
+	if filter.ModifiedAfter != "" {
+		conditions = append(conditions, "mod_time >= ?")
+		args = append(args, filter.ModifiedAfter)
+	}
+
+	if filter.ModifiedBefore != "" {
+		conditions = append(conditions, "mod_time < ?")
+		args = append(args, filter.ModifiedBefore)
+	}
+
+	if filter.After != "" {
+		conditions = append(conditions, "path > ?")
+		args = append(args, filter.After)
+	}
+
+	query := "SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size, flags FROM nodes WHERE " + strings.Join(conditions, " AND ")
+
+	query += " ORDER BY path"
+
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := database.reader.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []interfaces.Node
+	for rows.Next() {
+		node, err := database.nodeFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, rows.Err()
+}
+
+// subtreeCondition matches path and everything below it, given subtreeArgs.
+// Descendants sort between path + "/" and path + "0", the character after
+// the slash, so the path index serves it.
+const subtreeCondition = "(path = ? OR (path >= ? AND path < ?))"
+
+func subtreeArgs(path string) []any {
+	return []any{path, path + "/", path + "0"}
+}
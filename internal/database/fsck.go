@@ -0,0 +1,177 @@
+package database
+
+import (
+	"io/fs"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+// Queries used by the integrity checker, they look for rows that the foreign
+// keys would have prevented had they been enforced when the rows were written.
+// Rows of a node are checked in the namespace of the node, rows whose node is
+// gone belong to no namespace and are looked for across the whole database.
+
+func (database *Database) GetOrphanNodeContentIds() ([]int64, error) {
+	return database.queryIds(`
+		SELECT c.id
+		FROM node_contents c
+		LEFT JOIN nodes n ON n.id = c.node_id
+		WHERE n.id IS NULL
+		ORDER BY c.id
+	`)
+}
+
+func (database *Database) GetMisplacedNodeContentIds() ([]int64, error) {
+	return database.queryIds(`
+		SELECT c.id
+		FROM node_contents c
+		JOIN nodes n ON n.id = c.node_id
+		WHERE n.namespace_id = ? AND n.mode & ? != 0
+		ORDER BY c.id
+	`, database.namespace, int64(fs.ModeType))
+}
+
+func (database *Database) GetOrphanNodeAttributeIds() ([]int64, error) {
+	return database.queryIds(`
+		SELECT a.id
+		FROM node_attributes a
+		LEFT JOIN nodes n ON n.id = a.node_id
+		WHERE n.id IS NULL
+		ORDER BY a.id
+	`)
+}
+
+// GetDanglingSymlinks returns the symlinks of the namespace whose target is gone
+func (database *Database) GetDanglingSymlinks() ([]interfaces.Symlink, error) {
+	return database.querySymlinks(`
+		SELECT s.id, s.source_node_id, s.target_node_id
+		FROM symlinks s
+		JOIN nodes source ON source.id = s.source_node_id
+		LEFT JOIN nodes target ON target.id = s.target_node_id
+		WHERE source.namespace_id = ? AND target.id IS NULL
+		ORDER BY s.id
+	`, database.namespace)
+}
+
+// GetOrphanSymlinks returns the symlinks whose source node is gone
+func (database *Database) GetOrphanSymlinks() ([]interfaces.Symlink, error) {
+	return database.querySymlinks(`
+		SELECT s.id, s.source_node_id, s.target_node_id
+		FROM symlinks s
+		LEFT JOIN nodes source ON source.id = s.source_node_id
+		WHERE source.id IS NULL
+		ORDER BY s.id
+	`)
+}
+
+func (database *Database) querySymlinks(query string, args ...any) ([]interfaces.Symlink, error) {
+	rows, err := database.reader.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var symlinks []interfaces.Symlink
+	for rows.Next() {
+		symlink, err := database.symlinkFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		symlinks = append(symlinks, symlink)
+	}
+
+	return symlinks, rows.Err()
+}
+
+func (database *Database) GetSymlinkNodesWithoutTarget() ([]interfaces.Node, error) {
+	rows, err := database.reader.Query(`
+		SELECT n.id, n.name, n.parent_id, n.path, n.mode, n.uid, n.gid, n.mod_time, n.create_time, n.access_time, n.size, n.flags
+		FROM nodes n
+		LEFT JOIN symlinks s ON s.source_node_id = n.id
+		WHERE n.namespace_id = ? AND n.mode & ? != 0 AND s.id IS NULL
+		ORDER BY n.id
+	`, database.namespace, int64(fs.ModeSymlink))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []interfaces.Node
+	for rows.Next() {
+		node, err := database.nodeFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, rows.Err()
+}
+
+// EachNodeContent calls fn for every content row of the namespace, one row in memory at a time
+func (database *Database) EachNodeContent(fn func(interfaces.NodeContent) error) error {
+	rows, err := database.reader.Query(`
+		SELECT c.id, c.node_id, c.content, c.hash
+		FROM node_contents c
+		JOIN nodes n ON n.id = c.node_id
+		WHERE n.namespace_id = ?
+		ORDER BY c.id
+	`, database.namespace)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		nodeContent, err := database.nodeContentFactory.New(rows)
+		if err != nil {
+			return err
+		}
+
+		err = fn(nodeContent)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (database *Database) UpdateNodeContentHash(id int64, hash string) error {
+	_, err := database.db.Exec("UPDATE node_contents SET hash = ? WHERE id = ?", hash, id)
+
+	return err
+}
+
+func (database *Database) DeleteNodeContent(id int64) error {
+	_, err := database.db.Exec("DELETE FROM node_contents WHERE id = ?", id)
+
+	return err
+}
+
+func (database *Database) DeleteNodeAttribute(id int64) error {
+	_, err := database.db.Exec("DELETE FROM node_attributes WHERE id = ?", id)
+
+	return err
+}
+
+func (database *Database) queryIds(query string, args ...any) ([]int64, error) {
+	rows, err := database.reader.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
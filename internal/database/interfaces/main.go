@@ -16,6 +16,8 @@ type Node interface{
 	GetModTime() string
 	GetCreateTime() string
 	GetAccessTime() string
+	GetSize() int64
+	GetFlags() int64
 
 	SetName(string)
 	SetParentId(int64)
@@ -26,6 +28,8 @@ type Node interface{
 	SetModTime(string)
 	SetCreateTime(string)
 	SetAccessTime(string)
+	SetSize(int64)
+	SetFlags(int64)
 }
 
 type NodeRelationship interface {
@@ -38,7 +42,10 @@ type NodeContent interface {
 	NodeRelationship
 
 	GetContent() []byte
+	GetHash() string
+
 	SetContent([]byte)
+	SetHash(string)
 }
 
 type NodeAttribute interface {
@@ -47,6 +54,9 @@ type NodeAttribute interface {
 
 	GetKey() string
 	GetValue() string
+
+	SetKey(string)
+	SetValue(string)
 }
 
 type Symlink interface {
@@ -59,6 +69,41 @@ type Symlink interface {
 	SetTargetNodeId(int64)
 }
 
+type Mount interface {
+	Entity
+
+	GetNodeId() int64
+	GetKind() string
+	GetSource() string
+
+	SetNodeId(int64)
+}
+
+type Quota interface {
+	Entity
+
+	GetKind() string
+	GetSubject() int64
+	GetSoftBytes() int64
+	GetHardBytes() int64
+	GetSoftNodes() int64
+	GetHardNodes() int64
+	GetGrace() int64
+	GetSoftExceeded() string
+	// GetUsedNodes and GetUsedBytes are the usage kept with the quota
+	GetUsedNodes() int64
+	GetUsedBytes() int64
+
+	SetSoftExceeded(string)
+}
+
+type Namespace interface {
+	Entity
+
+	GetName() string
+	GetRootId() int64
+}
+
 type Database interface {
 	GetNode(id int64) (Node, error)
 	SaveNode(Node) error
@@ -0,0 +1,352 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// LockEnd is the end of a range that reaches to the end of the file
+const LockEnd = math.MaxInt64
+
+var (
+	// ErrLockConflict is returned by AcquireLock while another owner holds a conflicting lock
+	ErrLockConflict = errors.New("lock is held by another owner")
+	// ErrDeadlock is returned by AcquireLock when waiting would close a cycle of waiting owners
+	ErrDeadlock = errors.New("waiting for the lock would deadlock")
+)
+
+// Lock is a byte range of a node locked by an owner of a session. The range
+// is Start up to End, End itself not included.
+type Lock struct {
+	SessionId int64
+	Owner     string
+	Handle    int64
+	NodeId    int64
+	Exclusive bool
+	Start     int64
+	End       int64
+	// Pid is the process of the session, GetLocks fills it in
+	Pid int
+}
+
+const (
+	lockShared    = "shared"
+	lockExclusive = "exclusive"
+)
+
+func (lock Lock) kind() string {
+	if lock.Exclusive {
+		return lockExclusive
+	}
+
+	return lockShared
+}
+
+type lockOwner struct {
+	sessionId int64
+	owner     string
+}
+
+// AcquireLock gives lock to its owner, replacing what the owner held of its
+// range before. When another owner holds a conflicting lock it fails with
+// ErrLockConflict, after recording that the owner waits for the lock when
+// wait is set. Waiting is refused with ErrDeadlock when the owners in the
+// way wait, directly or through others, for the owner of lock. Sessions that
+// expired before now are purged along with their locks first.
+func (database *Database) AcquireLock(lock Lock, wait bool, now int64) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = purgeSessions(tx, now)
+	if err != nil {
+		return err
+	}
+
+	self := lockOwner{lock.SessionId, lock.Owner}
+
+	blockers, err := lockBlockers(tx, self, lock)
+	if err != nil {
+		return err
+	}
+
+	if len(blockers) == 0 {
+		err = replaceLockRange(tx, lock, true)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM lock_waits WHERE session_id = ? AND owner = ?", lock.SessionId, lock.Owner)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+
+	if !wait {
+		return ErrLockConflict
+	}
+
+	deadlock, err := lockCycle(tx, self, blockers)
+	if err != nil {
+		return err
+	}
+
+	if deadlock {
+		_, err = tx.Exec("DELETE FROM lock_waits WHERE session_id = ? AND owner = ?", lock.SessionId, lock.Owner)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+
+		return ErrDeadlock
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO lock_waits (session_id, owner, node_id, kind, range_start, range_end) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (session_id, owner) DO UPDATE
+		SET node_id = excluded.node_id, kind = excluded.kind, range_start = excluded.range_start, range_end = excluded.range_end
+	`, lock.SessionId, lock.Owner, lock.NodeId, lock.kind(), lock.Start, lock.End)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return ErrLockConflict
+}
+
+// ReleaseLock drops what the owner of lock holds of its range
+func (database *Database) ReleaseLock(lock Lock) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceLockRange(tx, lock, false)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseHandleLocks drops the locks taken through a handle of a session
+// and stops it waiting
+func (database *Database) ReleaseHandleLocks(sessionId int64, handle int64, owner string) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM locks WHERE session_id = ? AND handle = ?", sessionId, handle)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM lock_waits WHERE session_id = ? AND owner = ?", sessionId, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelLockWait records that an owner no longer waits for a lock
+func (database *Database) CancelLockWait(sessionId int64, owner string) error {
+	_, err := database.db.Exec("DELETE FROM lock_waits WHERE session_id = ? AND owner = ?", sessionId, owner)
+
+	return err
+}
+
+// GetLocks returns the locks held on a node ordered by range
+func (database *Database) GetLocks(nodeId int64, now int64) ([]Lock, error) {
+	rows, err := database.reader.Query(`
+		SELECT l.session_id, l.owner, l.handle, l.node_id, l.kind, l.range_start, l.range_end, s.pid
+		FROM locks l
+		JOIN sessions s ON s.id = l.session_id
+		WHERE l.node_id = ? AND s.expires >= ?
+		ORDER BY l.range_start, l.id
+	`, nodeId, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locks []Lock
+	for rows.Next() {
+		var lock Lock
+		var kind string
+
+		err := rows.Scan(&lock.SessionId, &lock.Owner, &lock.Handle, &lock.NodeId, &kind, &lock.Start, &lock.End, &lock.Pid)
+		if err != nil {
+			return nil, err
+		}
+
+		lock.Exclusive = kind == lockExclusive
+		locks = append(locks, lock)
+	}
+
+	return locks, rows.Err()
+}
+
+// lockBlockers returns the owners other than self holding locks that conflict with lock
+func lockBlockers(tx *sql.Tx, self lockOwner, lock Lock) ([]lockOwner, error) {
+	rows, err := tx.Query(`
+		SELECT DISTINCT session_id, owner
+		FROM locks
+		WHERE node_id = ? AND range_start < ? AND range_end > ? AND (kind = ? OR ? = ?)
+		AND NOT (session_id = ? AND owner = ?)
+	`, lock.NodeId, lock.End, lock.Start, lockExclusive, lock.kind(), lockExclusive, self.sessionId, self.owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var owners []lockOwner
+	for rows.Next() {
+		var owner lockOwner
+
+		err := rows.Scan(&owner.sessionId, &owner.owner)
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, owner)
+	}
+
+	return owners, rows.Err()
+}
+
+// lockCycle follows who the blockers of self are waiting for in turn, it
+// tells whether that leads back to self
+func lockCycle(tx *sql.Tx, self lockOwner, blockers []lockOwner) (bool, error) {
+	visited := map[lockOwner]bool{}
+
+	for len(blockers) > 0 {
+		owner := blockers[len(blockers)-1]
+		blockers = blockers[:len(blockers)-1]
+
+		if owner == self {
+			return true, nil
+		}
+
+		if visited[owner] {
+			continue
+		}
+		visited[owner] = true
+
+		waiting := Lock{SessionId: owner.sessionId, Owner: owner.owner}
+		var kind string
+
+		err := tx.QueryRow(
+			"SELECT node_id, kind, range_start, range_end FROM lock_waits WHERE session_id = ? AND owner = ?",
+			owner.sessionId, owner.owner,
+		).Scan(&waiting.NodeId, &kind, &waiting.Start, &waiting.End)
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		waiting.Exclusive = kind == lockExclusive
+
+		next, err := lockBlockers(tx, owner, waiting)
+		if err != nil {
+			return false, err
+		}
+
+		blockers = append(blockers, next...)
+	}
+
+	return false, nil
+}
+
+// replaceLockRange cuts the range of lock out of what its owner holds of the
+// node, keeping the parts around it, and adds lock itself when add is set
+func replaceLockRange(tx *sql.Tx, lock Lock, add bool) error {
+	rows, err := tx.Query(`
+		SELECT id, handle, kind, range_start, range_end
+		FROM locks
+		WHERE session_id = ? AND owner = ? AND node_id = ? AND range_start < ? AND range_end > ?
+	`, lock.SessionId, lock.Owner, lock.NodeId, lock.End, lock.Start)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	var remains []Lock
+
+	for rows.Next() {
+		var id int64
+		var kind string
+		held := lock
+
+		err := rows.Scan(&id, &held.Handle, &kind, &held.Start, &held.End)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		held.Exclusive = kind == lockExclusive
+
+		ids = append(ids, fmt.Sprint(id))
+
+		if held.Start < lock.Start {
+			before := held
+			before.End = lock.Start
+			remains = append(remains, before)
+		}
+
+		if held.End > lock.End {
+			after := held
+			after.Start = lock.End
+			remains = append(remains, after)
+		}
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	if len(ids) > 0 {
+		_, err = tx.Exec("DELETE FROM locks WHERE id IN (" + strings.Join(ids, ", ") + ")")
+		if err != nil {
+			return err
+		}
+	}
+
+	if add {
+		remains = append(remains, lock)
+	}
+
+	for _, remain := range remains {
+		_, err = tx.Exec(
+			"INSERT INTO locks (session_id, owner, handle, node_id, kind, range_start, range_end) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			remain.SessionId, remain.Owner, remain.Handle, remain.NodeId, remain.kind(), remain.Start, remain.End,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
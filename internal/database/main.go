@@ -2,10 +2,18 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/sushydev/vfs_go/internal/database/interfaces"
+	mount_factory "github.com/sushydev/vfs_go/internal/database/mount/factory"
+	namespace_factory "github.com/sushydev/vfs_go/internal/database/namespace/factory"
 	node_factory "github.com/sushydev/vfs_go/internal/database/node/factory"
+	node_attribute_factory "github.com/sushydev/vfs_go/internal/database/node_attribute/factory"
 	node_content_factory "github.com/sushydev/vfs_go/internal/database/node_content/factory"
+	quota_factory "github.com/sushydev/vfs_go/internal/database/quota/factory"
 	symlink_factory "github.com/sushydev/vfs_go/internal/database/symlink/factory"
 
 	_ "modernc.org/sqlite"
@@ -40,10 +48,6 @@ CREATE INDEX IF NOT EXISTS idx_nodes_type ON nodes(mode);
 -- Index for faster parent directory lookups
 CREATE INDEX IF NOT EXISTS idx_nodes_parent ON nodes(parent_id);
 
--- Insert the root directory
-INSERT OR IGNORE INTO nodes (id, name, parent_id, path, mode, mod_time, create_time, access_time)
-VALUES (0, 'root', -1, '/', 2147483648, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
-
 ---- File contents table that stores file content ----
 
 -- File contents table that stores file content
@@ -90,25 +94,208 @@ CREATE INDEX IF NOT EXISTS idx_symlinks_source ON symlinks(source_node_id);
 CREATE INDEX IF NOT EXISTS idx_symlinks_target ON symlinks(target_node_id);
 `
 
+// Options tune the connections to the database, see the PRAGMA of the same
+// name for each. The filesystem package fills in its defaults.
+type Options struct {
+	ReadOnly    bool
+	JournalMode string
+	Synchronous string
+	BusyTimeout time.Duration
+	// CacheSize is the page cache of each connection in KiB
+	CacheSize   int64
+	MmapSize    int64
+	ForeignKeys bool
+	// MaxReaders and MaxWriters size the pools queries and changes go through
+	MaxReaders  int
+	MaxWriters  int
+}
+
 type Database struct {
+	// db is the pool changes go through, reader the one for queries
 	db          *sql.DB
+	reader      *sql.DB
 	nodeFactory *node_factory.Factory
 	nodeContentFactory *node_content_factory.Factory
 	symlinkFactory *symlink_factory.Factory
+	nodeAttributeFactory *node_attribute_factory.Factory
+	mountFactory *mount_factory.Factory
+	quotaFactory *quota_factory.Factory
+	namespaceFactory *namespace_factory.Factory
+	// namespace is the namespace node queries are limited to, see InNamespace
+	namespace int64
 }
 
 var _ interfaces.Database = &Database{}
 
-func New(path string) (*Database, error) {
-	db, err := sql.Open("sqlite", path)
+// New opens the database at path, creating and migrating it as needed. A
+// read-only database is opened as it is, it has to be current already.
+func New(path string, options Options) (*Database, error) {
+	if options.ReadOnly {
+		dataSource, err := dataSource(path, url.Values{"mode": {"ro"}}, options.pragmas(false, "query_only(1)"))
+		if err != nil {
+			return nil, err
+		}
+
+		db, err := openPool(dataSource, options.MaxReaders)
+		if err != nil {
+			return nil, err
+		}
+
+		err = checkVersion(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		return &Database{db: db, reader: db}, nil
+	}
+
+	err := prepare(path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Writers begin their transactions right away rather than on their first
+	// change, so waiting for the lock is left to the busy timeout instead of
+	// failing with SQLITE_BUSY halfway through
+	writerSource, err := dataSource(path, url.Values{"_txlock": {"immediate"}}, options.pragmas(true))
+	if err != nil {
+		return nil, err
+	}
+
+	readerSource, err := dataSource(path, options.pragmas(false, "query_only(1)"))
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openPool(writerSource, options.MaxWriters)
 	if err != nil {
 		return nil, err
 	}
 
+	reader, err := openPool(readerSource, options.MaxReaders)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Database{db: db, reader: reader}, nil
+}
+
+// prepare creates and migrates the database on a connection of its own.
+// Migrations rebuild tables, with foreign keys enforced dropping one would
+// cascade into the rows referring to it.
+func prepare(path string, options Options) error {
+	dataSource, err := dataSource(path, (&Options{BusyTimeout: options.BusyTimeout}).pragmas(false, "foreign_keys(0)"))
+	if err != nil {
+		return err
+	}
+
+	db, err := openPool(dataSource, 1)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if options.JournalMode != "" {
+		_, err = db.Exec("PRAGMA journal_mode = " + options.JournalMode)
+		if err != nil {
+			return err
+		}
+	}
+
 	_, err = db.Exec(schema)
+	if err != nil {
+		return err
+	}
+
+	err = migrate(db)
+	if err != nil {
+		return err
+	}
+
+	return (&Database{db: db, reader: db}).ensureDefaultNamespace()
+}
+
+// pragmas returns the options as _pragma parameters of the driver, which
+// runs them on every connection it opens. The journal mode is only set by
+// writers, switching it takes a write lock.
+func (options *Options) pragmas(writer bool, extra ...string) url.Values {
+	pragmas := []string{fmt.Sprintf("busy_timeout(%d)", options.BusyTimeout.Milliseconds())}
+
+	if writer && options.JournalMode != "" {
+		pragmas = append(pragmas, "journal_mode("+options.JournalMode+")")
+	}
+
+	if options.Synchronous != "" {
+		pragmas = append(pragmas, "synchronous("+options.Synchronous+")")
+	}
+
+	if options.CacheSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("cache_size(%d)", -options.CacheSize))
+	}
+
+	if options.MmapSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("mmap_size(%d)", options.MmapSize))
+	}
+
+	if options.ForeignKeys {
+		pragmas = append(pragmas, "foreign_keys(1)")
+	}
+
+	return url.Values{"_pragma": append(pragmas, extra...)}
+}
+
+// dataSource returns the file: URI the driver opens path with, carrying
+// parameters. A path that is a file: URI already keeps its own parameters
+// besides those given here, its pragmas run before them. Any other path is
+// escaped so ?, # and % are taken as part of the name.
+func dataSource(path string, parameters ...url.Values) (string, error) {
+	// Opaque leaves a relative path relative, as Path it would be taken for a host
+	uri := &url.URL{Scheme: "file", Opaque: (&url.URL{Path: path}).EscapedPath()}
+
+	if strings.HasPrefix(path, "file:") {
+		parsed, err := url.Parse(path)
+		if err != nil {
+			return "", err
+		}
+
+		uri = parsed
+	}
+
+	query := uri.Query()
+	for _, values := range parameters {
+		for key, value := range values {
+			if key == "_pragma" {
+				value = append(query[key], value...)
+			}
+
+			query[key] = value
+		}
+	}
+
+	uri.RawQuery = query.Encode()
+
+	return uri.String(), nil
+}
+
+func openPool(dataSource string, size int) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dataSource)
 	if err != nil {
 		return nil, err
 	}
-	
-	return &Database{db: db}, nil
+
+	if size > 0 {
+		db.SetMaxOpenConns(size)
+		db.SetMaxIdleConns(size)
+	}
+
+	// sql.Open does not connect, this surfaces a bad path or pragma here
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
 }
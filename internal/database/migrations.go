@@ -0,0 +1,272 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Migrations are applied in order on top of the base schema, the index of the
+// last applied migration is tracked through PRAGMA user_version.
+var migrations = []string{
+	// Content hash used by fsck to verify node contents
+	`ALTER TABLE node_contents ADD COLUMN hash TEXT NOT NULL DEFAULT ''`,
+
+	// Mount table, the row id is part of the node ids handed out for mounted entries
+	`CREATE TABLE mounts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		node_id INTEGER NOT NULL UNIQUE,                             -- Mount point directory
+		kind TEXT NOT NULL,                                          -- Kind of file system mounted
+		source TEXT NOT NULL,                                        -- Where the mounted file system comes from
+		FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE -- Ensure mount point exists
+	)`,
+
+	// Content size per node, kept in sync with node_contents by triggers
+	`ALTER TABLE nodes ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+	UPDATE nodes SET size = ifnull((SELECT length(content) FROM node_contents WHERE node_id = nodes.id), 0);
+	CREATE TRIGGER node_contents_size_insert AFTER INSERT ON node_contents BEGIN
+		UPDATE nodes SET size = length(NEW.content) WHERE id = NEW.node_id;
+	END;
+	CREATE TRIGGER node_contents_size_update AFTER UPDATE OF content, node_id ON node_contents BEGIN
+		UPDATE nodes SET size = 0 WHERE id = OLD.node_id AND OLD.node_id != NEW.node_id;
+		UPDATE nodes SET size = length(NEW.content) WHERE id = NEW.node_id;
+	END;
+	CREATE TRIGGER node_contents_size_delete AFTER DELETE ON node_contents BEGIN
+		UPDATE nodes SET size = 0 WHERE id = OLD.node_id;
+	END`,
+
+	// Quotas on directory trees and owners, limits of 0 do not apply
+	`CREATE TABLE quotas (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,                   -- directory, uid or gid
+		subject INTEGER NOT NULL,             -- Directory node id, uid or gid
+		soft_bytes INTEGER NOT NULL DEFAULT 0,
+		hard_bytes INTEGER NOT NULL DEFAULT 0,
+		soft_nodes INTEGER NOT NULL DEFAULT 0,
+		hard_nodes INTEGER NOT NULL DEFAULT 0,
+		grace INTEGER NOT NULL DEFAULT 0,     -- Seconds usage may stay above a soft limit
+		soft_exceeded TEXT NOT NULL DEFAULT '', -- When usage went above a soft limit
+		UNIQUE (kind, subject)
+	);
+	CREATE INDEX idx_nodes_uid ON nodes(uid);
+	CREATE INDEX idx_nodes_gid ON nodes(gid)`,
+
+	// Namespaces, separate trees in one database. Paths and names are unique
+	// per namespace now, which takes rebuilding the nodes table. Existing
+	// nodes and quotas go to the default namespace, id 0 like its root.
+	`CREATE TABLE namespaces (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		root_id INTEGER NOT NULL               -- Root directory of the namespace
+	);
+	INSERT INTO namespaces (id, name, root_id) SELECT 0, 'default', 0 WHERE EXISTS (SELECT 1 FROM nodes WHERE id = 0);
+	DROP TRIGGER node_contents_size_insert;
+	DROP TRIGGER node_contents_size_update;
+	DROP TRIGGER node_contents_size_delete;
+	CREATE TABLE nodes_namespaced (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		namespace_id INTEGER NOT NULL DEFAULT 0,                             -- Namespace the node belongs to
+		name TEXT NOT NULL,
+		parent_id INTEGER,
+		path TEXT NOT NULL,
+		mode INTEGER NOT NULL,
+		uid INTEGER NOT NULL DEFAULT 0,
+		gid INTEGER NOT NULL DEFAULT 0,
+		mod_time TIMESTAMP NOT NULL,
+		create_time TIMESTAMP NOT NULL,
+		access_time TIMESTAMP NOT NULL,
+		size INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY (namespace_id) REFERENCES namespaces(id) ON DELETE CASCADE,
+		FOREIGN KEY (parent_id) REFERENCES nodes(id) ON DELETE CASCADE,
+		UNIQUE (namespace_id, path),
+		UNIQUE (namespace_id, parent_id, name)
+	);
+	INSERT INTO nodes_namespaced (id, namespace_id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size)
+	SELECT id, 0, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size FROM nodes;
+	DROP TABLE nodes;
+	ALTER TABLE nodes_namespaced RENAME TO nodes;
+	CREATE INDEX idx_nodes_path ON nodes(path);
+	CREATE INDEX idx_nodes_name ON nodes(name);
+	CREATE INDEX idx_nodes_type ON nodes(mode);
+	CREATE INDEX idx_nodes_parent ON nodes(parent_id);
+	CREATE INDEX idx_nodes_uid ON nodes(uid);
+	CREATE INDEX idx_nodes_gid ON nodes(gid);
+	CREATE TRIGGER node_contents_size_insert AFTER INSERT ON node_contents BEGIN
+		UPDATE nodes SET size = length(NEW.content) WHERE id = NEW.node_id;
+	END;
+	CREATE TRIGGER node_contents_size_update AFTER UPDATE OF content, node_id ON node_contents BEGIN
+		UPDATE nodes SET size = 0 WHERE id = OLD.node_id AND OLD.node_id != NEW.node_id;
+		UPDATE nodes SET size = length(NEW.content) WHERE id = NEW.node_id;
+	END;
+	CREATE TRIGGER node_contents_size_delete AFTER DELETE ON node_contents BEGIN
+		UPDATE nodes SET size = 0 WHERE id = OLD.node_id;
+	END;
+	CREATE TABLE quotas_namespaced (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		namespace_id INTEGER NOT NULL DEFAULT 0, -- Namespace the quota applies in
+		kind TEXT NOT NULL,
+		subject INTEGER NOT NULL,
+		soft_bytes INTEGER NOT NULL DEFAULT 0,
+		hard_bytes INTEGER NOT NULL DEFAULT 0,
+		soft_nodes INTEGER NOT NULL DEFAULT 0,
+		hard_nodes INTEGER NOT NULL DEFAULT 0,
+		grace INTEGER NOT NULL DEFAULT 0,
+		soft_exceeded TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (namespace_id) REFERENCES namespaces(id) ON DELETE CASCADE,
+		UNIQUE (namespace_id, kind, subject)
+	);
+	INSERT INTO quotas_namespaced (id, kind, subject, soft_bytes, hard_bytes, soft_nodes, hard_nodes, grace, soft_exceeded)
+	SELECT id, kind, subject, soft_bytes, hard_bytes, soft_nodes, hard_nodes, grace, soft_exceeded FROM quotas;
+	DROP TABLE quotas;
+	ALTER TABLE quotas_namespaced RENAME TO quotas`,
+
+	// Attribute flags like immutable and append only, set apart from the mode
+	`ALTER TABLE nodes ADD COLUMN flags INTEGER NOT NULL DEFAULT 0`,
+
+	// Foreign keys are enforced now, roots get a NULL parent instead of one
+	// that does not exist
+	`UPDATE nodes SET parent_id = NULL WHERE parent_id = -1`,
+
+	// One value per attribute key, so setting one can be a single upsert.
+	// Racing writers may have left more, the last one written is kept.
+	`DELETE FROM node_attributes WHERE id NOT IN (SELECT max(id) FROM node_attributes GROUP BY node_id, key);
+	CREATE UNIQUE INDEX idx_attributes_node_key ON node_attributes(node_id, key)`,
+
+	// Advisory locks shared by every process using the database. A session
+	// is one open VFS, its locks go when it is closed or stops renewing it.
+	`CREATE TABLE lock_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		pid INTEGER NOT NULL,                 -- Process that opened the session
+		expires INTEGER NOT NULL              -- Unix milliseconds the session lasts until unless renewed
+	);
+	CREATE TABLE locks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id INTEGER NOT NULL,
+		owner TEXT NOT NULL,                  -- Owner within the session, locks of one owner do not conflict
+		handle INTEGER NOT NULL,              -- Handle the lock was taken through, closing it releases the lock
+		node_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,                   -- shared or exclusive
+		range_start INTEGER NOT NULL,
+		range_end INTEGER NOT NULL,           -- First byte after the range
+		FOREIGN KEY (session_id) REFERENCES lock_sessions(id) ON DELETE CASCADE,
+		FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE
+	);
+	CREATE INDEX idx_locks_node ON locks(node_id);
+	CREATE INDEX idx_locks_session ON locks(session_id, owner);
+	CREATE TABLE lock_waits (
+		session_id INTEGER NOT NULL,
+		owner TEXT NOT NULL,
+		node_id INTEGER NOT NULL,             -- Lock the owner is waiting for
+		kind TEXT NOT NULL,
+		range_start INTEGER NOT NULL,
+		range_end INTEGER NOT NULL,
+		PRIMARY KEY (session_id, owner),
+		FOREIGN KEY (session_id) REFERENCES lock_sessions(id) ON DELETE CASCADE,
+		FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE
+	)`,
+
+	// Sessions hold the open files of a VFS as well as its locks. A file
+	// removed while open somewhere is unlinked from the tree instead, it is
+	// deleted once no session has it open any more.
+	`ALTER TABLE lock_sessions RENAME TO sessions;
+	CREATE TABLE open_nodes (
+		session_id INTEGER NOT NULL,
+		node_id INTEGER NOT NULL,
+		PRIMARY KEY (session_id, node_id),
+		FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE,
+		FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE
+	);
+	CREATE INDEX idx_open_nodes_node ON open_nodes(node_id);
+	CREATE TABLE unlinked_nodes (
+		node_id INTEGER PRIMARY KEY,          -- Node without a parent or path, kept for its open handles
+		FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE
+	)`,
+
+	// Usage kept with each quota, so charging one does not add up its nodes.
+	// Triggers follow nodes as they come, go, grow and change owner. Moves
+	// change the paths of a whole subtree at once, MoveNode and UnlinkNode
+	// account for them.
+	`ALTER TABLE quotas ADD COLUMN used_nodes INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE quotas ADD COLUMN used_bytes INTEGER NOT NULL DEFAULT 0;
+	UPDATE quotas SET
+		used_nodes = (SELECT count(*) FROM nodes n WHERE ` + quotaCounts("n") + `),
+		used_bytes = (SELECT ifnull(sum(n.size), 0) FROM nodes n WHERE ` + quotaCounts("n") + `);
+	CREATE TRIGGER quota_usage_insert AFTER INSERT ON nodes
+	WHEN EXISTS (SELECT 1 FROM quotas WHERE namespace_id = NEW.namespace_id) BEGIN
+		UPDATE quotas SET used_nodes = used_nodes + 1, used_bytes = used_bytes + NEW.size WHERE ` + quotaCounts("NEW") + `;
+	END;
+	CREATE TRIGGER quota_usage_delete AFTER DELETE ON nodes
+	WHEN EXISTS (SELECT 1 FROM quotas WHERE namespace_id = OLD.namespace_id) BEGIN
+		UPDATE quotas SET used_nodes = used_nodes - 1, used_bytes = used_bytes - OLD.size WHERE ` + quotaCounts("OLD") + `;
+	END;
+	CREATE TRIGGER quota_usage_update AFTER UPDATE OF size, uid, gid ON nodes
+	WHEN (OLD.size != NEW.size OR OLD.uid != NEW.uid OR OLD.gid != NEW.gid)
+	AND EXISTS (SELECT 1 FROM quotas WHERE namespace_id = NEW.namespace_id) BEGIN
+		UPDATE quotas SET used_nodes = used_nodes - 1, used_bytes = used_bytes - OLD.size WHERE ` + quotaCounts("OLD") + `;
+		UPDATE quotas SET used_nodes = used_nodes + 1, used_bytes = used_bytes + NEW.size WHERE ` + quotaCounts("NEW") + `;
+	END`,
+}
+
+// quotaCounts matches the quotas that count the node row: those of its
+// owners and of the directories its path is within, a directory counting
+// itself. Quotas are the table of the statement it is used in.
+func quotaCounts(row string) string {
+	return `quotas.namespace_id = ` + row + `.namespace_id AND (
+		quotas.kind = 'uid' AND quotas.subject = ` + row + `.uid OR
+		quotas.kind = 'gid' AND quotas.subject = ` + row + `.gid OR
+		quotas.kind = 'directory' AND EXISTS (
+			SELECT 1 FROM nodes d
+			WHERE d.id = quotas.subject
+			AND (` + row + `.path = d.path OR substr(` + row + `.path, 1, length(rtrim(d.path, '/')) + 1) = rtrim(d.path, '/') || '/')
+		)
+	)`
+}
+
+// checkVersion fails unless every migration has been applied, for databases
+// that are opened read-only and cannot be migrated
+func checkVersion(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	if version != len(migrations) {
+		return fmt.Errorf("database is at version %d, opening it read-only takes version %d", version, len(migrations))
+	}
+
+	return nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for index := version; index < len(migrations); index++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migrations[index])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", index+1, err)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", index+1))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
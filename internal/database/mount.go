@@ -0,0 +1,50 @@
+package database
+
+import (
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+func (database *Database) InsertMount(node interfaces.Node, kind string, source string) (int64, error) {
+	result, err := database.db.Exec(
+		"INSERT INTO mounts (node_id, kind, source) VALUES (?, ?, ?)",
+		node.GetId(),
+		kind,
+		source,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (database *Database) GetMounts() ([]interfaces.Mount, error) {
+	rows, err := database.reader.Query(`
+		SELECT mounts.id, mounts.node_id, mounts.kind, mounts.source
+		FROM mounts
+		JOIN nodes ON nodes.id = mounts.node_id
+		WHERE nodes.namespace_id = ?
+		ORDER BY mounts.id
+	`, database.namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mounts []interfaces.Mount
+	for rows.Next() {
+		mount, err := database.mountFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, mount)
+	}
+
+	return mounts, rows.Err()
+}
+
+func (database *Database) DeleteMount(id int64) error {
+	_, err := database.db.Exec("DELETE FROM mounts WHERE id = ?", id)
+
+	return err
+}
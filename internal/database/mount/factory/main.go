@@ -0,0 +1,40 @@
+package factory
+
+import (
+	"database/sql"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+	"github.com/sushydev/vfs_go/internal/database/mount"
+)
+
+type Factory struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Factory {
+	return &Factory{db: db}
+}
+
+func (factory *Factory) New(row interfaces.RowScanner) (interfaces.Mount, error) {
+	var id int64
+	var nodeId int64
+	var kind string
+	var source string
+
+	err := row.Scan(
+		&id,
+		&nodeId,
+		&kind,
+		&source,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return mount.New(
+		id,
+		nodeId,
+		kind,
+		source,
+	)
+}
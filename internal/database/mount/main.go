@@ -0,0 +1,48 @@
+package mount
+
+import (
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+type Mount struct {
+	id     int64
+	nodeId int64
+	kind   string
+	source string
+}
+
+var _ interfaces.Mount = &Mount{}
+
+func New(
+	id int64,
+	nodeId int64,
+	kind string,
+	source string,
+) (*Mount, error) {
+	return &Mount{
+		id:     id,
+		nodeId: nodeId,
+		kind:   kind,
+		source: source,
+	}, nil
+}
+
+func (mount *Mount) GetId() int64 {
+	return mount.id
+}
+
+func (mount *Mount) GetNodeId() int64 {
+	return mount.nodeId
+}
+
+func (mount *Mount) GetKind() string {
+	return mount.kind
+}
+
+func (mount *Mount) GetSource() string {
+	return mount.source
+}
+
+func (mount *Mount) SetNodeId(nodeId int64) {
+	mount.nodeId = nodeId
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"io/fs"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+// DefaultNamespace holds the tree of databases made before there were
+// namespaces, its root keeps id 0
+const DefaultNamespace = "default"
+
+// InNamespace returns a view of the database whose node queries only see
+// the nodes of namespace id. Ids of nodes in other namespaces are not found.
+func (database *Database) InNamespace(id int64) *Database {
+	view := *database
+	view.namespace = id
+
+	return &view
+}
+
+// GetNamespaceId returns the namespace the view is limited to
+func (database *Database) GetNamespaceId() int64 {
+	return database.namespace
+}
+
+func (database *Database) GetNamespace(name string) (interfaces.Namespace, error) {
+	row := database.reader.QueryRow("SELECT id, name, root_id FROM namespaces WHERE name = ?", name)
+
+	return database.namespaceFactory.New(row)
+}
+
+func (database *Database) GetNamespaces() ([]interfaces.Namespace, error) {
+	rows, err := database.reader.Query("SELECT id, name, root_id FROM namespaces ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var namespaces []interfaces.Namespace
+	for rows.Next() {
+		namespace, err := database.namespaceFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, namespace)
+	}
+
+	return namespaces, rows.Err()
+}
+
+// CreateNamespace adds a namespace with an empty root directory
+func (database *Database) CreateNamespace(name string) (interfaces.Namespace, error) {
+	err := database.createNamespace(nil, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return database.GetNamespace(name)
+}
+
+// createNamespace inserts the namespace and its root with id, or with the
+// next free ids when id is nil
+func (database *Database) createNamespace(id any, name string) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO namespaces (id, name, root_id) VALUES (?, ?, -1)", id, name)
+	if err != nil {
+		return err
+	}
+
+	namespaceId, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	result, err = tx.Exec(`
+		INSERT INTO nodes (id, namespace_id, name, parent_id, path, mode, mod_time, create_time, access_time)
+		VALUES (?, ?, 'root', NULL, '/', ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, id, namespaceId, int64(fs.ModeDir))
+	if err != nil {
+		return err
+	}
+
+	rootId, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE namespaces SET root_id = ? WHERE id = ?", rootId, namespaceId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteNamespace removes a namespace with all of its nodes and what is
+// stored along with them
+func (database *Database) DeleteNamespace(id int64) error {
+	hasSearchIndex, err := database.HasSearchIndex()
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		"DELETE FROM node_contents WHERE node_id IN (SELECT id FROM nodes WHERE namespace_id = ?1)",
+		"DELETE FROM node_attributes WHERE node_id IN (SELECT id FROM nodes WHERE namespace_id = ?1)",
+		"DELETE FROM symlinks WHERE source_node_id IN (SELECT id FROM nodes WHERE namespace_id = ?1)",
+		"DELETE FROM mounts WHERE node_id IN (SELECT id FROM nodes WHERE namespace_id = ?1)",
+		"DELETE FROM quotas WHERE namespace_id = ?1",
+	}
+
+	if hasSearchIndex {
+		statements = append(statements, "DELETE FROM node_search WHERE rowid IN (SELECT id FROM nodes WHERE namespace_id = ?1)")
+	}
+
+	statements = append(statements,
+		"DELETE FROM nodes WHERE namespace_id = ?1",
+		"DELETE FROM namespaces WHERE id = ?1",
+	)
+
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		_, err = tx.Exec(statement, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ensureDefaultNamespace creates the default namespace in a new database
+func (database *Database) ensureDefaultNamespace() error {
+	var count int
+	err := database.reader.QueryRow("SELECT count(*) FROM namespaces WHERE id = 0").Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	return database.createNamespace(0, DefaultNamespace)
+}
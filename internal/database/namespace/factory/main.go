@@ -0,0 +1,37 @@
+package factory
+
+import (
+	"database/sql"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+	"github.com/sushydev/vfs_go/internal/database/namespace"
+)
+
+type Factory struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Factory {
+	return &Factory{db: db}
+}
+
+func (factory *Factory) New(row interfaces.RowScanner) (interfaces.Namespace, error) {
+	var id int64
+	var name string
+	var rootId int64
+
+	err := row.Scan(
+		&id,
+		&name,
+		&rootId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return namespace.New(
+		id,
+		name,
+		rootId,
+	)
+}
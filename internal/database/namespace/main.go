@@ -0,0 +1,37 @@
+package namespace
+
+import (
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+type Namespace struct {
+	id     int64
+	name   string
+	rootId int64
+}
+
+var _ interfaces.Namespace = &Namespace{}
+
+func New(
+	id int64,
+	name string,
+	rootId int64,
+) (*Namespace, error) {
+	return &Namespace{
+		id:     id,
+		name:   name,
+		rootId: rootId,
+	}, nil
+}
+
+func (namespace *Namespace) GetId() int64 {
+	return namespace.id
+}
+
+func (namespace *Namespace) GetName() string {
+	return namespace.name
+}
+
+func (namespace *Namespace) GetRootId() int64 {
+	return namespace.rootId
+}
@@ -1,14 +1,22 @@
 package database
 
 import (
+	"database/sql"
+	"errors"
+	"strings"
+
 	"github.com/sushydev/vfs_go/internal/database/interfaces"
 )
 
+// ErrLoop is returned by MoveNode for a parent that is the node or below it
+var ErrLoop = errors.New("node cannot be moved below itself")
+
+// InsertNode adds a node to parent, its path is made from the one parent has
+// as the row is written. It fails with sql.ErrNoRows when parent is gone.
 // todo return last inserted id
 func (d *Database) InsertNode(
 	name string,
 	parent interfaces.Node,
-	path string,
 	mode uint32,
 	uid int,
 	gid int,
@@ -16,49 +24,137 @@ func (d *Database) InsertNode(
 	createTime string,
 	accessTime string,
 ) error {
-	var parentId int64
+	_, err := d.insertNode(d.db, name, parent, mode, uid, gid, modTime, createTime, accessTime)
+
+	return err
+}
+
+// InsertSymlinkNode adds a symlink node to parent pointing at target, the
+// node and its symlink row are written in one transaction. It fails with
+// sql.ErrNoRows when parent is gone and with a foreign key violation when
+// target is.
+func (d *Database) InsertSymlinkNode(name string, parent interfaces.Node, target interfaces.Node, mode uint32, uid int, gid int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := d.insertNode(tx, name, parent, mode, uid, gid, 0, "", "")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO symlinks (source_node_id, target_node_id) VALUES (?, ?)", id, target.GetId())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
-	if parent != nil {
-		parentId = int64(parent.GetId())
-	} else {
-		parentId = 0
+// InsertNodeWithAttributes adds a file node to parent along with its
+// attributes, all written in one transaction. It fails with sql.ErrNoRows
+// when parent is gone.
+func (d *Database) InsertNodeWithAttributes(name string, parent interfaces.Node, mode uint32, uid int, gid int, attributes map[string]string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := d.insertNode(tx, name, parent, mode, uid, gid, 0, "", "")
+	if err != nil {
+		return err
 	}
 
+	for key, value := range attributes {
+		_, err = tx.Exec("INSERT INTO node_attributes (node_id, key, value) VALUES (?, ?, ?)", id, key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// execer is a pool or a transaction to write through
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertNode is InsertNode through db, it returns the id of the new node
+func (d *Database) insertNode(
+	db execer,
+	name string,
+	parent interfaces.Node,
+	mode uint32,
+	uid int,
+	gid int,
+	modTime int,
+	createTime string,
+	accessTime string,
+) (int64, error) {
 	parsedMode := int64(mode)
 
-	_, err := d.db.Exec(`
-		INSERT INTO nodes (name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, name, parentId, path, parsedMode, uid, gid, modTime, createTime, accessTime)
+	result, err := db.Exec(`
+		INSERT INTO nodes (namespace_id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time)
+		SELECT namespace_id, ?, id, rtrim(path, '/') || '/' || ?, ?, ?, ?, ?, ?, ?
+		FROM nodes
+		WHERE id = ? AND namespace_id = ?
+	`, name, name, parsedMode, uid, gid, modTime, createTime, accessTime, parent.GetId(), d.namespace)
+	if err != nil {
+		return 0, err
+	}
 
-	return err
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if inserted == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	return result.LastInsertId()
 }
 
 func (database *Database) GetNode(id int64) (interfaces.Node, error) {
-	row := database.db.QueryRow(`
-		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time
+	row := database.reader.QueryRow(`
+		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size, flags
 		FROM nodes
-		WHERE id = ?
-	`, id)
+		WHERE id = ? AND namespace_id = ?
+	`, id, database.namespace)
 
 	return database.nodeFactory.New(row)
 }
 
 func (database *Database) GetNodeByName(name string) (interfaces.Node, error) {
-	row := database.db.QueryRow(`
-		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time
+	row := database.reader.QueryRow(`
+		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size, flags
 		FROM nodes
-		WHERE name = ?
-	`, name)
+		WHERE name = ? AND namespace_id = ? AND id NOT IN (SELECT node_id FROM unlinked_nodes)
+	`, name, database.namespace)
+
+	return database.nodeFactory.New(row)
+}
+
+func (database *Database) GetNodeByPath(path string) (interfaces.Node, error) {
+	row := database.reader.QueryRow(`
+		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size, flags
+		FROM nodes
+		WHERE namespace_id = ? AND path = ?
+	`, database.namespace, path)
 
 	return database.nodeFactory.New(row)
 }
 
 func (database *Database) GetNodesByParent(parent interfaces.Node) ([]interfaces.Node, error) {
-	rows, err := database.db.Query(`
-		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time
+	rows, err := database.reader.Query(`
+		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size, flags
 		FROM nodes
 		WHERE parent_id = ?
+		ORDER BY name
 	`, parent.GetId())
 	if err != nil {
 		return nil, err
@@ -77,9 +173,69 @@ func (database *Database) GetNodesByParent(parent interfaces.Node) ([]interfaces
 	return nodes, nil
 }
 
+// GetNodesByParentAfter returns up to limit children of parent named after
+// after, in name order. The (parent_id, name) index serves it directly.
+func (database *Database) GetNodesByParentAfter(parent interfaces.Node, after string, limit int) ([]interfaces.Node, error) {
+	rows, err := database.reader.Query(`
+		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size, flags
+		FROM nodes
+		WHERE parent_id = ? AND name > ?
+		ORDER BY name
+		LIMIT ?
+	`, parent.GetId(), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []interfaces.Node
+	for rows.Next() {
+		file, err := database.nodeFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, file)
+	}
+
+	return nodes, nil
+}
+
+func (database *Database) GetNodesByPaths(paths []string) ([]interfaces.Node, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, 0, len(paths)+1)
+	args = append(args, database.namespace)
+	for _, path := range paths {
+		args = append(args, path)
+	}
+
+	rows, err := database.reader.Query(`
+		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size, flags
+		FROM nodes
+		WHERE namespace_id = ? AND path IN (?`+strings.Repeat(", ?", len(paths)-1)+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []interfaces.Node
+	for rows.Next() {
+		file, err := database.nodeFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, file)
+	}
+
+	return nodes, nil
+}
+
 func (database *Database) GetNodeByParentAndName(parent interfaces.Node, name string) (interfaces.Node, error) {
-	row := database.db.QueryRow(`
-		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time
+	row := database.reader.QueryRow(`
+		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size, flags
 		FROM nodes
 		WHERE parent_id = ? AND name = ?
 	`, parent.GetId(), name)
@@ -95,6 +251,22 @@ func (d *Database) DeleteNode(node interfaces.Node) error {
 	return err
 }
 
+// DeleteEmptyNode deletes node unless it has children, which may have been
+// created since they were last looked at. It tells whether node was deleted.
+func (d *Database) DeleteEmptyNode(node interfaces.Node) (bool, error) {
+	result, err := d.db.Exec(`
+		DELETE FROM nodes
+		WHERE id = ?1 AND NOT EXISTS (SELECT 1 FROM nodes WHERE parent_id = ?1)
+	`, node.GetId())
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+
+	return deleted > 0, err
+}
+
 func (database *Database) SaveNode(node interfaces.Node) error {
 	_, err := database.db.Exec(`
 		UPDATE nodes
@@ -102,7 +274,7 @@ func (database *Database) SaveNode(node interfaces.Node) error {
 		WHERE id = ?
 	`,
 		node.GetName(),
-		node.GetParentId(),
+		parentValue(node.GetParentId()),
 		node.GetPath(),
 		node.GetMode(),
 		node.GetUid(),
@@ -116,6 +288,109 @@ func (database *Database) SaveNode(node interfaces.Node) error {
 	return err
 }
 
+// parentValue stores the parent of a root, which has none, as NULL
+func parentValue(parentId int64) any {
+	if parentId < 0 {
+		return nil
+	}
+
+	return parentId
+}
+
+func (database *Database) SetNodeFlags(node interfaces.Node, flags int64) error {
+	_, err := database.db.Exec("UPDATE nodes SET flags = ? WHERE id = ?", flags, node.GetId())
+
+	return err
+}
+
 func (d *Database) Close() error {
-	return d.db.Close()
+	err := d.db.Close()
+
+	if d.reader != d.db {
+		readerErr := d.reader.Close()
+		if err == nil {
+			err = readerErr
+		}
+	}
+
+	return err
+}
+
+// GetNodes returns the nodes of the tree, unlinked ones left out
+func (database *Database) GetNodes() ([]interfaces.Node, error) {
+	rows, err := database.reader.Query(`
+		SELECT id, name, parent_id, path, mode, uid, gid, mod_time, create_time, access_time, size, flags
+		FROM nodes
+		WHERE namespace_id = ? AND id NOT IN (SELECT node_id FROM unlinked_nodes)
+		ORDER BY id
+	`, database.namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []interfaces.Node
+	for rows.Next() {
+		node, err := database.nodeFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, rows.Err()
+}
+
+// MoveNode gives a node a new name and parent and rewrites the paths below
+// it. Both are read where they are at that moment, in the same transaction
+// as the changes. It fails with sql.ErrNoRows when either is gone, unlinked
+// nodes included, and with ErrLoop when parent is the node or below it.
+func (database *Database) MoveNode(id int64, name string, parentId int64) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldPath, parentPath string
+
+	err = tx.QueryRow(
+		"SELECT path FROM nodes WHERE id = ? AND namespace_id = ? AND id NOT IN (SELECT node_id FROM unlinked_nodes)",
+		id, database.namespace,
+	).Scan(&oldPath)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow("SELECT path FROM nodes WHERE id = ? AND namespace_id = ?", parentId, database.namespace).Scan(&parentPath)
+	if err != nil {
+		return err
+	}
+
+	if parentPath == oldPath || strings.HasPrefix(parentPath, oldPath+"/") {
+		return ErrLoop
+	}
+
+	path := strings.TrimSuffix(parentPath, "/") + "/" + name
+
+	err = database.moveQuotaUsage(tx, oldPath, path)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE nodes SET name = ?, parent_id = ?, path = ? WHERE id = ?", name, parentId, path, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE nodes
+		SET path = ?2 || substr(path, length(?1) + 1)
+		WHERE namespace_id = ?3 AND substr(path, 1, length(?1)) = ?1
+	`, oldPath+"/", path+"/", database.namespace)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -18,7 +18,7 @@ func New(db *sql.DB) *Factory {
 func (factory *Factory) New(row interfaces.RowScanner) (interfaces.Node, error) {
 	var id int64
 	var name string
-	var parentId int64
+	var parentId sql.NullInt64
 	var path string
 	var mode int64
 	var uid int
@@ -26,6 +26,8 @@ func (factory *Factory) New(row interfaces.RowScanner) (interfaces.Node, error)
 	var modTime string
 	var createTime string
 	var accessTime string
+	var size int64
+	var flags int64
 
 	err := row.Scan(
 		&id,
@@ -38,6 +40,8 @@ func (factory *Factory) New(row interfaces.RowScanner) (interfaces.Node, error)
 		&modTime,
 		&createTime,
 		&accessTime,
+		&size,
+		&flags,
 	)
 	if err != nil {
 		return nil, err
@@ -46,7 +50,7 @@ func (factory *Factory) New(row interfaces.RowScanner) (interfaces.Node, error)
 	return node.New(
 		id,
 		name,
-		parentId,
+		rootParent(parentId),
 		path,
 		mode,
 		uid,
@@ -54,5 +58,16 @@ func (factory *Factory) New(row interfaces.RowScanner) (interfaces.Node, error)
 		modTime,
 		createTime,
 		accessTime,
+		size,
+		flags,
 	)
 }
+
+// rootParent is -1 for roots, their parent is NULL in the database
+func rootParent(parentId sql.NullInt64) int64 {
+	if !parentId.Valid {
+		return -1
+	}
+
+	return parentId.Int64
+}
@@ -15,6 +15,8 @@ type Node struct {
 	modTime     string
 	createTime  string
 	accessTime  string
+	size        int64
+	flags       int64
 }
 
 var _ interfaces.Node = &Node{}
@@ -30,6 +32,8 @@ func New(
 	modTime string,
 	createTime string,
 	accessTime string,
+	size int64,
+	flags int64,
 ) (*Node, error) {
 	return &Node{
 		id:          id,
@@ -42,6 +46,8 @@ func New(
 		modTime:     modTime,
 		createTime:  createTime,
 		accessTime:  accessTime,
+		size:        size,
+		flags:       flags,
 	}, nil
 }
 
@@ -85,6 +91,15 @@ func (node *Node) GetAccessTime() string {
 	return node.accessTime
 }
 
+// GetSize is the length of the stored content, kept current by the database
+func (node *Node) GetSize() int64 {
+	return node.size
+}
+
+func (node *Node) GetFlags() int64 {
+	return node.flags
+}
+
 func (node *Node) SetName(name string) {
 	node.name = name
 }
@@ -120,3 +135,11 @@ func (node *Node) SetCreateTime(createTime string) {
 func (node *Node) SetAccessTime(accessTime string) {
 	node.accessTime = accessTime
 }
+
+func (node *Node) SetSize(size int64) {
+	node.size = size
+}
+
+func (node *Node) SetFlags(flags int64) {
+	node.flags = flags
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+func (database *Database) InsertNodeAttribute(node interfaces.Node, key string, value string) error {
+	_, err := database.db.Exec(
+		`INSERT INTO node_attributes (node_id, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (node_id, key) DO UPDATE SET value = excluded.value`,
+		node.GetId(),
+		key,
+		value,
+	)
+
+	return err
+}
+
+func (database *Database) GetNodeAttributeByNodeAndKey(node interfaces.Node, key string) (interfaces.NodeAttribute, error) {
+	row := database.reader.QueryRow("SELECT id, node_id, key, value FROM node_attributes WHERE node_id = ? AND key = ?", node.GetId(), key)
+
+	return database.nodeAttributeFactory.New(row)
+}
+
+func (database *Database) GetNodeAttributesByNode(node interfaces.Node) ([]interfaces.NodeAttribute, error) {
+	rows, err := database.reader.Query("SELECT id, node_id, key, value FROM node_attributes WHERE node_id = ? ORDER BY key", node.GetId())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodeAttributes []interfaces.NodeAttribute
+	for rows.Next() {
+		nodeAttribute, err := database.nodeAttributeFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodeAttributes = append(nodeAttributes, nodeAttribute)
+	}
+
+	return nodeAttributes, rows.Err()
+}
+
+func (database *Database) SaveNodeAttribute(nodeAttribute interfaces.NodeAttribute) error {
+	_, err := database.db.Exec(
+		"UPDATE node_attributes SET key = ?, value = ? WHERE id = ?",
+		nodeAttribute.GetKey(),
+		nodeAttribute.GetValue(),
+		nodeAttribute.GetId(),
+	)
+
+	return err
+}
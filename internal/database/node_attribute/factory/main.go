@@ -0,0 +1,40 @@
+package factory
+
+import (
+	"database/sql"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+	"github.com/sushydev/vfs_go/internal/database/node_attribute"
+)
+
+type Factory struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Factory {
+	return &Factory{db: db}
+}
+
+func (factory *Factory) New(row interfaces.RowScanner) (interfaces.NodeAttribute, error) {
+	var id int64
+	var nodeId int64
+	var key string
+	var value string
+
+	err := row.Scan(
+		&id,
+		&nodeId,
+		&key,
+		&value,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return node_attribute.New(
+		id,
+		nodeId,
+		key,
+		value,
+	)
+}
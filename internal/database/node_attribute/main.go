@@ -0,0 +1,56 @@
+package node_attribute
+
+import (
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+type NodeAttribute struct {
+	id     int64
+	nodeId int64
+	key    string
+	value  string
+}
+
+var _ interfaces.NodeAttribute = &NodeAttribute{}
+
+func New(
+	id int64,
+	nodeId int64,
+	key string,
+	value string,
+) (*NodeAttribute, error) {
+	return &NodeAttribute{
+		id:     id,
+		nodeId: nodeId,
+		key:    key,
+		value:  value,
+	}, nil
+}
+
+func (nodeAttribute *NodeAttribute) GetId() int64 {
+	return nodeAttribute.id
+}
+
+func (nodeAttribute *NodeAttribute) GetNodeId() int64 {
+	return nodeAttribute.nodeId
+}
+
+func (nodeAttribute *NodeAttribute) GetKey() string {
+	return nodeAttribute.key
+}
+
+func (nodeAttribute *NodeAttribute) GetValue() string {
+	return nodeAttribute.value
+}
+
+func (nodeAttribute *NodeAttribute) SetNodeId(nodeId int64) {
+	nodeAttribute.nodeId = nodeId
+}
+
+func (nodeAttribute *NodeAttribute) SetKey(key string) {
+	nodeAttribute.key = key
+}
+
+func (nodeAttribute *NodeAttribute) SetValue(value string) {
+	nodeAttribute.value = value
+}
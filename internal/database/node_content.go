@@ -1,14 +1,29 @@
 package database
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+
 	"github.com/sushydev/vfs_go/internal/database/interfaces"
 )
 
+// HashContent returns the hash stored alongside node contents
+func HashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
+
 func (database *Database) InsertNodeContent(node interfaces.Node, content []byte) error {
 	_, err := database.db.Exec(
-		"INSERT INTO node_contents (node_id, content) VALUES (?, ?)",
+		`INSERT INTO node_contents (node_id, content, hash) VALUES (?, ?, ?)
+		ON CONFLICT (node_id) DO UPDATE SET content = excluded.content, hash = excluded.hash`,
 		node.GetId(),
 		content,
+		HashContent(content),
 	)
 	if err != nil {
 		return err
@@ -17,22 +32,76 @@ func (database *Database) InsertNodeContent(node interfaces.Node, content []byte
 	return nil
 }
 
+// ErrFlagged is returned by ReplaceNodeContent when the flags of the node forbid the change
+var ErrFlagged = errors.New("node flags forbid the change")
+
+// ReplaceNodeContent makes content the whole content of node. The flags of
+// the node are read in the same transaction as the write: a node with one of
+// immutable fails with ErrFlagged, and so does one with one of appendOnly
+// unless content starts with what the node holds. It fails with
+// sql.ErrNoRows when the node is gone.
+func (database *Database) ReplaceNodeContent(node interfaces.Node, content []byte, immutable int64, appendOnly int64) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var flags int64
+	err = tx.QueryRow("SELECT flags FROM nodes WHERE id = ?", node.GetId()).Scan(&flags)
+	if err != nil {
+		return err
+	}
+
+	if flags&immutable != 0 {
+		return ErrFlagged
+	}
+
+	if flags&appendOnly != 0 {
+		var held []byte
+		err = tx.QueryRow("SELECT content FROM node_contents WHERE node_id = ?", node.GetId()).Scan(&held)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if !bytes.HasPrefix(content, held) {
+			return ErrFlagged
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO node_contents (node_id, content, hash) VALUES (?, ?, ?)
+		ON CONFLICT (node_id) DO UPDATE SET content = excluded.content, hash = excluded.hash`,
+		node.GetId(),
+		content,
+		HashContent(content),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (database *Database) GetNodeContent(id int64) (interfaces.NodeContent, error) {
-	row := database.db.QueryRow("SELECT id, node_id, content FROM node_contents WHERE id = ?", id)
+	row := database.reader.QueryRow("SELECT id, node_id, content, hash FROM node_contents WHERE id = ?", id)
 
 	return database.nodeContentFactory.New(row)
 }
 
 func (database *Database) GetNodeContentByNode(node interfaces.Node) (interfaces.NodeContent, error) {
-	row := database.db.QueryRow("SELECT id, node_id, content FROM node_contents WHERE node_id = ?", node.GetId())
+	row := database.reader.QueryRow("SELECT id, node_id, content, hash FROM node_contents WHERE node_id = ?", node.GetId())
 
 	return database.nodeContentFactory.New(row)
 }
 
 func (database *Database) SaveNodeContent(nodeContent interfaces.NodeContent) error {
+	nodeContent.SetHash(HashContent(nodeContent.GetContent()))
+
 	_, err := database.db.Exec(
-		"UPDATE node_contents SET content = ? WHERE id = ?",
+		"UPDATE node_contents SET content = ?, hash = ? WHERE id = ?",
 		nodeContent.GetContent(),
+		nodeContent.GetHash(),
 		nodeContent.GetId(),
 	)
 	if err != nil {
@@ -41,3 +110,119 @@ func (database *Database) SaveNodeContent(nodeContent interfaces.NodeContent) er
 
 	return nil
 }
+
+func (database *Database) GetNodeContentSize(node interfaces.Node) (int64, error) {
+	var size int64
+
+	err := database.reader.QueryRow("SELECT length(content) FROM node_contents WHERE node_id = ?", node.GetId()).Scan(&size)
+
+	return size, err
+}
+
+// ReadNodeContentAt reads at most length bytes starting at offset without loading the whole content
+func (database *Database) ReadNodeContentAt(node interfaces.Node, offset int64, length int64) ([]byte, error) {
+	var content []byte
+
+	err := database.reader.QueryRow(
+		"SELECT substr(content, ? + 1, ?) FROM node_contents WHERE node_id = ?",
+		offset,
+		length,
+		node.GetId(),
+	).Scan(&content)
+
+	return content, err
+}
+
+// RehashNodeContent stores the hash of the content node has, streaming it a
+// chunk at a time. Reading and storing happen in one transaction, a write
+// landing in between cannot leave the hash of content that is gone.
+func (database *Database) RehashNodeContent(node interfaces.Node) error {
+	const chunk = 1 << 20
+
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hasher := sha256.New()
+
+	for offset := int64(0); ; {
+		var content []byte
+
+		err = tx.QueryRow(
+			"SELECT substr(content, ? + 1, ?) FROM node_contents WHERE node_id = ?",
+			offset,
+			chunk,
+			node.GetId(),
+		).Scan(&content)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		hasher.Write(content)
+		offset += int64(len(content))
+
+		if len(content) < chunk {
+			break
+		}
+	}
+
+	_, err = tx.Exec("UPDATE node_contents SET hash = ? WHERE node_id = ?", hex.EncodeToString(hasher.Sum(nil)), node.GetId())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// WriteNodeContentAt splices data into the content at offset, the gap is zero
+// filled when writing past the end. The hash is cleared and has to be updated
+// once the writer is done.
+func (database *Database) WriteNodeContentAt(node interfaces.Node, offset int64, data []byte) error {
+	_, err := database.db.Exec(
+		"INSERT OR IGNORE INTO node_contents (node_id, content, hash) VALUES (?, x'', ?)",
+		node.GetId(),
+		HashContent(nil),
+	)
+	if err != nil {
+		return err
+	}
+
+	// substr returns NULL rather than an empty blob for empty content, hence the ifnull
+	_, err = database.db.Exec(`
+		UPDATE node_contents
+		SET content = CAST(
+			ifnull(substr(content, 1, ?1), x'') ||
+			zeroblob(max(?1 - length(content), 0)) ||
+			?2 ||
+			ifnull(substr(content, ?1 + length(?2) + 1), x'')
+		AS BLOB), hash = ''
+		WHERE node_id = ?3
+	`, offset, data, node.GetId())
+
+	return err
+}
+
+func (database *Database) TruncateNodeContent(node interfaces.Node, size int64) error {
+	_, err := database.db.Exec(
+		"INSERT OR IGNORE INTO node_contents (node_id, content, hash) VALUES (?, x'', ?)",
+		node.GetId(),
+		HashContent(nil),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.db.Exec(`
+		UPDATE node_contents
+		SET content = CAST(ifnull(substr(content, 1, ?1), x'') || zeroblob(max(?1 - length(content), 0)) AS BLOB), hash = ''
+		WHERE node_id = ?2
+	`, size, node.GetId())
+
+	return err
+}
@@ -19,11 +19,13 @@ func (factory *Factory) New(row interfaces.RowScanner) (interfaces.NodeContent,
 	var id int64
 	var nodeId int64
 	var content []byte
+	var hash string
 
 	err := row.Scan(
 		&id,
 		&nodeId,
 		&content,
+		&hash,
 	)
 	if err != nil {
 		return nil, err
@@ -33,5 +35,6 @@ func (factory *Factory) New(row interfaces.RowScanner) (interfaces.NodeContent,
 		id,
 		nodeId,
 		content,
+		hash,
 	)
 }
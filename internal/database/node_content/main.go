@@ -8,6 +8,7 @@ type NodeContent struct {
 	id      int64
 	nodeId  int64
 	content []byte
+	hash    string
 }
 
 var _ interfaces.NodeContent = &NodeContent{}
@@ -16,11 +17,13 @@ func New(
 	id int64,
 	nodeId int64,
 	content []byte,
+	hash string,
 ) (*NodeContent, error) {
 	return &NodeContent{
 		id:      id,
 		nodeId:  nodeId,
 		content: content,
+		hash:    hash,
 	}, nil
 }
 
@@ -36,6 +39,10 @@ func (nodeContent *NodeContent) GetContent() []byte {
 	return nodeContent.content
 }
 
+func (nodeContent *NodeContent) GetHash() string {
+	return nodeContent.hash
+}
+
 func (nodeContent *NodeContent) SetNodeId(nodeId int64) {
 	nodeContent.nodeId = nodeId
 }
@@ -44,6 +51,10 @@ func (nodeContent *NodeContent) SetContent(content []byte) {
 	nodeContent.content = content
 }
 
+func (nodeContent *NodeContent) SetHash(hash string) {
+	nodeContent.hash = hash
+}
+
 func (nodeContent *NodeContent) GetEntity() interfaces.NodeContent {
 	return nodeContent
 }
@@ -0,0 +1,219 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+// SaveQuota creates or replaces the quota of kind on subject, which starts
+// out within its soft limits again. Its usage is added up in the same
+// transaction, from then on it is kept as nodes change.
+func (database *Database) SaveQuota(kind string, subject int64, softBytes int64, hardBytes int64, softNodes int64, hardNodes int64, grace int64) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO quotas (namespace_id, kind, subject, soft_bytes, hard_bytes, soft_nodes, hard_nodes, grace, soft_exceeded)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, '')
+		ON CONFLICT (namespace_id, kind, subject) DO UPDATE SET
+			soft_bytes = excluded.soft_bytes,
+			hard_bytes = excluded.hard_bytes,
+			soft_nodes = excluded.soft_nodes,
+			hard_nodes = excluded.hard_nodes,
+			grace = excluded.grace,
+			soft_exceeded = ''
+	`, database.namespace, kind, subject, softBytes, hardBytes, softNodes, hardNodes, grace)
+	if err != nil {
+		return err
+	}
+
+	condition, args := kind+" = ?", []any{subject}
+
+	if kind == "directory" {
+		var path string
+		err = tx.QueryRow("SELECT path FROM nodes WHERE id = ? AND namespace_id = ?", subject, database.namespace).Scan(&path)
+		if err != nil {
+			return err
+		}
+
+		// Unlinked nodes have no path below the root
+		condition, args = "substr(path, 1, 1) = '/'", nil
+		if path != "/" {
+			condition, args = subtreeCondition, subtreeArgs(path)
+		}
+	} else if kind != "uid" && kind != "gid" {
+		return fmt.Errorf("no quota kind %q", kind)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE quotas SET (used_nodes, used_bytes) = (
+			SELECT count(*), ifnull(sum(size), 0) FROM nodes WHERE namespace_id = ? AND `+condition+`
+		)
+		WHERE namespace_id = ? AND kind = ? AND subject = ?
+	`, append(append([]any{database.namespace}, args...), database.namespace, kind, subject)...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// moveQuotaUsage moves the usage of the subtree at oldPath from the directory
+// quotas it leaves to those it enters at newPath, before tx changes its
+// paths. Quotas on directories within the subtree move along with it. The
+// triggers keeping usage leave this to moves, whose single statement also
+// changes the paths of the quota directories it moves.
+func (database *Database) moveQuotaUsage(tx *sql.Tx, oldPath string, newPath string) error {
+	rows, err := tx.Query(`
+		SELECT quotas.id, nodes.path
+		FROM quotas
+		JOIN nodes ON nodes.id = quotas.subject
+		WHERE quotas.namespace_id = ? AND quotas.kind = 'directory'
+	`, database.namespace)
+	if err != nil {
+		return err
+	}
+
+	// sign is -1 for quotas the subtree leaves, 1 for those it enters
+	type change struct {
+		id   int64
+		sign int64
+	}
+
+	var changes []change
+	for rows.Next() {
+		var id int64
+		var path string
+
+		err = rows.Scan(&id, &path)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		was, is := pathWithin(oldPath, path), pathWithin(newPath, path)
+		if was == is || pathWithin(path, oldPath) {
+			continue
+		}
+
+		sign := int64(1)
+		if was {
+			sign = -1
+		}
+
+		changes = append(changes, change{id, sign})
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+
+	var nodes, bytes int64
+	err = tx.QueryRow(
+		"SELECT count(*), ifnull(sum(size), 0) FROM nodes WHERE namespace_id = ? AND "+subtreeCondition,
+		append([]any{database.namespace}, subtreeArgs(oldPath)...)...,
+	).Scan(&nodes, &bytes)
+	if err != nil {
+		return err
+	}
+
+	for _, quota := range changes {
+		_, err = tx.Exec(
+			"UPDATE quotas SET used_nodes = used_nodes + ?, used_bytes = used_bytes + ? WHERE id = ?",
+			quota.sign*nodes,
+			quota.sign*bytes,
+			quota.id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pathWithin tells whether path is directory or below it
+func pathWithin(path string, directory string) bool {
+	if directory == "/" {
+		return strings.HasPrefix(path, "/")
+	}
+
+	return path == directory || strings.HasPrefix(path, directory+"/")
+}
+
+func (database *Database) GetQuota(kind string, subject int64) (interfaces.Quota, error) {
+	row := database.reader.QueryRow(`
+		SELECT id, kind, subject, soft_bytes, hard_bytes, soft_nodes, hard_nodes, grace, soft_exceeded, used_nodes, used_bytes
+		FROM quotas
+		WHERE namespace_id = ? AND kind = ? AND subject = ?
+	`, database.namespace, kind, subject)
+
+	return database.quotaFactory.New(row)
+}
+
+// HasQuotas tells whether any quota is defined in the namespace, the unique
+// index on the namespace serves it
+func (database *Database) HasQuotas() (bool, error) {
+	var exists bool
+	err := database.reader.QueryRow("SELECT EXISTS (SELECT 1 FROM quotas WHERE namespace_id = ?)", database.namespace).Scan(&exists)
+
+	return exists, err
+}
+
+func (database *Database) GetQuotas() ([]interfaces.Quota, error) {
+	rows, err := database.reader.Query(`
+		SELECT id, kind, subject, soft_bytes, hard_bytes, soft_nodes, hard_nodes, grace, soft_exceeded, used_nodes, used_bytes
+		FROM quotas
+		WHERE namespace_id = ?
+		ORDER BY kind, subject
+	`, database.namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quotas []interfaces.Quota
+	for rows.Next() {
+		quota, err := database.quotaFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+
+	return quotas, rows.Err()
+}
+
+func (database *Database) SetQuotaSoftExceeded(quota interfaces.Quota) error {
+	_, err := database.db.Exec("UPDATE quotas SET soft_exceeded = ? WHERE id = ?", quota.GetSoftExceeded(), quota.GetId())
+
+	return err
+}
+
+func (database *Database) DeleteQuota(kind string, subject int64) (bool, error) {
+	result, err := database.db.Exec("DELETE FROM quotas WHERE namespace_id = ? AND kind = ? AND subject = ?", database.namespace, kind, subject)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+
+	return deleted > 0, err
+}
+
+// GetOwnerUsage totals the nodes owned by a uid or gid, column is "uid" or "gid"
+func (database *Database) GetOwnerUsage(column string, owner int) (NodeUsage, error) {
+	if column != "uid" && column != "gid" {
+		return NodeUsage{}, fmt.Errorf("no owner column %q", column)
+	}
+
+	return database.nodeUsage(column+" = ?", owner)
+}
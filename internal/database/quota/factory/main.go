@@ -0,0 +1,61 @@
+package factory
+
+import (
+	"database/sql"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+	"github.com/sushydev/vfs_go/internal/database/quota"
+)
+
+type Factory struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Factory {
+	return &Factory{db: db}
+}
+
+func (factory *Factory) New(row interfaces.RowScanner) (interfaces.Quota, error) {
+	var id int64
+	var kind string
+	var subject int64
+	var softBytes int64
+	var hardBytes int64
+	var softNodes int64
+	var hardNodes int64
+	var grace int64
+	var softExceeded string
+	var usedNodes int64
+	var usedBytes int64
+
+	err := row.Scan(
+		&id,
+		&kind,
+		&subject,
+		&softBytes,
+		&hardBytes,
+		&softNodes,
+		&hardNodes,
+		&grace,
+		&softExceeded,
+		&usedNodes,
+		&usedBytes,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return quota.New(
+		id,
+		kind,
+		subject,
+		softBytes,
+		hardBytes,
+		softNodes,
+		hardNodes,
+		grace,
+		softExceeded,
+		usedNodes,
+		usedBytes,
+	)
+}
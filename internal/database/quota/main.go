@@ -0,0 +1,97 @@
+package quota
+
+import (
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+type Quota struct {
+	id           int64
+	kind         string
+	subject      int64
+	softBytes    int64
+	hardBytes    int64
+	softNodes    int64
+	hardNodes    int64
+	grace        int64
+	softExceeded string
+	usedNodes    int64
+	usedBytes    int64
+}
+
+var _ interfaces.Quota = &Quota{}
+
+func New(
+	id int64,
+	kind string,
+	subject int64,
+	softBytes int64,
+	hardBytes int64,
+	softNodes int64,
+	hardNodes int64,
+	grace int64,
+	softExceeded string,
+	usedNodes int64,
+	usedBytes int64,
+) (*Quota, error) {
+	return &Quota{
+		id:           id,
+		kind:         kind,
+		subject:      subject,
+		softBytes:    softBytes,
+		hardBytes:    hardBytes,
+		softNodes:    softNodes,
+		hardNodes:    hardNodes,
+		grace:        grace,
+		softExceeded: softExceeded,
+		usedNodes:    usedNodes,
+		usedBytes:    usedBytes,
+	}, nil
+}
+
+func (quota *Quota) GetId() int64 {
+	return quota.id
+}
+
+func (quota *Quota) GetKind() string {
+	return quota.kind
+}
+
+func (quota *Quota) GetSubject() int64 {
+	return quota.subject
+}
+
+func (quota *Quota) GetSoftBytes() int64 {
+	return quota.softBytes
+}
+
+func (quota *Quota) GetHardBytes() int64 {
+	return quota.hardBytes
+}
+
+func (quota *Quota) GetSoftNodes() int64 {
+	return quota.softNodes
+}
+
+func (quota *Quota) GetHardNodes() int64 {
+	return quota.hardNodes
+}
+
+func (quota *Quota) GetGrace() int64 {
+	return quota.grace
+}
+
+func (quota *Quota) GetSoftExceeded() string {
+	return quota.softExceeded
+}
+
+func (quota *Quota) GetUsedNodes() int64 {
+	return quota.usedNodes
+}
+
+func (quota *Quota) GetUsedBytes() int64 {
+	return quota.usedBytes
+}
+
+func (quota *Quota) SetSoftExceeded(softExceeded string) {
+	quota.softExceeded = softExceeded
+}
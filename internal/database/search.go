@@ -0,0 +1,200 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+// SearchHit is a node whose content matched a full text query
+type SearchHit struct {
+	Node    interfaces.Node
+	Snippet string
+	Rank    float64
+}
+
+// CreateSearchIndex adds the full text index, rows are keyed by node id.
+// The media types and size it takes in are stored along with it, replacing
+// those of an index that already exists.
+func (database *Database) CreateSearchIndex(mimeTypes []string, maxSize int64) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []string{
+		"CREATE VIRTUAL TABLE IF NOT EXISTS node_search USING fts5(content)",
+		"CREATE TABLE IF NOT EXISTS node_search_options (mime_types TEXT NOT NULL, max_size INTEGER NOT NULL)",
+		"DELETE FROM node_search_options",
+	} {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO node_search_options (mime_types, max_size) VALUES (?, ?)", strings.Join(mimeTypes, "\n"), maxSize)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSearchOptions returns the media types and size the index was created
+// with, sql.ErrNoRows for an index made before they were stored
+func (database *Database) GetSearchOptions() ([]string, int64, error) {
+	var count int
+	err := database.reader.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'node_search_options'").Scan(&count)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if count == 0 {
+		return nil, 0, sql.ErrNoRows
+	}
+
+	var mimeTypes string
+	var maxSize int64
+
+	err = database.reader.QueryRow("SELECT mime_types, max_size FROM node_search_options").Scan(&mimeTypes, &maxSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if mimeTypes == "" {
+		return nil, maxSize, nil
+	}
+
+	return strings.Split(mimeTypes, "\n"), maxSize, nil
+}
+
+func (database *Database) HasSearchIndex() (bool, error) {
+	var count int
+	err := database.reader.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'node_search'").Scan(&count)
+
+	return count > 0, err
+}
+
+func (database *Database) IndexNodeContent(node interfaces.Node, content string) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM node_search WHERE rowid = ?", node.GetId())
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO node_search (rowid, content) VALUES (?, ?)", node.GetId(), content)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (database *Database) DeleteNodeSearch(node interfaces.Node) error {
+	_, err := database.db.Exec("DELETE FROM node_search WHERE rowid = ?", node.GetId())
+
+	return err
+}
+
+// GetSearchableNodes lists the nodes with stored content of at most maxSize
+// bytes. The index is shared by all namespaces, so this lists theirs too.
+func (database *Database) GetSearchableNodes(maxSize int64) ([]interfaces.Node, error) {
+	rows, err := database.reader.Query(`
+		SELECT nodes.id, nodes.name, nodes.parent_id, nodes.path, nodes.mode, nodes.uid, nodes.gid, nodes.mod_time, nodes.create_time, nodes.access_time, nodes.size, nodes.flags
+		FROM node_contents
+		JOIN nodes ON nodes.id = node_contents.node_id
+		WHERE length(node_contents.content) <= ? AND nodes.id NOT IN (SELECT node_id FROM unlinked_nodes)
+		ORDER BY nodes.id
+	`, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []interfaces.Node
+	for rows.Next() {
+		node, err := database.nodeFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, rows.Err()
+}
+
+// SearchNodes runs an FTS5 query, best matches first, limited to prefix and
+// everything below it when prefix is not the root
+func (database *Database) SearchNodes(query string, prefix string, limit int) ([]SearchHit, error) {
+	rows, err := database.reader.Query(`
+		SELECT node_search.rowid, snippet(node_search, 0, '[', ']', '...', 12), node_search.rank
+		FROM node_search
+		JOIN nodes ON nodes.id = node_search.rowid
+		WHERE node_search MATCH ?1 AND nodes.namespace_id = ?4 AND nodes.id NOT IN (SELECT node_id FROM unlinked_nodes) AND (?2 = '/' OR nodes.path >= ?2 || '/' AND nodes.path < ?2 || '0')
+		ORDER BY node_search.rank
+		LIMIT ?3
+	`, query, prefix, limit, database.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	type row struct {
+		id      int64
+		snippet string
+		rank    float64
+	}
+
+	var found []row
+	for rows.Next() {
+		var hit row
+		err = rows.Scan(&hit.id, &hit.snippet, &hit.rank)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		found = append(found, hit)
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]SearchHit, 0, len(found))
+	for _, hit := range found {
+		node, err := database.GetNode(hit.id)
+		if err != nil {
+			return nil, err
+		}
+
+		hits = append(hits, SearchHit{Node: node, Snippet: hit.snippet, Rank: hit.rank})
+	}
+
+	return hits, nil
+}
+
+func (database *Database) DropSearchIndex() error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"node_search", "node_search_options"} {
+		_, err = tx.Exec("DROP TABLE IF EXISTS " + table)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
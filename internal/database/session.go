@@ -0,0 +1,245 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+// CreateSession starts a session whose locks and open nodes last as long as
+// it is renewed before expires, in Unix milliseconds
+func (database *Database) CreateSession(pid int, expires int64) (int64, error) {
+	result, err := database.db.Exec("INSERT INTO sessions (pid, expires) VALUES (?, ?)", pid, expires)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// RenewSession moves the expiry of a session, it tells whether the session
+// was still there. One that was not has lost its locks and open nodes.
+func (database *Database) RenewSession(id int64, expires int64) (bool, error) {
+	result, err := database.db.Exec("UPDATE sessions SET expires = ? WHERE id = ?", expires, id)
+	if err != nil {
+		return false, err
+	}
+
+	renewed, err := result.RowsAffected()
+
+	return renewed > 0, err
+}
+
+// DeleteSession ends a session, releasing its locks and open nodes
+func (database *Database) DeleteSession(id int64) error {
+	_, err := database.db.Exec("DELETE FROM sessions WHERE id = ?", id)
+
+	return err
+}
+
+// OpenNode records that a session has a node open. It fails with a foreign
+// key violation when the node is gone.
+func (database *Database) OpenNode(sessionId int64, nodeId int64) error {
+	_, err := database.db.Exec("INSERT OR IGNORE INTO open_nodes (session_id, node_id) VALUES (?, ?)", sessionId, nodeId)
+
+	return err
+}
+
+// CloseNode records that a session no longer has a node open. It tells
+// whether that deleted the node, which happens to an unlinked node nobody
+// else has open.
+func (database *Database) CloseNode(sessionId int64, nodeId int64, now int64) (bool, error) {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM open_nodes WHERE session_id = ? AND node_id = ?", sessionId, nodeId)
+	if err != nil {
+		return false, err
+	}
+
+	reclaimed, err := reclaimNodes(tx, now, "node_id = ?", nodeId)
+	if err != nil {
+		return false, err
+	}
+
+	return reclaimed > 0, tx.Commit()
+}
+
+// UnlinkNode removes a node from the tree. A node some session has open is
+// kept, without parent and path so it can no longer be found, until the
+// last session closes it. It tells whether the node was kept and fails with
+// sql.ErrNoRows when the node is gone or unlinked already.
+func (database *Database) UnlinkNode(node interfaces.Node, now int64) (bool, error) {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	err = purgeSessions(tx, now)
+	if err != nil {
+		return false, err
+	}
+
+	var open bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM open_nodes o
+			JOIN sessions s ON s.id = o.session_id
+			WHERE o.node_id = ? AND s.expires >= ?
+		)
+	`, node.GetId(), now).Scan(&open)
+	if err != nil {
+		return false, err
+	}
+
+	// The path of an unlinked node does not start with a slash, no lookup
+	// reaches it and it stays unique
+	query := "UPDATE nodes SET parent_id = NULL, path = 'unlinked:' || id WHERE id = ?1 AND id NOT IN (SELECT node_id FROM unlinked_nodes)"
+	if !open {
+		query = "DELETE FROM nodes WHERE id = ?1 AND id NOT IN (SELECT node_id FROM unlinked_nodes)"
+	}
+
+	// A node kept leaves the directory quotas along with its path
+	if open {
+		var path string
+		err = tx.QueryRow("SELECT path FROM nodes WHERE id = ?", node.GetId()).Scan(&path)
+		if err != nil {
+			return false, err
+		}
+
+		err = database.moveQuotaUsage(tx, path, "")
+		if err != nil {
+			return false, err
+		}
+	}
+
+	result, err := tx.Exec(query, node.GetId())
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if changed == 0 {
+		return false, sql.ErrNoRows
+	}
+
+	if open {
+		_, err = tx.Exec("INSERT INTO unlinked_nodes (node_id) VALUES (?)", node.GetId())
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return open, tx.Commit()
+}
+
+// ReclaimUnlinkedNodes deletes the unlinked nodes nobody has open any more,
+// those of sessions that expired before now included. It returns how many
+// it deleted.
+func (database *Database) ReclaimUnlinkedNodes(now int64) (int64, error) {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = purgeSessions(tx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	reclaimed, err := reclaimNodes(tx, now, "")
+	if err != nil {
+		return 0, err
+	}
+
+	return reclaimed, tx.Commit()
+}
+
+// purgeSessions deletes the sessions that expired before now along with
+// their locks and open nodes
+func purgeSessions(tx *sql.Tx, now int64) error {
+	_, err := tx.Exec("DELETE FROM sessions WHERE expires < ?", now)
+
+	return err
+}
+
+// reclaimNodes deletes the unlinked nodes matching condition, if any, that
+// no session alive at now has open. Their search index rows go with them.
+func reclaimNodes(tx *sql.Tx, now int64, condition string, args ...any) (int64, error) {
+	if condition != "" {
+		condition = " AND " + condition
+	}
+
+	rows, err := tx.Query(`
+		SELECT node_id
+		FROM unlinked_nodes u
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM open_nodes o
+			JOIN sessions s ON s.id = o.session_id
+			WHERE o.node_id = u.node_id AND s.expires >= ?
+		)`+condition,
+		append([]any{now}, args...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		ids = append(ids, fmt.Sprint(id))
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	list := strings.Join(ids, ", ")
+
+	_, err = tx.Exec("DELETE FROM nodes WHERE id IN (" + list + ")")
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM unlinked_nodes WHERE node_id IN (" + list + ")")
+	if err != nil {
+		return 0, err
+	}
+
+	var search int
+	err = tx.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'node_search'").Scan(&search)
+	if err != nil {
+		return 0, err
+	}
+
+	if search > 0 {
+		_, err = tx.Exec("DELETE FROM node_search WHERE rowid IN (" + list + ")")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(ids)), nil
+}
@@ -17,7 +17,7 @@ func (d *Database) InsertSymlink(
 }
 
 func (database *Database) GetSymlink(id int64) (interfaces.Symlink, error) {
-	row := database.db.QueryRow(`
+	row := database.reader.QueryRow(`
 		SELECT id, source_node_id, target_node_id
 		FROM symlinks
 		WHERE id = ?
@@ -27,7 +27,7 @@ func (database *Database) GetSymlink(id int64) (interfaces.Symlink, error) {
 }
 
 func (database *Database) GetSymlinkBySourceNode(sourceNode interfaces.Node) (interfaces.Symlink, error) {
-	row := database.db.QueryRow(`
+	row := database.reader.QueryRow(`
 		SELECT id, source_node_id, target_node_id
 		FROM symlinks
 		WHERE source_node_id = ?
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"io/fs"
+)
+
+// NodeUsage totals the nodes of a subtree
+type NodeUsage struct {
+	Nodes       int64
+	Files       int64
+	Directories int64
+	Bytes       int64
+}
+
+// GetNodeUsage sums up path and everything below it
+func (database *Database) GetNodeUsage(path string) (NodeUsage, error) {
+	if path == "/" {
+		return database.nodeUsage("")
+	}
+
+	return database.nodeUsage(subtreeCondition, subtreeArgs(path)...)
+}
+
+// nodeUsage sums up the nodes of the namespace matching condition, if any,
+// unlinked nodes still held open are no longer part of any tree
+func (database *Database) nodeUsage(condition string, args ...any) (NodeUsage, error) {
+	var usage NodeUsage
+
+	if condition != "" {
+		condition = " AND " + condition
+	}
+
+	err := database.reader.QueryRow(`
+		SELECT
+			count(*),
+			ifnull(sum(mode & ? = 0), 0),
+			ifnull(sum(mode & ? != 0), 0),
+			ifnull(sum(size), 0)
+		FROM nodes
+		WHERE namespace_id = ? AND id NOT IN (SELECT node_id FROM unlinked_nodes)`+condition,
+		append([]any{int64(fs.ModeType), int64(fs.ModeDir), database.namespace}, args...)...,
+	).Scan(&usage.Nodes, &usage.Files, &usage.Directories, &usage.Bytes)
+
+	return usage, err
+}
+
+// PageStats describes the database file in pages
+type PageStats struct {
+	PageSize  int64
+	PageCount int64
+	// FreePages are allocated in the file but unused, they are reused before it grows
+	FreePages int64
+}
+
+func (database *Database) GetPageStats() (PageStats, error) {
+	var stats PageStats
+
+	for pragma, value := range map[string]*int64{
+		"page_size":      &stats.PageSize,
+		"page_count":     &stats.PageCount,
+		"freelist_count": &stats.FreePages,
+	} {
+		err := database.reader.QueryRow("PRAGMA " + pragma).Scan(value)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
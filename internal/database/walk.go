@@ -0,0 +1,181 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+// WalkNode is a node reached by WalkNodes
+type WalkNode struct {
+	Node  interfaces.Node
+	Depth int
+	// Key joins the names on the way down from the root with char(1), which
+	// sorts before any character a name holds, so a directory and its
+	// contents stay together ahead of siblings that merely share a prefix.
+	// Walks come in order of it and resume after one.
+	Key string
+}
+
+// WalkNodes returns up to limit nodes below root in depth first order,
+// siblings in lexical order of their name, starting after the node with key
+// after. A key that ends in char(2) instead of a name resumes after the whole
+// subtree of the node it names. A maxDepth of 0 or less does not limit the
+// depth.
+//
+// The recursive query takes the next node from a queue ordered by key, so
+// it stops once limit nodes are found rather than walking the whole tree.
+// Only directories on the way down to after and those past it are entered,
+// each with no more children than the page can hold, so a page costs about
+// the same wherever it falls.
+func (database *Database) WalkNodes(root interfaces.Node, after string, maxDepth int, limit int) ([]WalkNode, error) {
+	// Root, the nodes on the way down to after and after itself come out of
+	// the queue first and are left out below
+	queued := limit + strings.Count(after, "\x01") + 1
+
+	cursor, args := walkCursor(after, 7)
+
+	rows, err := database.reader.Query(`
+		WITH RECURSIVE
+		cursor(key, next) AS (`+cursor+`),
+		walk(id, depth, key) AS (
+			SELECT id, 0, '' FROM nodes WHERE id = ?1 AND namespace_id = ?2
+			UNION ALL
+			SELECT nodes.id, walk.depth + 1, walk.key || char(1) || nodes.name
+			FROM walk
+			LEFT JOIN cursor ON cursor.key = walk.key
+			JOIN nodes ON nodes.id IN (
+				SELECT child.id
+				FROM nodes AS child
+				WHERE child.namespace_id = ?2 AND child.parent_id = walk.id AND child.name >= ifnull(cursor.next, '') AND child.id != ?1
+				ORDER BY child.name
+				LIMIT ?5
+			)
+			WHERE ?3 <= 0 OR walk.depth < ?3
+			ORDER BY 3
+			LIMIT ?6
+		)
+		SELECT nodes.id, nodes.name, nodes.parent_id, nodes.path, nodes.mode, nodes.uid, nodes.gid, nodes.mod_time, nodes.create_time, nodes.access_time, nodes.size, nodes.flags, walk.depth, walk.key
+		FROM walk
+		CROSS JOIN nodes ON nodes.id = walk.id
+		WHERE walk.key > ?4
+		ORDER BY walk.key
+	`, append([]any{root.GetId(), database.namespace, maxDepth, after, queued, queued}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []WalkNode
+	for rows.Next() {
+		var walkNode WalkNode
+
+		node, err := database.nodeFactory.New(walkScanner{rows, &walkNode.Depth, &walkNode.Key})
+		if err != nil {
+			return nil, err
+		}
+
+		walkNode.Node = node
+		nodes = append(nodes, walkNode)
+	}
+
+	return nodes, rows.Err()
+}
+
+// walkCursor lists the directories on the way down to after by key, along
+// with the name the walk resumes at in each. Its parameters are numbered
+// from first on.
+func walkCursor(after string, first int) (string, []any) {
+	if after == "" {
+		return "SELECT NULL, NULL WHERE 0", nil
+	}
+
+	var values []string
+	var args []any
+
+	key := ""
+	for _, name := range strings.Split(after[1:], "\x01") {
+		values = append(values, fmt.Sprintf("(?%d, ?%d)", first+len(args), first+len(args)+1))
+		args = append(args, key, name)
+		key += "\x01" + name
+	}
+
+	return "VALUES " + strings.Join(values, ", "), args
+}
+
+// walkScanner scans the depth and key that follow the node columns
+type walkScanner struct {
+	scanner interfaces.RowScanner
+	depth   *int
+	key     *string
+}
+
+func (s walkScanner) Scan(dest ...any) error {
+	return s.scanner.Scan(append(dest, s.depth, s.key)...)
+}
+
+// GetNodesByParentsAfter returns up to limit children of parents, by the
+// position of their parent and then by name, that come after the child
+// named after of parents[afterParent]. Breadth first walks read a depth
+// with it a number of directories at a time.
+//
+// Like WalkNodes it takes children from a queue ordered the way they are
+// returned, so it stops once limit children are found and takes no more
+// than that from any one parent.
+func (database *Database) GetNodesByParentsAfter(parents []interfaces.Node, afterParent int, after string, limit int) ([]interfaces.Node, error) {
+	if len(parents) == 0 {
+		return nil, nil
+	}
+
+	values := make([]string, len(parents))
+	args := []any{database.namespace, afterParent, after, limit, limit + len(parents)}
+
+	for i, parent := range parents {
+		values[i] = fmt.Sprintf("(?%d, ?%d)", len(args)+1, len(args)+2)
+		args = append(args, i, parent.GetId())
+	}
+
+	rows, err := database.reader.Query(`
+		WITH RECURSIVE
+		parents(position, id) AS (VALUES `+strings.Join(values, ", ")+`),
+		children(position, name, id, child) AS (
+			SELECT position, '', id, 0 FROM parents WHERE position >= ?2
+			UNION ALL
+			SELECT children.position, nodes.name, nodes.id, 1
+			FROM children
+			JOIN nodes ON nodes.id IN (
+				SELECT child.id
+				FROM nodes AS child
+				WHERE child.namespace_id = ?1 AND child.parent_id = children.id AND child.name > iif(children.position = ?2, ?3, '')
+				ORDER BY child.name
+				LIMIT ?4
+			)
+			WHERE children.child = 0
+			ORDER BY 1, 2
+			LIMIT ?5
+		)
+		SELECT nodes.id, nodes.name, nodes.parent_id, nodes.path, nodes.mode, nodes.uid, nodes.gid, nodes.mod_time, nodes.create_time, nodes.access_time, nodes.size, nodes.flags
+		FROM children
+		CROSS JOIN nodes ON nodes.id = children.id
+		WHERE children.child = 1
+		ORDER BY children.position, children.name
+		LIMIT ?4
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []interfaces.Node
+	for rows.Next() {
+		node, err := database.nodeFactory.New(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, rows.Err()
+}
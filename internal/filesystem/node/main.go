@@ -59,6 +59,14 @@ func (node *Node) GetAccessTime() string {
 	return node.entity.GetAccessTime()
 }
 
+func (node *Node) GetSize() int64 {
+	return node.entity.GetSize()
+}
+
+func (node *Node) GetFlags() uint32 {
+	return uint32(node.entity.GetFlags())
+}
+
 func (node *Node) SetName(name string) {
 	node.entity.SetName(name)
 }
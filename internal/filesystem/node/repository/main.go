@@ -34,6 +34,15 @@ func (r *Repository) GetByName(name string) (interfaces.Node, error) {
 	return node.New(entity)
 }
 
+func (r *Repository) GetByPath(path string) (interfaces.Node, error) {
+	entity, err := r.database.GetNodeByPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return node.New(entity)
+}
+
 func (r *Repository) GetByParentAndName(parent interfaces.Node, name string) (interfaces.Node, error) {
 	entity, err := r.database.GetNodeByParentAndName(parent.GetEntity(), name)
 	if err != nil {
@@ -61,3 +70,79 @@ func (r *Repository) GetChildren(parent interfaces.Node) ([]interfaces.Node, err
 
 	return nodes, nil
 }
+
+func (r *Repository) GetChildrenAfter(parent interfaces.Node, after string, limit int) ([]interfaces.Node, error) {
+	entities, err := r.database.GetNodesByParentAfter(parent.GetEntity(), after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []interfaces.Node
+	for _, entity := range entities {
+		node, err := node.New(entity)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+func (r *Repository) GetByPaths(paths []string) ([]interfaces.Node, error) {
+	entities, err := r.database.GetNodesByPaths(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []interfaces.Node
+	for _, entity := range entities {
+		node, err := node.New(entity)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+func (r *Repository) GetAll() ([]interfaces.Node, error) {
+	entities, err := r.database.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []interfaces.Node
+	for _, entity := range entities {
+		node, err := node.New(entity)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+func (r *Repository) Find(filter database.NodeFilter) ([]interfaces.Node, error) {
+	entities, err := r.database.FindNodes(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []interfaces.Node
+	for _, entity := range entities {
+		node, err := node.New(entity)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
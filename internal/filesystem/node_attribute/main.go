@@ -0,0 +1,50 @@
+package node_attribute
+
+import (
+	"github.com/sushydev/vfs_go/interfaces"
+	database_interfaces "github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+type NodeAttribute struct {
+	entity database_interfaces.NodeAttribute
+}
+
+var _ interfaces.NodeAttribute = &NodeAttribute{}
+
+func New(entity database_interfaces.NodeAttribute) (*NodeAttribute, error) {
+	return &NodeAttribute{
+		entity: entity,
+	}, nil
+}
+
+func (nodeAttribute *NodeAttribute) GetId() uint64 {
+	return uint64(nodeAttribute.entity.GetId())
+}
+
+func (nodeAttribute *NodeAttribute) GetNodeId() uint64 {
+	return uint64(nodeAttribute.entity.GetNodeId())
+}
+
+func (nodeAttribute *NodeAttribute) GetKey() string {
+	return nodeAttribute.entity.GetKey()
+}
+
+func (nodeAttribute *NodeAttribute) GetValue() string {
+	return nodeAttribute.entity.GetValue()
+}
+
+func (nodeAttribute *NodeAttribute) SetNodeId(nodeId uint64) {
+	nodeAttribute.entity.SetNodeId(int64(nodeId))
+}
+
+func (nodeAttribute *NodeAttribute) SetKey(key string) {
+	nodeAttribute.entity.SetKey(key)
+}
+
+func (nodeAttribute *NodeAttribute) SetValue(value string) {
+	nodeAttribute.entity.SetValue(value)
+}
+
+func (nodeAttribute *NodeAttribute) GetEntity() database_interfaces.NodeAttribute {
+	return nodeAttribute.entity
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"github.com/sushydev/vfs_go/interfaces"
+	"github.com/sushydev/vfs_go/internal/database"
+	"github.com/sushydev/vfs_go/internal/filesystem/node_attribute"
+)
+
+type Repository struct {
+	database *database.Database
+}
+
+func New(database *database.Database) *Repository {
+	return &Repository{
+		database: database,
+	}
+}
+
+func (r *Repository) GetByNodeAndKey(node interfaces.Node, key string) (interfaces.NodeAttribute, error) {
+	entity, err := r.database.GetNodeAttributeByNodeAndKey(node.GetEntity(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return node_attribute.New(entity)
+}
+
+func (r *Repository) GetByNode(node interfaces.Node) ([]interfaces.NodeAttribute, error) {
+	entities, err := r.database.GetNodeAttributesByNode(node.GetEntity())
+	if err != nil {
+		return nil, err
+	}
+
+	var nodeAttributes []interfaces.NodeAttribute
+	for _, entity := range entities {
+		nodeAttribute, err := node_attribute.New(entity)
+		if err != nil {
+			return nil, err
+		}
+
+		nodeAttributes = append(nodeAttributes, nodeAttribute)
+	}
+
+	return nodeAttributes, nil
+}
@@ -0,0 +1,216 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/sushydev/vfs_go/internal/database"
+)
+
+// Waiting for a lock polls the database, starting at lockPollMin and backing
+// off up to lockPollMax
+const (
+	lockPollMin = 5 * time.Millisecond
+	lockPollMax = 250 * time.Millisecond
+)
+
+type LockType int
+
+const (
+	// LockShared may be held by any number of owners at once, for reading
+	LockShared LockType = iota + 1
+	// LockExclusive is held by a single owner, for writing
+	LockExclusive
+)
+
+// LockRange is Length bytes from Start. A Length of 0 reaches to the end of
+// the file however far it grows, the zero LockRange covers the whole file.
+type LockRange struct {
+	Start  int64
+	Length int64
+}
+
+// HeldLock is a lock someone holds on a file
+type HeldLock struct {
+	Type  LockType
+	Range LockRange
+	// Pid is the process holding the lock
+	Pid int
+}
+
+// Lock takes an advisory lock of lockType on lockRange of the file open in
+// handle. Locks are kept in the database, they work between every process
+// using it. They belong to the handle, or to the owner set with
+// SetLockOwner, and a new lock replaces what that owner held of the range.
+// Locks of different owners conflict when they overlap and either is
+// exclusive. A shared lock takes a handle open for reading and an exclusive
+// one a handle open for writing.
+//
+// Without wait a conflicting lock fails with EAGAIN, with it Lock waits until
+// the lock is free. Waiting fails with EDEADLK when the owners in the way
+// are waiting for this owner in turn. Locks are released by Unlock, when the
+// handle is closed, when the VFS is closed, or sessionLease after the process
+// holding them died.
+func (f *FileSystem) Lock(handle *Handle, lockType LockType, lockRange LockRange, wait bool) error {
+	lock, err := f.handleLock(handle, lockRange)
+	if err != nil {
+		return err
+	}
+
+	switch lockType {
+	case LockShared:
+		if !handle.readable {
+			return syscall.EBADF
+		}
+	case LockExclusive:
+		if !handle.writable {
+			return syscall.EBADF
+		}
+
+		lock.Exclusive = true
+	default:
+		return syscall.EINVAL
+	}
+
+	for delay := lockPollMin; ; delay = min(delay*2, lockPollMax) {
+		err = f.database.AcquireLock(lock, wait, time.Now().UnixMilli())
+		switch err {
+		case nil:
+			return nil
+		case database.ErrDeadlock:
+			return syscall.EDEADLK
+		case database.ErrLockConflict:
+			if !wait {
+				return syscall.EAGAIN
+			}
+		default:
+			f.database.CancelLockWait(lock.SessionId, lock.Owner)
+			return constraintError(err)
+		}
+
+		time.Sleep(delay)
+	}
+}
+
+// Unlock releases what the owner of handle holds of lockRange
+func (f *FileSystem) Unlock(handle *Handle, lockRange LockRange) error {
+	lock, err := f.handleLock(handle, lockRange)
+	if err != nil {
+		return err
+	}
+
+	return f.database.ReleaseLock(lock)
+}
+
+// Locks lists the locks held on the file id by anyone
+func (f *FileSystem) Locks(id uint64) ([]HeldLock, error) {
+	_, err := f.Open(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if isMountedId(id) || isGeneratedId(id) {
+		return nil, nil
+	}
+
+	locks, err := f.database.GetLocks(int64(id), time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	held := make([]HeldLock, 0, len(locks))
+	for _, lock := range locks {
+		heldLock := HeldLock{Type: LockShared, Range: LockRange{Start: lock.Start}, Pid: lock.Pid}
+
+		if lock.Exclusive {
+			heldLock.Type = LockExclusive
+		}
+
+		if lock.End != database.LockEnd {
+			heldLock.Range.Length = lock.End - lock.Start
+		}
+
+		held = append(held, heldLock)
+	}
+
+	return held, nil
+}
+
+// SetLockOwner makes the locks taken through h belong to owner, shared with
+// every handle of this VFS given the same owner. Frontends pass the owner
+// their clients lock as, like the lock owner of a FUSE request. Deadlocks
+// are found between owners, a cycle through handles that belong to one
+// caller is only seen when they share an owner. It applies to locks taken
+// after.
+func (h *Handle) SetLockOwner(owner uint64) {
+	h.lockOwner = fmt.Sprintf("owner:%d", owner)
+}
+
+// handleLock returns the lock of lockRange in the session of f, for the
+// owner of handle
+func (f *FileSystem) handleLock(handle *Handle, lockRange LockRange) (database.Lock, error) {
+	if handle.closed {
+		return database.Lock{}, os.ErrClosed
+	}
+
+	err := f.requireWritable()
+	if err != nil {
+		return database.Lock{}, err
+	}
+
+	if isGeneratedId(handle.node.GetId()) {
+		return database.Lock{}, syscall.ENOLCK
+	}
+
+	if lockRange.Start < 0 || lockRange.Length < 0 {
+		return database.Lock{}, syscall.EINVAL
+	}
+
+	end := int64(database.LockEnd)
+	if lockRange.Length > 0 {
+		end = lockRange.Start + lockRange.Length
+		if end < lockRange.Start {
+			return database.Lock{}, syscall.EOVERFLOW
+		}
+	}
+
+	sessionId, err := f.session.start(f.database)
+	if err != nil {
+		return database.Lock{}, err
+	}
+
+	f.session.mutex.Lock()
+	if handle.lockId == 0 {
+		f.session.handles++
+		handle.lockId = f.session.handles
+	}
+	f.session.mutex.Unlock()
+
+	if handle.lockOwner == "" {
+		handle.lockOwner = fmt.Sprintf("handle:%d", handle.lockId)
+	}
+
+	return database.Lock{
+		SessionId: sessionId,
+		Owner:     handle.lockOwner,
+		Handle:    handle.lockId,
+		NodeId:    int64(handle.node.GetId()),
+		Start:     lockRange.Start,
+		End:       end,
+	}, nil
+}
+
+// releaseLocks drops the locks taken through handle, if it took any
+func (s *sessionState) releaseLocks(handle *Handle) error {
+	s.mutex.Lock()
+	sessionId, database := s.sessionId, s.database
+	s.mutex.Unlock()
+
+	if handle.lockId == 0 || sessionId == 0 {
+		return nil
+	}
+
+	return database.ReleaseHandleLocks(sessionId, handle.lockId, handle.lockOwner)
+}
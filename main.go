@@ -1,14 +1,31 @@
+// Package filesystem is a virtual file system stored in SQLite.
+//
+// A FileSystem, and every view made from it with As, Namespace or Sub, is
+// safe for use by many goroutines at once. Each call is atomic on its own:
+// creating a name that is taken fails with EEXIST, removing a directory
+// that gained an entry fails with ENOTEMPTY, and nodes are created and moved
+// with paths made from where their new parent is at that moment. Sequences
+// of calls are not atomic, a node looked up may be gone by the next call and
+// then fails with ENOENT. LookupOrTouch and LookupOrMkDir create a node only
+// if it is absent, for when several callers may want the same one.
+//
+// Quotas are checked before a change is written, callers changing a tree at
+// the same moment may take it past a limit by what they write together.
 package filesystem
 
 import (
 	"database/sql"
 	"fmt"
 	"io/fs"
+	gopath "path"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sushydev/vfs_go/interfaces"
 	"github.com/sushydev/vfs_go/internal/database"
 	node_repository "github.com/sushydev/vfs_go/internal/filesystem/node/repository"
+	node_attribute_repository "github.com/sushydev/vfs_go/internal/filesystem/node_attribute/repository"
 	node_content_repository "github.com/sushydev/vfs_go/internal/filesystem/node_content/repository"
 	symlink_repository "github.com/sushydev/vfs_go/internal/filesystem/symlink/repository"
 )
@@ -18,22 +35,172 @@ type FileSystem struct {
 	nodeRepository *node_repository.Repository
 	nodeContentRepository *node_content_repository.Repository
 	symlinkRepository *symlink_repository.Repository
+	nodeAttributeRepository *node_attribute_repository.Repository
+	mounts *mountTable
+	providers *providerTable
+	generators *generatorTable
+	search *searchIndex
+	session *sessionState
+	namespaces *namespaceTable
+	// namespace is the name of the tree f works on and rootId the id of its root
+	namespace string
+	rootId uint64
+	// readOnly is set when the database was opened read-only
+	readOnly bool
+	// cred is who a view made with As acts as, nil when calls are not checked
+	cred *Cred
+	// generating are the generated files whose generators call through this
+	// view, their size is not produced again while they run
+	generating []uint64
 }
 
 var _ interfaces.FileSystem = &FileSystem{}
 
+// Options change how NewWith opens the VFS and tune SQLite underneath it.
+// Fields left zero are taken from DefaultOptions.
+type Options struct {
+	// ReadOnly opens the database read-only, everything that would change it
+	// fails with EROFS. The database has to be migrated to this version.
+	ReadOnly bool
+	// JournalMode is the SQLite journal mode, WAL lets queries go on while
+	// changes are written
+	JournalMode string
+	// Synchronous is how often SQLite waits for the disk: OFF, NORMAL, FULL or EXTRA
+	Synchronous string
+	// BusyTimeout is how long a connection waits for a lock before failing with SQLITE_BUSY
+	BusyTimeout time.Duration
+	// CacheSize is the page cache of each connection in KiB
+	CacheSize int64
+	// MmapSize is how many bytes of the database are memory mapped, -1 turns it off
+	MmapSize int64
+	// DisableForeignKeys leaves foreign keys unenforced, removing a node then
+	// leaves its content, attributes and children behind
+	DisableForeignKeys bool
+	// MaxReaders and MaxWriters size the connection pools of queries and of
+	// changes. SQLite has a single writer, more than one makes them wait on
+	// each other's locks.
+	MaxReaders int
+	MaxWriters int
+}
+
+// DefaultOptions are what New opens the VFS with
+var DefaultOptions = Options{
+	JournalMode: "WAL",
+	Synchronous: "NORMAL",
+	BusyTimeout: 5 * time.Second,
+	CacheSize:   16 << 10,
+	MmapSize:    256 << 20,
+	MaxReaders:  4,
+	MaxWriters:  1,
+}
+
+// withDefaults fills the zero fields of o from DefaultOptions
+func (o Options) withDefaults() Options {
+	if o.JournalMode == "" {
+		o.JournalMode = DefaultOptions.JournalMode
+	}
+
+	if o.Synchronous == "" {
+		o.Synchronous = DefaultOptions.Synchronous
+	}
+
+	if o.BusyTimeout == 0 {
+		o.BusyTimeout = DefaultOptions.BusyTimeout
+	}
+
+	if o.CacheSize == 0 {
+		o.CacheSize = DefaultOptions.CacheSize
+	}
+
+	if o.MmapSize == 0 {
+		o.MmapSize = DefaultOptions.MmapSize
+	}
+
+	if o.MmapSize < 0 {
+		o.MmapSize = 0
+	}
+
+	if o.MaxReaders == 0 {
+		o.MaxReaders = DefaultOptions.MaxReaders
+	}
+
+	if o.MaxWriters == 0 {
+		o.MaxWriters = DefaultOptions.MaxWriters
+	}
+
+	return o
+}
+
 func New(path string) (*FileSystem, error) {
-	database, err := database.New(path)
+	return NewWith(path, DefaultOptions)
+}
+
+// NewWith opens the VFS at path with options
+func NewWith(path string, options Options) (*FileSystem, error) {
+	options = options.withDefaults()
+
+	database, err := database.New(path, database.Options{
+		ReadOnly:    options.ReadOnly,
+		JournalMode: options.JournalMode,
+		Synchronous: options.Synchronous,
+		BusyTimeout: options.BusyTimeout,
+		CacheSize:   options.CacheSize,
+		MmapSize:    options.MmapSize,
+		ForeignKeys: !options.DisableForeignKeys,
+		MaxReaders:  options.MaxReaders,
+		MaxWriters:  options.MaxWriters,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &FileSystem{
-		database:       database,
-		nodeRepository: node_repository.New(database),
-		nodeContentRepository: node_content_repository.New(database),
-		symlinkRepository: symlink_repository.New(database),
-	}, nil
+	fileSystem := &FileSystem{
+		readOnly: options.ReadOnly,
+		providers: newProviderTable(),
+		search: &searchIndex{},
+		session: &sessionState{},
+		namespaces: newNamespaceTable(),
+	}
+
+	fileSystem.scope(database, DefaultNamespace, 0)
+	fileSystem.namespaces.views[DefaultNamespace] = fileSystem
+
+	// Removed files that were still open when their process died are
+	// deleted once its session expired
+	if !options.ReadOnly {
+		_, err = database.ReclaimUnlinkedNodes(time.Now().UnixMilli())
+		if err != nil {
+			database.Close()
+			return nil, err
+		}
+	}
+
+	err = fileSystem.restoreMounts()
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	err = fileSystem.restoreSearch()
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return fileSystem, nil
+}
+
+// scope points f at the tree of one namespace, along with the state that is
+// kept per namespace
+func (f *FileSystem) scope(database *database.Database, namespace string, rootId uint64) {
+	f.database = database
+	f.nodeRepository = node_repository.New(database)
+	f.nodeContentRepository = node_content_repository.New(database)
+	f.symlinkRepository = symlink_repository.New(database)
+	f.nodeAttributeRepository = node_attribute_repository.New(database)
+	f.mounts = newMountTable()
+	f.generators = newGeneratorTable()
+	f.namespace, f.rootId = namespace, rootId
 }
 
 func getPath(parentNode interfaces.Node, name string) string {
@@ -44,8 +211,59 @@ func getPath(parentNode interfaces.Node, name string) string {
 	return parentNode.GetPath() + "/" + name
 }
 
+// constraintError turns SQLite refusing a change into the errno of the race
+// behind it: a name taken or a node removed since it was looked up, a
+// directory moved below the one being moved, or flags set in the meantime
+func constraintError(err error) error {
+	switch {
+	case err == sql.ErrNoRows, database.IsForeignKeyViolation(err):
+		return syscall.ENOENT
+	case database.IsUniqueViolation(err):
+		return syscall.EEXIST
+	case err == database.ErrLoop:
+		return syscall.EINVAL
+	case err == database.ErrFlagged:
+		return syscall.EPERM
+	}
+
+	return err
+}
+
+func (f *FileSystem) getNode(id uint64) (interfaces.Node, error) {
+	if isMountedId(id) || isGeneratedId(id) {
+		return f.open(id)
+	}
+
+	node, err := f.nodeRepository.Get(id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if node == nil {
+		return nil, syscall.ENOENT
+	}
+
+	return node, nil
+}
+
+// changed invalidates what is derived from the directories parentIds
+func (f *FileSystem) changed(parentIds ...uint64) {
+	for _, parentId := range parentIds {
+		f.generators.invalidate(parentId)
+	}
+}
+
+// isWithin reports whether node is ancestor itself or one of its descendants
+func isWithin(node interfaces.Node, ancestor interfaces.Node) bool {
+	if ancestor.GetPath() == "/" {
+		return true
+	}
+
+	return node.GetPath() == ancestor.GetPath() || strings.HasPrefix(node.GetPath(), ancestor.GetPath()+"/")
+}
+
 func (f *FileSystem) Root() (interfaces.Node, error) {
-	root, err := f.nodeRepository.Get(0)
+	root, err := f.nodeRepository.Get(f.rootId)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
@@ -58,6 +276,40 @@ func (f *FileSystem) Root() (interfaces.Node, error) {
 }
 
 func (f *FileSystem) Open(id uint64) (interfaces.Node, error) {
+	node, err := f.open(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.cred != nil {
+		err = f.traverse(node.GetPath())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+func (f *FileSystem) open(id uint64) (interfaces.Node, error) {
+	if isGeneratedId(id) {
+		instance, err := f.generators.get(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return f.generatedNode(instance)
+	}
+
+	if mount, local, ok := f.mounts.owner(id); ok {
+		node, err := mount.fileSystem.Open(local)
+		if err != nil {
+			return nil, err
+		}
+
+		return mount.translate(node)
+	}
+
 	node, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
@@ -80,10 +332,76 @@ func (f *FileSystem) Find(name string) (interfaces.Node, error) {
 		return nil, syscall.ENOENT
 	}
 
+	if f.cred != nil {
+		err = f.traverse(node.GetPath())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// LookupPath finds a node by its absolute path, symlinks along the way are not followed
+func (f *FileSystem) LookupPath(path string) (interfaces.Node, error) {
+	node, err := f.lookupPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.cred != nil {
+		err = f.traverse(node.GetPath())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+func (f *FileSystem) lookupPath(path string) (interfaces.Node, error) {
+	path = gopath.Clean("/" + path)
+
+	if mount, rest, ok := f.mounts.byPath(path); ok {
+		return mount.lookupPath(rest)
+	}
+
+	node, err := f.nodeRepository.GetByPath(path)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if node == nil && path != "/" {
+		parentNode, err := f.lookupPath(gopath.Dir(path))
+		if err == nil && parentNode.GetMode().IsDir() {
+			if instance := f.generators.find(parentNode, gopath.Base(path)); instance != nil {
+				return f.generatedNode(instance)
+			}
+		}
+	}
+
+	if node == nil {
+		return nil, syscall.ENOENT
+	}
+
 	return node, nil
 }
 
 func (f *FileSystem) ReadDir(id uint64) ([]interfaces.Node, error) {
+	err := f.checkAccess(id, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+
+	if mount, local, ok := f.mounts.resolve(id); ok {
+		nodes, err := mount.fileSystem.ReadDir(local)
+		if err != nil {
+			return nil, err
+		}
+
+		return mount.translateAll(nodes)
+	}
+
 	parentNode, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
@@ -97,10 +415,29 @@ func (f *FileSystem) ReadDir(id uint64) ([]interfaces.Node, error) {
 		return nil, syscall.ENOTDIR
 	}
 
-	return f.nodeRepository.GetChildren(parentNode)
+	children, err := f.nodeRepository.GetChildren(parentNode)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.generatedNodes(parentNode, children)
 }
 
 func (f *FileSystem) Lookup(parentId uint64, name string) (interfaces.Node, error) {
+	err := f.checkAccess(parentId, AccessExecute)
+	if err != nil {
+		return nil, err
+	}
+
+	if mount, local, ok := f.mounts.resolve(parentId); ok {
+		node, err := mount.fileSystem.Lookup(local, name)
+		if err != nil {
+			return nil, err
+		}
+
+		return mount.translate(node)
+	}
+
 	parentNode, err := f.nodeRepository.Get(parentId)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
@@ -120,13 +457,66 @@ func (f *FileSystem) Lookup(parentId uint64, name string) (interfaces.Node, erro
 	}
 
 	if node == nil {
+		if instance := f.generators.find(parentNode, name); instance != nil {
+			return f.generatedNode(instance)
+		}
+
 		return nil, syscall.ENOENT
 	}
 
 	return node, nil
 }
 
+// LookupOrTouch returns the node name in parentId, creating it as an empty
+// file when there is none. Of callers racing for the same name exactly one
+// creates it, created tells whether that was this one. The node found may be
+// of any type.
+func (f *FileSystem) LookupOrTouch(parentId uint64, name string) (node interfaces.Node, created bool, err error) {
+	return f.lookupOrCreate(parentId, name, f.Touch)
+}
+
+// LookupOrMkDir is LookupOrTouch for directories
+func (f *FileSystem) LookupOrMkDir(parentId uint64, name string) (node interfaces.Node, created bool, err error) {
+	return f.lookupOrCreate(parentId, name, f.MkDir)
+}
+
+// lookupOrCreate goes around again when the node is taken or removed by
+// someone else between looking it up and creating it
+func (f *FileSystem) lookupOrCreate(parentId uint64, name string, create func(parentId uint64, name string) error) (interfaces.Node, bool, error) {
+	for {
+		node, err := f.Lookup(parentId, name)
+		if err != syscall.ENOENT {
+			return node, false, err
+		}
+
+		err = create(parentId, name)
+		if err == syscall.EEXIST {
+			continue
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		node, err = f.Lookup(parentId, name)
+		if err == syscall.ENOENT {
+			continue
+		}
+
+		return node, err == nil, err
+	}
+}
+
 func (f *FileSystem) MkDir(parentId uint64, name string) error {
+	err := f.checkCreate(parentId)
+	if err != nil {
+		return err
+	}
+
+	if mount, local, ok := f.mounts.resolve(parentId); ok {
+		return mount.mkDir(local, name)
+	}
+
 	parentNode, err := f.nodeRepository.Get(parentId)
 	if err != nil && err != sql.ErrNoRows {
 		return err
@@ -142,11 +532,48 @@ func (f *FileSystem) MkDir(parentId uint64, name string) error {
 
 	path := getPath(parentNode, name)
 
-	return f.database.InsertNode(name, parentNode.GetEntity(), path, uint32(fs.ModeDir), 0, 0, 0, "", "")
+	mode, uid, gid := f.newNodeOwner(parentNode, fs.ModeDir)
+
+	mode, access, inherited, err := f.inheritACL(parentNode, mode)
+	if err != nil {
+		return err
+	}
+
+	err = f.chargeQuota(quotaCharge{path: path, uid: uid, gid: gid, nodes: 1})
+	if err != nil {
+		return err
+	}
+
+	err = f.database.InsertNode(name, parentNode.GetEntity(), mode, uid, gid, 0, "", "")
+	if err != nil {
+		return constraintError(err)
+	}
+
+	err = f.applyACL(parentNode, name, access, inherited)
+	if err != nil {
+		return err
+	}
+
+	f.changed(parentId)
+
+	return nil
 }
 
 // TODO RmDir -f flag
 func (f *FileSystem) RmDir(id uint64) error {
+	err := f.checkRemove(id)
+	if err != nil {
+		return err
+	}
+
+	if f.mounts.isPoint(id) {
+		return syscall.EBUSY
+	}
+
+	if mount, local, ok := f.mounts.owner(id); ok {
+		return mount.rmDir(local)
+	}
+
 	node, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return err
@@ -156,24 +583,45 @@ func (f *FileSystem) RmDir(id uint64) error {
 		return syscall.ENOENT
 	}
 
-	children, err := f.nodeRepository.GetChildren(node)
+	deleted, err := f.database.DeleteEmptyNode(node.GetEntity())
 	if err != nil {
 		return err
 	}
 
-	if len(children) > 0 {
+	if !deleted {
 		return syscall.ENOTEMPTY
 	}
 
-	err = f.database.DeleteNode(node.GetEntity())
+	_, err = f.database.DeleteQuota(string(QuotaDirectory), int64(id))
 	if err != nil {
 		return err
 	}
 
+	f.changed(node.GetParentId())
+
 	return nil
 }
 
 func (f *FileSystem) Touch(parentId uint64, name string) error {
+	return f.touch(parentId, name, nil)
+}
+
+// touch is Touch creating the file with attributes, in the same write as the
+// node so no one sees it without them
+func (f *FileSystem) touch(parentId uint64, name string, attributes map[string]string) error {
+	err := f.checkCreate(parentId)
+	if err != nil {
+		return err
+	}
+
+	if mount, local, ok := f.mounts.resolve(parentId); ok {
+		if len(attributes) > 0 {
+			return syscall.EROFS
+		}
+
+		return mount.touch(local, name)
+	}
+
 	parentNode, err := f.nodeRepository.Get(parentId)
 	if err != nil && err != sql.ErrNoRows {
 		return err
@@ -189,10 +637,52 @@ func (f *FileSystem) Touch(parentId uint64, name string) error {
 
 	path := getPath(parentNode, name)
 
-	return f.database.InsertNode(name, parentNode.GetEntity(), path, 0, 0, 0, 0, "", "")
+	mode, uid, gid := f.newNodeOwner(parentNode, 0)
+
+	mode, access, inherited, err := f.inheritACL(parentNode, mode)
+	if err != nil {
+		return err
+	}
+
+	err = f.chargeQuota(quotaCharge{path: path, uid: uid, gid: gid, nodes: 1})
+	if err != nil {
+		return err
+	}
+
+	err = f.database.InsertNodeWithAttributes(name, parentNode.GetEntity(), mode, uid, gid, attributes)
+	if err != nil {
+		return constraintError(err)
+	}
+
+	err = f.applyACL(parentNode, name, access, inherited)
+	if err != nil {
+		return err
+	}
+
+	f.changed(parentId)
+
+	return nil
 }
 
 func (f *FileSystem) WriteFile(id uint64, content []byte) (int, error) {
+	err := f.checkAccess(id, AccessWrite)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = f.checkFlags(id, FlagImmutable)
+	if err != nil {
+		return 0, err
+	}
+
+	if isGeneratedId(id) {
+		return 0, syscall.EROFS
+	}
+
+	if mount, local, ok := f.mounts.owner(id); ok {
+		return mount.writeFile(local, content)
+	}
+
 	node, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return 0, err
@@ -202,34 +692,62 @@ func (f *FileSystem) WriteFile(id uint64, content []byte) (int, error) {
 		return 0, syscall.ENOENT
 	}
 
-	if node.GetMode() != fs.FileMode(0) {
+	if !node.GetMode().IsRegular() {
 		return 0, fmt.Errorf("node %s is not a file", node.GetName())
 	}
 
-	nodeContent, err := f.nodeContentRepository.GetByNode(node)
-	if err != nil && err != sql.ErrNoRows {
+	provider, _, err := f.contentProvider(node)
+	if err != nil {
 		return 0, err
 	}
 
-	if nodeContent == nil {
-		err = f.database.InsertNodeContent(node.GetEntity(), content)
-		if err != nil {
-			return 0, err
-		}
-	} else {
-		nodeContent.SetContent(content)
+	if provider != nil {
+		return 0, syscall.EROFS
+	}
 
-		err = f.database.SaveNodeContent(nodeContent.GetEntity())
-		if err != nil {
-			return 0, err
-		}
+	err = f.chargeQuota(quotaCharge{
+		path:  node.GetPath(),
+		uid:   node.GetUid(),
+		gid:   node.GetGid(),
+		bytes: int64(len(content)) - node.GetSize(),
+	})
+	if err != nil {
+		return 0, err
 	}
 
+	// Append only files take new content as long as it keeps what they hold,
+	// checked along with the write
+	err = f.database.ReplaceNodeContent(node.GetEntity(), content, int64(FlagImmutable), int64(FlagAppend))
+	if err != nil {
+		return 0, constraintError(err)
+	}
+
+	err = f.index(node, content)
+	if err != nil {
+		return 0, err
+	}
+
+	f.changed(node.GetParentId())
+
 	return len(content), nil
 
 }
 
 func (f *FileSystem) ReadFile(id uint64) ([]byte, error) {
+	err := f.checkAccess(id, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+
+	if isGeneratedId(id) {
+		content, _, err := f.generate(id)
+		return content, err
+	}
+
+	if mount, local, ok := f.mounts.owner(id); ok {
+		return mount.readFile(local)
+	}
+
 	node, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
@@ -239,10 +757,19 @@ func (f *FileSystem) ReadFile(id uint64) ([]byte, error) {
 		return nil, syscall.ENOENT
 	}
 
-	if node.GetMode() != fs.FileMode(0) {
+	if !node.GetMode().IsRegular() {
 		return nil, fmt.Errorf("node %s is not a file", node.GetName())
 	}
 
+	provider, key, err := f.contentProvider(node)
+	if err != nil {
+		return nil, err
+	}
+
+	if provider != nil {
+		return readProvided(provider, key)
+	}
+
 	nodeContent, err := f.nodeContentRepository.GetByNode(node)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
@@ -255,7 +782,23 @@ func (f *FileSystem) ReadFile(id uint64) ([]byte, error) {
 	return nodeContent.GetContent(), nil
 }
 
+// RemoveFile removes the file id. One that is open, in this process or any
+// other, stays readable and writable through its handles until the last of
+// them is closed, only then is it deleted.
 func (f *FileSystem) RemoveFile(id uint64) error {
+	err := f.checkRemove(id)
+	if err != nil {
+		return err
+	}
+
+	if isGeneratedId(id) {
+		return syscall.EROFS
+	}
+
+	if mount, local, ok := f.mounts.owner(id); ok {
+		return mount.removeFile(local)
+	}
+
 	node, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return err
@@ -269,15 +812,54 @@ func (f *FileSystem) RemoveFile(id uint64) error {
 		return syscall.EISDIR
 	}
 
-	err = f.database.DeleteNode(node.GetEntity())
+	if node.GetMode()&fs.ModeSymlink != 0 {
+		symlinkEntity, err := f.database.GetSymlinkBySourceNode(node.GetEntity())
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if symlinkEntity != nil {
+			err = f.database.DeleteSymlink(symlinkEntity)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = f.unindex(node)
 	if err != nil {
 		return err
 	}
 
+	_, err = f.database.UnlinkNode(node.GetEntity(), time.Now().UnixMilli())
+	if err != nil {
+		return constraintError(err)
+	}
+
+	f.changed(node.GetParentId())
+
 	return nil
 }
 
 func (f *FileSystem) Move(id uint64, name string, newParentId uint64) error {
+	err := f.checkMove(id, newParentId)
+	if err != nil {
+		return err
+	}
+
+	if isGeneratedId(id) {
+		return syscall.EROFS
+	}
+
+	mount, local, localParent, err := f.mounts.pair(id, newParentId)
+	if err != nil {
+		return err
+	}
+
+	if mount != nil {
+		return mount.rename(local, name, localParent)
+	}
+
 	node, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return err
@@ -304,21 +886,52 @@ func (f *FileSystem) Move(id uint64, name string, newParentId uint64) error {
 		return syscall.ENOTDIR
 	}
 
-	path := getPath(parentNode, name)
+	if isWithin(parentNode, node) {
+		return syscall.EINVAL
+	}
 
-	node.SetName(name)
-	node.SetPath(path)
-	node.SetParentId(newParentId)
+	if f.mounts.below(node) {
+		return syscall.EBUSY
+	}
 
-	err = f.database.SaveNode(node.GetEntity())
+	oldParentId := node.GetParentId()
+	oldPath := node.GetPath()
+	path := getPath(parentNode, name)
+
+	err = f.chargeMove(oldPath, path)
 	if err != nil {
 		return err
 	}
 
+	err = f.database.MoveNode(int64(id), name, int64(newParentId))
+	if err != nil {
+		return constraintError(err)
+	}
+
+	f.changed(oldParentId, newParentId)
+
 	return nil
 }
 
 func (f *FileSystem) Rename(id uint64, newName string, newParentId uint64) error {
+	err := f.checkMove(id, newParentId)
+	if err != nil {
+		return err
+	}
+
+	if isGeneratedId(id) {
+		return syscall.EROFS
+	}
+
+	mount, local, localParent, err := f.mounts.pair(id, newParentId)
+	if err != nil {
+		return err
+	}
+
+	if mount != nil {
+		return mount.rename(local, newName, localParent)
+	}
+
 	node, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return err
@@ -341,21 +954,48 @@ func (f *FileSystem) Rename(id uint64, newName string, newParentId uint64) error
 		return syscall.ENOTDIR
 	}
 
-	path := getPath(parentNode, newName)
+	if isWithin(parentNode, node) {
+		return syscall.EINVAL
+	}
 
-	node.SetName(newName)
-	node.SetPath(path)
-	node.SetParentId(newParentId)
+	if f.mounts.below(node) {
+		return syscall.EBUSY
+	}
 
-	err = f.database.SaveNode(node.GetEntity())
+	oldParentId := node.GetParentId()
+	oldPath := node.GetPath()
+	path := getPath(parentNode, newName)
+
+	err = f.chargeMove(oldPath, path)
 	if err != nil {
 		return err
 	}
 
+	err = f.database.MoveNode(int64(id), newName, int64(newParentId))
+	if err != nil {
+		return constraintError(err)
+	}
+
+	f.changed(oldParentId, newParentId)
+
 	return nil
 }
 
 func (f *FileSystem) Link(id uint64, name string, parentId uint64) error {
+	err := f.checkCreate(parentId)
+	if err != nil {
+		return err
+	}
+
+	mount, local, localParent, err := f.mounts.pair(id, parentId)
+	if err != nil {
+		return err
+	}
+
+	if mount != nil {
+		return mount.link(local, name, localParent)
+	}
+
 	node, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return err
@@ -384,24 +1024,33 @@ func (f *FileSystem) Link(id uint64, name string, parentId uint64) error {
 
 	path := getPath(parentNode, name)
 
-	err = f.database.InsertNode(name, parentNode.GetEntity(), path, uint32(fs.ModeSymlink), 0, 0, 0, "", "")
+	mode, uid, gid := f.newNodeOwner(parentNode, fs.ModeSymlink)
+
+	err = f.chargeQuota(quotaCharge{path: path, uid: uid, gid: gid, nodes: 1})
 	if err != nil {
 		return err
 	}
 
-	sourceNode, err := f.nodeRepository.GetByParentAndName(parentNode, name)
-	if err != nil && err != sql.ErrNoRows {
-		return err
+	err = f.database.InsertSymlinkNode(name, parentNode.GetEntity(), node.GetEntity(), mode, uid, gid)
+	if err != nil {
+		return constraintError(err)
 	}
 
-	if sourceNode == nil {
-		return syscall.ENOENT
-	}
+	f.changed(parentId)
 
-	return f.database.InsertSymlink(sourceNode.GetEntity(), node.GetEntity())
+	return nil
 }
 
 func (f *FileSystem) ReadLink(id uint64) (string, error) {
+	err := f.checkAccess(id, AccessExists)
+	if err != nil {
+		return "", err
+	}
+
+	if mount, local, ok := f.mounts.owner(id); ok {
+		return mount.readLink(local)
+	}
+
 	node, err := f.nodeRepository.Get(id)
 	if err != nil && err != sql.ErrNoRows {
 		return "", err
@@ -411,7 +1060,7 @@ func (f *FileSystem) ReadLink(id uint64) (string, error) {
 		return "", syscall.ENOENT
 	}
 
-	if node.GetMode() != fs.ModeSymlink {
+	if node.GetMode()&fs.ModeSymlink == 0 {
 		return "", syscall.EINVAL
 	}
 
@@ -442,7 +1091,20 @@ func (f *FileSystem) ReadLink(id uint64) (string, error) {
 }
 
 func (f *FileSystem) Save(node interfaces.Node) error {
-	err := f.database.SaveNode(node.GetEntity())
+	err := f.checkSave(node)
+	if err != nil {
+		return err
+	}
+
+	if isGeneratedId(node.GetId()) {
+		return syscall.EROFS
+	}
+
+	if mount, local, ok := f.mounts.owner(node.GetId()); ok {
+		return mount.save(local, node)
+	}
+
+	err = f.database.SaveNode(node.GetEntity())
 	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
@@ -451,5 +1113,24 @@ func (f *FileSystem) Save(node interfaces.Node) error {
 		return syscall.ENOENT
 	}
 
+	f.changed(node.GetParentId())
+
 	return nil
 }
+
+func (f *FileSystem) Close() error {
+	err := f.session.close()
+	if err != nil {
+		f.namespaces.closeMounts()
+		f.database.Close()
+		return err
+	}
+
+	err = f.namespaces.closeMounts()
+	if err != nil {
+		f.database.Close()
+		return err
+	}
+
+	return f.database.Close()
+}
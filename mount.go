@@ -0,0 +1,751 @@
+package filesystem
+
+import (
+	"fmt"
+	"io"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/archivefs"
+	"github.com/sushydev/vfs_go/interfaces"
+	database_node "github.com/sushydev/vfs_go/internal/database/node"
+	"github.com/sushydev/vfs_go/internal/filesystem/node"
+)
+
+// Ids of mounted entries carry the mount flag, the mount slot and the id the
+// mounted file system uses itself: 1<<63 | slot<<IdBits | local. Local ids
+// that do not fit in IdBits are handed out an index instead, those ids carry
+// the mapped flag as well and only stay the same while the mount is attached.
+const (
+	mountIdFlag   = 1 << 63
+	mountIdMapped = 1 << 62
+	mountIdShift  = archivefs.IdBits
+	mountIdLocal  = 1<<mountIdShift - 1
+	mountIdSlots  = 1<<(62-mountIdShift) - 1
+)
+
+// Mount kinds that can be persisted out of the box
+const (
+	// MountArchive sources are "host:<path>" or "node:<id>" of a zip or tar archive
+	MountArchive = "archive"
+	// MountVFS sources are the host path of another VFS database
+	MountVFS = "vfs"
+)
+
+// MountOpener opens the file system a persisted mount refers to, it is given
+// the VFS the mount lives in so sources can refer to nodes of it. File systems
+// that implement io.Closer are closed when they are unmounted.
+type MountOpener func(f *FileSystem, source string) (interfaces.FileSystem, error)
+
+var mountKinds = struct {
+	sync.RWMutex
+	openers map[string]MountOpener
+}{
+	openers: make(map[string]MountOpener),
+}
+
+func init() {
+	RegisterMountKind(MountArchive, openArchiveMount)
+	RegisterMountKind(MountVFS, openVFSMount)
+}
+
+// RegisterMountKind makes a kind of mount available to MountSource and to
+// the mounts restored when a VFS is opened
+func RegisterMountKind(kind string, opener MountOpener) {
+	mountKinds.Lock()
+	defer mountKinds.Unlock()
+
+	mountKinds.openers[kind] = opener
+}
+
+// Operations a mounted file system may support beyond interfaces.FileSystem,
+// anything it lacks is reported as EROFS
+type (
+	mountRoot interface {
+		Root() (interfaces.Node, error)
+	}
+	mountFileReader interface {
+		ReadFile(id uint64) ([]byte, error)
+	}
+	mountLinkReader interface {
+		ReadLink(id uint64) (string, error)
+	}
+	mountToucher interface {
+		Touch(parentId uint64, name string) error
+	}
+	mountFileWriter interface {
+		WriteFile(id uint64, content []byte) (int, error)
+	}
+	mountDirRemover  interface{ RmDir(id uint64) error }
+	mountFileRemover interface{ RemoveFile(id uint64) error }
+	mountRenamer     interface {
+		Rename(id uint64, newName string, newParentId uint64) error
+	}
+	mountLinker interface {
+		Link(id uint64, name string, parentId uint64) error
+	}
+	mountSaver interface {
+		Save(node interfaces.Node) error
+	}
+)
+
+type mount struct {
+	slot       uint64
+	point      interfaces.Node
+	fileSystem interfaces.FileSystem
+	root       uint64
+	// closer is set when the mount opened the file system itself
+	closer io.Closer
+
+	mutex  sync.Mutex
+	mapped map[uint64]uint64
+	locals []uint64
+}
+
+type mountTable struct {
+	mutex  sync.RWMutex
+	byNode map[uint64]*mount
+	bySlot map[uint64]*mount
+	// next is the slot handed to the next mount that is not persisted, those
+	// count down from the top so they stay clear of the mounts table row ids
+	next uint64
+}
+
+func newMountTable() *mountTable {
+	return &mountTable{
+		byNode: make(map[uint64]*mount),
+		bySlot: make(map[uint64]*mount),
+		next:   mountIdSlots,
+	}
+}
+
+func isMountedId(id uint64) bool {
+	return id&mountIdFlag != 0
+}
+
+// resolve finds the mount an id belongs to together with the id local to it,
+// mount points resolve to the root of what is mounted on them
+func (t *mountTable) resolve(id uint64) (*mount, uint64, bool) {
+	t.mutex.RLock()
+	mount, ok := t.byNode[id]
+	t.mutex.RUnlock()
+
+	if ok {
+		return mount, mount.root, true
+	}
+
+	return t.owner(id)
+}
+
+// owner finds the mount an id lives in, mount points belong to the outer tree
+func (t *mountTable) owner(id uint64) (*mount, uint64, bool) {
+	if !isMountedId(id) {
+		return nil, 0, false
+	}
+
+	t.mutex.RLock()
+	mount, ok := t.bySlot[(id&^(mountIdFlag|mountIdMapped))>>mountIdShift]
+	t.mutex.RUnlock()
+
+	if !ok {
+		return nil, 0, false
+	}
+
+	local, ok := mount.localId(id)
+	if !ok {
+		return nil, 0, false
+	}
+
+	return mount, local, true
+}
+
+func (t *mountTable) isPoint(id uint64) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	_, ok := t.byNode[id]
+
+	return ok
+}
+
+// below reports whether a mount point is node or lies somewhere beneath it,
+// mount points keep the path they were attached at so those may not move
+func (t *mountTable) below(node interfaces.Node) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, mount := range t.byNode {
+		if isWithin(mount.point, node) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// byPath finds the mount whose mount point is path or one of its ancestors
+func (t *mountTable) byPath(path string) (*mount, string, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, mount := range t.byNode {
+		point := mount.point.GetPath()
+
+		if path == point {
+			return mount, "", true
+		}
+
+		if strings.HasPrefix(path, point+"/") {
+			return mount, path[len(point)+1:], true
+		}
+	}
+
+	return nil, "", false
+}
+
+func (t *mountTable) add(mount *mount) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if mount.slot == 0 {
+		for t.bySlot[t.next] != nil {
+			t.next--
+		}
+
+		mount.slot = t.next
+		t.next--
+	}
+
+	if mount.slot > mountIdSlots || t.bySlot[mount.slot] != nil {
+		return syscall.EOVERFLOW
+	}
+
+	t.byNode[mount.point.GetId()] = mount
+	t.bySlot[mount.slot] = mount
+
+	return nil
+}
+
+func (t *mountTable) remove(id uint64) (*mount, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	mount, ok := t.byNode[id]
+	if ok {
+		delete(t.byNode, id)
+		delete(t.bySlot, mount.slot)
+	}
+
+	return mount, ok
+}
+
+func (m *mount) globalId(local uint64) uint64 {
+	if local == m.root {
+		return m.point.GetId()
+	}
+
+	if local <= mountIdLocal {
+		return mountIdFlag | m.slot<<mountIdShift | local
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	index, ok := m.mapped[local]
+	if !ok {
+		if m.mapped == nil {
+			m.mapped = make(map[uint64]uint64)
+		}
+
+		m.locals = append(m.locals, local)
+		index = uint64(len(m.locals))
+		m.mapped[local] = index
+	}
+
+	return mountIdFlag | mountIdMapped | m.slot<<mountIdShift | index
+}
+
+func (m *mount) localId(global uint64) (uint64, bool) {
+	if global&mountIdMapped == 0 {
+		return global & mountIdLocal, true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	index := global & mountIdLocal
+	if index == 0 || index > uint64(len(m.locals)) {
+		return 0, false
+	}
+
+	return m.locals[index-1], true
+}
+
+// globalPath places a path of the mounted file system below the mount point
+func (m *mount) globalPath(local string) string {
+	return gopath.Join(m.point.GetPath(), local)
+}
+
+// translate presents a node of the mounted file system with ids and path of the VFS
+func (m *mount) translate(local interfaces.Node) (interfaces.Node, error) {
+	if local.GetId() == m.root {
+		return m.point, nil
+	}
+
+	entity, err := database_node.New(
+		int64(m.globalId(local.GetId())),
+		local.GetName(),
+		int64(m.globalId(local.GetParentId())),
+		m.globalPath(local.GetPath()),
+		int64(uint32(local.GetMode())),
+		local.GetUid(),
+		local.GetGid(),
+		local.GetModTime(),
+		local.GetCreateTime(),
+		local.GetAccessTime(),
+		local.GetSize(),
+		int64(local.GetFlags()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return node.New(entity)
+}
+
+func (m *mount) translateAll(locals []interfaces.Node) ([]interfaces.Node, error) {
+	nodes := make([]interfaces.Node, 0, len(locals))
+	for _, local := range locals {
+		node, err := m.translate(local)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// lookupPath walks the part of a path that lies inside the mount
+func (m *mount) lookupPath(rest string) (interfaces.Node, error) {
+	current := m.root
+	local := interfaces.Node(nil)
+
+	for _, name := range splitPath(rest) {
+		next, err := m.fileSystem.Lookup(current, name)
+		if err != nil {
+			return nil, err
+		}
+
+		current, local = next.GetId(), next
+	}
+
+	if local == nil {
+		return m.point, nil
+	}
+
+	return m.translate(local)
+}
+
+// save copies the changes made to a translated node onto the node of the mounted file system
+func (m *mount) save(local uint64, changed interfaces.Node) error {
+	saver, ok := m.fileSystem.(mountSaver)
+	if !ok {
+		return syscall.EROFS
+	}
+
+	node, err := m.fileSystem.Open(local)
+	if err != nil {
+		return err
+	}
+
+	node.SetMode(uint32(changed.GetMode()))
+	node.SetUid(changed.GetUid())
+	node.SetGid(changed.GetGid())
+	node.SetModTime(changed.GetModTime())
+	node.SetCreateTime(changed.GetCreateTime())
+	node.SetAccessTime(changed.GetAccessTime())
+
+	return saver.Save(node)
+}
+
+// Mount grafts fileSystem onto a directory named name below parentId, which
+// is created when it does not exist yet. The mount lasts until Unmount or
+// Close and the caller stays responsible for closing fileSystem. Directories
+// holding a mount point cannot be moved or renamed while it is mounted.
+func (f *FileSystem) Mount(parentId uint64, name string, fileSystem interfaces.FileSystem) error {
+	return f.attach(parentId, name, &mount{fileSystem: fileSystem}, "", "")
+}
+
+// MountSource mounts what the opener registered for kind makes of source and
+// records it so it is mounted again whenever the VFS is opened
+func (f *FileSystem) MountSource(parentId uint64, name string, kind string, source string) error {
+	err := f.requireRoot()
+	if err != nil {
+		return err
+	}
+
+	fileSystem, err := openMountSource(f, kind, source)
+	if err != nil {
+		return err
+	}
+
+	closer, _ := fileSystem.(io.Closer)
+
+	err = f.attach(parentId, name, &mount{fileSystem: fileSystem, closer: closer}, kind, source)
+	if err != nil && closer != nil {
+		closer.Close()
+	}
+
+	return err
+}
+
+// MountArchive attaches a zip or tar archive on the host as a read-only
+// directory named name below parentId. The mount is restored on reopen.
+func (f *FileSystem) MountArchive(parentId uint64, name string, hostPath string) error {
+	hostPath, err := filepath.Abs(hostPath)
+	if err != nil {
+		return err
+	}
+
+	return f.MountSource(parentId, name, MountArchive, "host:"+hostPath)
+}
+
+// MountArchiveNode attaches an archive stored in the VFS itself, like MountArchive
+func (f *FileSystem) MountArchiveNode(parentId uint64, name string, archiveId uint64) error {
+	return f.MountSource(parentId, name, MountArchive, "node:"+strconv.FormatUint(archiveId, 10))
+}
+
+func (f *FileSystem) attach(parentId uint64, name string, mount *mount, kind string, source string) error {
+	err := f.requireWritable()
+	if err != nil {
+		return err
+	}
+
+	err = f.requireRoot()
+	if err != nil {
+		return err
+	}
+
+	if _, _, ok := f.mounts.resolve(parentId); ok {
+		return syscall.ENOTSUP
+	}
+
+	point, _, err := f.LookupOrMkDir(parentId, name)
+	if err != nil {
+		return err
+	}
+
+	if !point.GetMode().IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	if f.mounts.isPoint(point.GetId()) {
+		return syscall.EBUSY
+	}
+
+	children, err := f.nodeRepository.GetChildren(point)
+	if err != nil {
+		return err
+	}
+
+	if len(children) > 0 {
+		return syscall.ENOTEMPTY
+	}
+
+	mount.point = point
+
+	if rooted, ok := mount.fileSystem.(mountRoot); ok {
+		root, err := rooted.Root()
+		if err != nil {
+			return err
+		}
+
+		mount.root = root.GetId()
+	}
+
+	if kind != "" {
+		slot, err := f.database.InsertMount(point.GetEntity(), kind, source)
+		if err != nil {
+			return err
+		}
+
+		mount.slot = uint64(slot)
+	}
+
+	err = f.mounts.add(mount)
+	if err != nil && kind != "" {
+		f.database.DeleteMount(int64(mount.slot))
+	}
+
+	return err
+}
+
+// Unmount detaches whatever is mounted on the mount point id, the now empty
+// mount point directory is left in place
+func (f *FileSystem) Unmount(id uint64) error {
+	err := f.requireWritable()
+	if err != nil {
+		return err
+	}
+
+	err = f.requireRoot()
+	if err != nil {
+		return err
+	}
+
+	mount, ok := f.mounts.remove(id)
+	if !ok {
+		return syscall.EINVAL
+	}
+
+	err = f.database.DeleteMount(int64(mount.slot))
+	if err != nil {
+		return err
+	}
+
+	if mount.closer != nil {
+		return mount.closer.Close()
+	}
+
+	return nil
+}
+
+// restoreMounts reattaches the mounts recorded in the database, a source that
+// can no longer be opened is skipped so the VFS itself still opens
+func (f *FileSystem) restoreMounts() error {
+	entities, err := f.database.GetMounts()
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range entities {
+		point, err := f.getNode(uint64(entity.GetNodeId()))
+		if err != nil {
+			continue
+		}
+
+		fileSystem, err := openMountSource(f, entity.GetKind(), entity.GetSource())
+		if err != nil {
+			continue
+		}
+
+		closer, _ := fileSystem.(io.Closer)
+		mount := &mount{slot: uint64(entity.GetId()), point: point, fileSystem: fileSystem, closer: closer}
+
+		if rooted, ok := fileSystem.(mountRoot); ok {
+			root, err := rooted.Root()
+			if err == nil {
+				mount.root = root.GetId()
+			}
+		}
+
+		err = f.mounts.add(mount)
+		if err != nil && closer != nil {
+			closer.Close()
+		}
+	}
+
+	return nil
+}
+
+func (f *FileSystem) closeMounts() error {
+	f.mounts.mutex.Lock()
+	defer f.mounts.mutex.Unlock()
+
+	var err error
+	for id, mount := range f.mounts.byNode {
+		if mount.closer != nil {
+			closeErr := mount.closer.Close()
+			if err == nil && closeErr != nil {
+				err = closeErr
+			}
+		}
+
+		delete(f.mounts.byNode, id)
+		delete(f.mounts.bySlot, mount.slot)
+	}
+
+	return err
+}
+
+func openMountSource(f *FileSystem, kind string, source string) (interfaces.FileSystem, error) {
+	mountKinds.RLock()
+	opener, ok := mountKinds.openers[kind]
+	mountKinds.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown mount kind %q", kind)
+	}
+
+	return opener(f, source)
+}
+
+// archiveMount closes whatever the archive is read from along with the mount
+type archiveMount struct {
+	*archivefs.FileSystem
+	io.Closer
+}
+
+func openArchiveMount(f *FileSystem, source string) (interfaces.FileSystem, error) {
+	var reader io.ReaderAt
+	var closer io.Closer
+	var size int64
+
+	switch {
+	case strings.HasPrefix(source, "host:"):
+		file, err := os.Open(strings.TrimPrefix(source, "host:"))
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		reader, closer, size = file, file, info.Size()
+	case strings.HasPrefix(source, "node:"):
+		id, err := strconv.ParseUint(strings.TrimPrefix(source, "node:"), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		handle, err := f.OpenFile(id)
+		if err != nil {
+			return nil, err
+		}
+
+		size, err = handle.Size()
+		if err != nil {
+			handle.Close()
+			return nil, err
+		}
+
+		reader, closer = handle, handle
+	default:
+		return nil, fmt.Errorf("unknown archive source %q", source)
+	}
+
+	fileSystem, err := archivefs.New(reader, size)
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+
+	return &archiveMount{FileSystem: fileSystem, Closer: closer}, nil
+}
+
+func openVFSMount(f *FileSystem, source string) (interfaces.FileSystem, error) {
+	return New(source)
+}
+
+// pair resolves an entry together with the directory it is placed in, both
+// have to live in the same file system. The mount is nil when neither is mounted.
+func (t *mountTable) pair(id uint64, parentId uint64) (*mount, uint64, uint64, error) {
+	if t.isPoint(id) {
+		return nil, 0, 0, syscall.EBUSY
+	}
+
+	mount, local, ok := t.owner(id)
+	parentMount, localParent, parentOk := t.resolve(parentId)
+
+	if !ok && !parentOk {
+		return nil, 0, 0, nil
+	}
+
+	if mount != parentMount {
+		return nil, 0, 0, syscall.EXDEV
+	}
+
+	return mount, local, localParent, nil
+}
+
+func (m *mount) mkDir(parentId uint64, name string) error {
+	return m.fileSystem.MkDir(parentId, name)
+}
+
+func (m *mount) rmDir(id uint64) error {
+	remover, ok := m.fileSystem.(mountDirRemover)
+	if !ok {
+		return syscall.EROFS
+	}
+
+	return remover.RmDir(id)
+}
+
+func (m *mount) touch(parentId uint64, name string) error {
+	toucher, ok := m.fileSystem.(mountToucher)
+	if !ok {
+		return syscall.EROFS
+	}
+
+	return toucher.Touch(parentId, name)
+}
+
+func (m *mount) writeFile(id uint64, content []byte) (int, error) {
+	writer, ok := m.fileSystem.(mountFileWriter)
+	if !ok {
+		return 0, syscall.EROFS
+	}
+
+	return writer.WriteFile(id, content)
+}
+
+func (m *mount) readFile(id uint64) ([]byte, error) {
+	reader, ok := m.fileSystem.(mountFileReader)
+	if !ok {
+		return nil, syscall.ENOTSUP
+	}
+
+	return reader.ReadFile(id)
+}
+
+func (m *mount) removeFile(id uint64) error {
+	remover, ok := m.fileSystem.(mountFileRemover)
+	if !ok {
+		return syscall.EROFS
+	}
+
+	return remover.RemoveFile(id)
+}
+
+func (m *mount) rename(id uint64, newName string, newParentId uint64) error {
+	renamer, ok := m.fileSystem.(mountRenamer)
+	if !ok {
+		return syscall.EROFS
+	}
+
+	return renamer.Rename(id, newName, newParentId)
+}
+
+func (m *mount) link(id uint64, name string, parentId uint64) error {
+	linker, ok := m.fileSystem.(mountLinker)
+	if !ok {
+		return syscall.EROFS
+	}
+
+	return linker.Link(id, name, parentId)
+}
+
+// readLink returns the target as a path of the VFS
+func (m *mount) readLink(id uint64) (string, error) {
+	reader, ok := m.fileSystem.(mountLinkReader)
+	if !ok {
+		return "", syscall.EINVAL
+	}
+
+	target, err := reader.ReadLink(id)
+	if err != nil {
+		return "", err
+	}
+
+	return m.globalPath(target), nil
+}
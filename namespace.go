@@ -0,0 +1,167 @@
+package filesystem
+
+import (
+	"database/sql"
+	"sync"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/internal/database"
+	database_interfaces "github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+// DefaultNamespace is the tree New opens, databases made before namespaces
+// existed hold just this one
+const DefaultNamespace = database.DefaultNamespace
+
+// namespaceTable keeps one view per namespace so mounts, generators and
+// quotas of a namespace are shared by everyone who opens it
+type namespaceTable struct {
+	mutex sync.Mutex
+	views map[string]*FileSystem
+}
+
+func newNamespaceTable() *namespaceTable {
+	return &namespaceTable{
+		views: make(map[string]*FileSystem),
+	}
+}
+
+// Namespace returns the VFS of the namespace name, creating it with an empty
+// root directory when it does not exist yet. Each namespace is a tree of its
+// own: paths start at its root and ids of nodes in other namespaces are not
+// found. Mounts, generators and quotas are kept per namespace, providers and
+// the search index are shared by all of them.
+//
+// The returned VFS does not check credentials, use As on it for that.
+func (f *FileSystem) Namespace(name string) (*FileSystem, error) {
+	err := f.requireRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		return nil, syscall.EINVAL
+	}
+
+	f.namespaces.mutex.Lock()
+	defer f.namespaces.mutex.Unlock()
+
+	if view, ok := f.namespaces.views[name]; ok {
+		return view, nil
+	}
+
+	entity, err := f.database.GetNamespace(name)
+	if err == sql.ErrNoRows {
+		err = f.requireWritable()
+		if err == nil {
+			entity, err = f.database.CreateNamespace(name)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	view, err := f.namespaceView(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	f.namespaces.views[name] = view
+
+	return view, nil
+}
+
+// Namespaces lists the names of all namespaces
+func (f *FileSystem) Namespaces() ([]string, error) {
+	entities, err := f.database.GetNamespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entities))
+	for _, entity := range entities {
+		names = append(names, entity.GetName())
+	}
+
+	return names, nil
+}
+
+// DeleteNamespace removes the namespace name with everything in it. Its
+// mounts are closed, views of it that are still around find nothing after.
+// The default namespace cannot be removed.
+func (f *FileSystem) DeleteNamespace(name string) error {
+	err := f.requireWritable()
+	if err != nil {
+		return err
+	}
+
+	err = f.requireRoot()
+	if err != nil {
+		return err
+	}
+
+	if name == DefaultNamespace {
+		return syscall.EBUSY
+	}
+
+	f.namespaces.mutex.Lock()
+	defer f.namespaces.mutex.Unlock()
+
+	entity, err := f.database.GetNamespace(name)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if entity == nil {
+		return syscall.ENOENT
+	}
+
+	if view, ok := f.namespaces.views[name]; ok {
+		err = view.closeMounts()
+		if err != nil {
+			return err
+		}
+
+		delete(f.namespaces.views, name)
+	}
+
+	return f.database.DeleteNamespace(entity.GetId())
+}
+
+// namespaceView opens the namespace entity, sharing what is not kept per
+// namespace with f
+func (f *FileSystem) namespaceView(entity database_interfaces.Namespace) (*FileSystem, error) {
+	view := &FileSystem{
+		readOnly:   f.readOnly,
+		providers:  f.providers,
+		search:     f.search,
+		session:    f.session,
+		namespaces: f.namespaces,
+	}
+
+	view.scope(f.database.InNamespace(entity.GetId()), entity.GetName(), uint64(entity.GetRootId()))
+
+	err := view.restoreMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	return view, nil
+}
+
+// closeMounts closes the mounts of every namespace opened so far
+func (t *namespaceTable) closeMounts() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var err error
+	for _, view := range t.views {
+		closeErr := view.closeMounts()
+		if err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,194 @@
+package overlay
+
+import (
+	"syscall"
+
+	filesystem "github.com/sushydev/vfs_go"
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+type commitSymlink struct {
+	parent interfaces.Node
+	name   string
+	target string
+	source interfaces.Node
+}
+
+// Commit applies the upper layer to the lower one and empties the upper layer,
+// the merged view reads the same before and after
+func (f *FileSystem) Commit() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	upperRoot, err := f.upper.Root()
+	if err != nil {
+		return err
+	}
+
+	lowerRoot, err := f.lower.Root()
+	if err != nil {
+		return err
+	}
+
+	var symlinks []commitSymlink
+
+	err = f.commitDir(upperRoot, lowerRoot, &symlinks)
+	if err != nil {
+		return err
+	}
+
+	// Links are made last so they can point at anything the commit created
+	for _, symlink := range symlinks {
+		target, err := f.lower.LookupPath(symlink.target)
+		if err == syscall.ENOENT {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = f.lower.Link(target.GetId(), symlink.name, symlink.parent.GetId())
+		if err != nil {
+			return err
+		}
+
+		node, err := f.lower.Lookup(symlink.parent.GetId(), symlink.name)
+		if err != nil {
+			return err
+		}
+
+		err = copyMetadata(f.upper, symlink.source, f.lower, node)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = copyMetadata(f.upper, upperRoot, f.lower, lowerRoot)
+	if err != nil {
+		return err
+	}
+
+	children, err := f.upper.ReadDir(upperRoot.GetId())
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		err = removeTree(f.upper, child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *FileSystem) commitDir(upperDir interfaces.Node, lowerDir interfaces.Node, symlinks *[]commitSymlink) error {
+	if f.isOpaque(upperDir) {
+		children, err := f.lower.ReadDir(lowerDir.GetId())
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			err = removeTree(f.lower, child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	children, err := f.upper.ReadDir(upperDir.GetId())
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		name := child.GetName()
+
+		existing, err := f.lower.Lookup(lowerDir.GetId(), name)
+		switch err {
+		case nil:
+		case syscall.ENOENT:
+			existing = nil
+		default:
+			return err
+		}
+
+		if f.isWhiteout(child) {
+			if existing != nil {
+				err = removeTree(f.lower, existing)
+				if err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		// Entries of a different type, and symlinks which are always relinked, replace the lower entry
+		if existing != nil && (isSymlink(child) || existing.GetMode().Type() != child.GetMode().Type()) {
+			err = removeTree(f.lower, existing)
+			if err != nil {
+				return err
+			}
+
+			existing = nil
+		}
+
+		if isSymlink(child) {
+			target, err := f.upper.ReadLink(child.GetId())
+			if err == syscall.ENOENT {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			*symlinks = append(*symlinks, commitSymlink{parent: lowerDir, name: name, target: target, source: child})
+			continue
+		}
+
+		if existing == nil {
+			existing, err = create(f.lower, lowerDir, name, child)
+			if err != nil {
+				return err
+			}
+		}
+
+		if child.GetMode().IsDir() {
+			err = f.commitDir(child, existing, symlinks)
+		} else {
+			err = copyContent(f.upper, child, f.lower, existing)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = copyMetadata(f.upper, child, f.lower, existing)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// create makes an empty directory or file like source below parent
+func create(layer *filesystem.FileSystem, parent interfaces.Node, name string, source interfaces.Node) (interfaces.Node, error) {
+	var err error
+	if source.GetMode().IsDir() {
+		err = layer.MkDir(parent.GetId(), name)
+	} else {
+		err = layer.Touch(parent.GetId(), name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return layer.Lookup(parent.GetId(), name)
+}
@@ -0,0 +1,407 @@
+// Package overlay layers a writable VFS over a read-only one. Reads see the
+// upper layer where it has an entry and the lower layer everywhere else,
+// the first write to a lower entry copies it up. Deletions of lower entries
+// are recorded in the upper layer as whiteouts, and directories that replace
+// a deleted lower directory are marked opaque so its old contents stay hidden.
+package overlay
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	filesystem "github.com/sushydev/vfs_go"
+	"github.com/sushydev/vfs_go/interfaces"
+	database_node "github.com/sushydev/vfs_go/internal/database/node"
+	"github.com/sushydev/vfs_go/internal/filesystem/node"
+)
+
+// Extended attributes the upper layer uses for its bookkeeping, they are
+// hidden from the merged view and cannot be set through it
+const (
+	WhiteoutAttribute = "trusted.overlay.whiteout"
+	OpaqueAttribute   = "trusted.overlay.opaque"
+
+	attributePrefix = "trusted.overlay."
+)
+
+// Ids of entries served from the lower layer carry this bit, upper ids are used as they are
+const lowerFlag = 1 << 62
+
+type FileSystem struct {
+	mutex sync.Mutex
+	lower *filesystem.FileSystem
+	upper *filesystem.FileSystem
+}
+
+var _ interfaces.FileSystem = &FileSystem{}
+
+// New merges upper over lower, lower is only ever written to by Commit
+func New(lower *filesystem.FileSystem, upper *filesystem.FileSystem) *FileSystem {
+	return &FileSystem{
+		lower: lower,
+		upper: upper,
+	}
+}
+
+// entry is a path of the merged view together with what each layer has there
+type entry struct {
+	path  string
+	upper interfaces.Node
+	// lower is nil when the upper layer hides it
+	lower interfaces.Node
+	// inLower is set when the lower layer has the path, even where the upper layer hides it
+	inLower bool
+}
+
+func (e *entry) node() interfaces.Node {
+	if e.upper != nil {
+		return e.upper
+	}
+
+	return e.lower
+}
+
+func (e *entry) id() uint64 {
+	if e.upper != nil {
+		return e.upper.GetId()
+	}
+
+	return e.lower.GetId() | lowerFlag
+}
+
+func (f *FileSystem) isWhiteout(upper interfaces.Node) bool {
+	_, err := f.upper.GetXattr(upper.GetId(), WhiteoutAttribute)
+
+	return err == nil
+}
+
+func (f *FileSystem) isOpaque(upper interfaces.Node) bool {
+	if upper == nil {
+		return false
+	}
+
+	_, err := f.upper.GetXattr(upper.GetId(), OpaqueAttribute)
+
+	return err == nil
+}
+
+// lookup resolves a path of the merged view one component at a time
+func (f *FileSystem) lookup(name string) (*entry, error) {
+	upperRoot, err := f.upper.Root()
+	if err != nil {
+		return nil, err
+	}
+
+	lowerRoot, err := f.lower.Root()
+	if err != nil {
+		return nil, err
+	}
+
+	current := &entry{path: "/", upper: upperRoot, lower: lowerRoot, inLower: true}
+
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+
+		if !current.node().GetMode().IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+
+		next := &entry{path: path.Join(current.path, part)}
+
+		if current.upper != nil {
+			upper, err := f.upper.Lookup(current.upper.GetId(), part)
+			switch err {
+			case nil:
+				if f.isWhiteout(upper) {
+					return nil, syscall.ENOENT
+				}
+
+				next.upper = upper
+			case syscall.ENOENT:
+			default:
+				return nil, err
+			}
+		}
+
+		if current.lower != nil && !f.isOpaque(current.upper) {
+			lower, err := f.lower.Lookup(current.lower.GetId(), part)
+			switch err {
+			case nil:
+				next.lower, next.inLower = lower, true
+			case syscall.ENOENT:
+			default:
+				return nil, err
+			}
+		}
+
+		if next.upper == nil && next.lower == nil {
+			return nil, syscall.ENOENT
+		}
+
+		// Only directories merge, anything else in the upper layer replaces the lower entry
+		if next.upper != nil && next.lower != nil && !(next.upper.GetMode().IsDir() && next.lower.GetMode().IsDir()) {
+			next.lower = nil
+		}
+
+		current = next
+	}
+
+	return current, nil
+}
+
+func (f *FileSystem) byId(id uint64) (*entry, error) {
+	var layerNode interfaces.Node
+	var err error
+
+	if id&lowerFlag != 0 {
+		layerNode, err = f.lower.Open(id &^ lowerFlag)
+	} else {
+		layerNode, err = f.upper.Open(id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return f.lookup(layerNode.GetPath())
+}
+
+// present hands out a node of either layer with the id and parent id of the merged view
+func (f *FileSystem) present(e *entry, parentId uint64) (interfaces.Node, error) {
+	layerNode := e.node()
+
+	entity, err := database_node.New(
+		int64(e.id()),
+		layerNode.GetName(),
+		int64(parentId),
+		e.path,
+		int64(uint32(layerNode.GetMode())),
+		layerNode.GetUid(),
+		layerNode.GetGid(),
+		layerNode.GetModTime(),
+		layerNode.GetCreateTime(),
+		layerNode.GetAccessTime(),
+		layerNode.GetSize(),
+		int64(layerNode.GetFlags()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return node.New(entity)
+}
+
+func (f *FileSystem) parentId(e *entry) (uint64, error) {
+	if e.path == "/" {
+		return e.node().GetParentId(), nil
+	}
+
+	parent, err := f.lookup(path.Dir(e.path))
+	if err != nil {
+		return 0, err
+	}
+
+	return parent.id(), nil
+}
+
+func (f *FileSystem) Root() (interfaces.Node, error) {
+	return f.Open(0)
+}
+
+func (f *FileSystem) Open(id uint64) (interfaces.Node, error) {
+	e, err := f.byId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	parentId, err := f.parentId(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.present(e, parentId)
+}
+
+// LookupPath finds an entry of the merged view by its absolute path
+func (f *FileSystem) LookupPath(name string) (interfaces.Node, error) {
+	e, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	parentId, err := f.parentId(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.present(e, parentId)
+}
+
+func (f *FileSystem) ReadDir(parentId uint64) ([]interfaces.Node, error) {
+	parent, err := f.byId(parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !parent.node().GetMode().IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+
+	seen := make(map[string]bool)
+	var entries []*entry
+
+	if parent.upper != nil {
+		children, err := f.upper.ReadDir(parent.upper.GetId())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, child := range children {
+			seen[child.GetName()] = true
+
+			if f.isWhiteout(child) {
+				continue
+			}
+
+			entries = append(entries, &entry{path: path.Join(parent.path, child.GetName()), upper: child})
+		}
+	}
+
+	if parent.lower != nil && !f.isOpaque(parent.upper) {
+		children, err := f.lower.ReadDir(parent.lower.GetId())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, child := range children {
+			if seen[child.GetName()] {
+				continue
+			}
+
+			entries = append(entries, &entry{path: path.Join(parent.path, child.GetName()), lower: child, inLower: true})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+
+	nodes := make([]interfaces.Node, 0, len(entries))
+	for _, e := range entries {
+		node, err := f.present(e, parentId)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+func (f *FileSystem) Lookup(parentId uint64, name string) (interfaces.Node, error) {
+	parent, err := f.byId(parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !parent.node().GetMode().IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+
+	if name == "" || strings.Contains(name, "/") {
+		return nil, syscall.ENOENT
+	}
+
+	e, err := f.lookup(path.Join(parent.path, name))
+	if err != nil {
+		return nil, err
+	}
+
+	return f.present(e, parentId)
+}
+
+func (f *FileSystem) ReadFile(id uint64) ([]byte, error) {
+	e, err := f.byId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.upper != nil {
+		return f.upper.ReadFile(e.upper.GetId())
+	}
+
+	return f.lower.ReadFile(e.lower.GetId())
+}
+
+func (f *FileSystem) ReadLink(id uint64) (string, error) {
+	e, err := f.byId(id)
+	if err != nil {
+		return "", err
+	}
+
+	if e.upper != nil {
+		return f.upper.ReadLink(e.upper.GetId())
+	}
+
+	return f.lower.ReadLink(e.lower.GetId())
+}
+
+func (f *FileSystem) GetXattr(id uint64, key string) (string, error) {
+	if strings.HasPrefix(key, attributePrefix) {
+		return "", syscall.ENODATA
+	}
+
+	e, err := f.byId(id)
+	if err != nil {
+		return "", err
+	}
+
+	if e.upper != nil {
+		return f.upper.GetXattr(e.upper.GetId(), key)
+	}
+
+	return f.lower.GetXattr(e.lower.GetId(), key)
+}
+
+func (f *FileSystem) ListXattr(id uint64) ([]string, error) {
+	e, err := f.byId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return listXattr(f.layer(e), e.node())
+}
+
+func (f *FileSystem) layer(e *entry) *filesystem.FileSystem {
+	if e.upper != nil {
+		return f.upper
+	}
+
+	return f.lower
+}
+
+// listXattr lists the attributes of a layer node without the overlay bookkeeping
+func listXattr(layer *filesystem.FileSystem, layerNode interfaces.Node) ([]string, error) {
+	keys, err := layer.ListXattr(layerNode.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	visible := keys[:0]
+	for _, key := range keys {
+		if !strings.HasPrefix(key, attributePrefix) {
+			visible = append(visible, key)
+		}
+	}
+
+	return visible, nil
+}
+
+func isSymlink(layerNode interfaces.Node) bool {
+	return layerNode.GetMode()&fs.ModeSymlink != 0
+}
@@ -0,0 +1,529 @@
+package overlay
+
+import (
+	"io"
+	"path"
+	"strings"
+	"syscall"
+
+	filesystem "github.com/sushydev/vfs_go"
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// copyUp makes sure the upper layer has the entry, copying it and any missing
+// parent directory from the lower layer with their metadata
+func (f *FileSystem) copyUp(e *entry) (interfaces.Node, error) {
+	if e.upper != nil {
+		return e.upper, nil
+	}
+
+	parent, err := f.lookup(path.Dir(e.path))
+	if err != nil {
+		return nil, err
+	}
+
+	upperParent, err := f.copyUp(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Base(e.path)
+
+	switch {
+	case e.lower.GetMode().IsDir():
+		err = f.upper.MkDir(upperParent.GetId(), name)
+	case e.lower.GetMode().IsRegular():
+		err = f.upper.Touch(upperParent.GetId(), name)
+	case isSymlink(e.lower):
+		err = f.copyUpSymlink(e, upperParent)
+	default:
+		err = syscall.EINVAL
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	upper, err := f.upper.Lookup(upperParent.GetId(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.lower.GetMode().IsRegular() {
+		err = copyContent(f.lower, e.lower, f.upper, upper)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = copyMetadata(f.lower, e.lower, f.upper, upper)
+	if err != nil {
+		return nil, err
+	}
+
+	e.upper = upper
+
+	return upper, nil
+}
+
+// copyUpSymlink relinks a lower symlink in the upper layer, its target is copied up
+// as well since links in the VFS point at nodes rather than paths
+func (f *FileSystem) copyUpSymlink(e *entry, upperParent interfaces.Node) error {
+	target, err := f.lower.ReadLink(e.lower.GetId())
+	if err != nil {
+		return err
+	}
+
+	targetEntry, err := f.lookup(target)
+	if err != nil {
+		return err
+	}
+
+	upperTarget, err := f.copyUp(targetEntry)
+	if err != nil {
+		return err
+	}
+
+	return f.upper.Link(upperTarget.GetId(), path.Base(e.path), upperParent.GetId())
+}
+
+// prepare checks that name is free in the directory parentId and returns the
+// upper directory to create it in. A whiteout in the way is removed, which is
+// reported so a new directory can be made opaque.
+func (f *FileSystem) prepare(parentId uint64, name string) (interfaces.Node, bool, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, false, syscall.EINVAL
+	}
+
+	parent, err := f.byId(parentId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !parent.node().GetMode().IsDir() {
+		return nil, false, syscall.ENOTDIR
+	}
+
+	_, err = f.lookup(path.Join(parent.path, name))
+	switch err {
+	case nil:
+		return nil, false, syscall.EEXIST
+	case syscall.ENOENT:
+	default:
+		return nil, false, err
+	}
+
+	upperParent, err := f.copyUp(parent)
+	if err != nil {
+		return nil, false, err
+	}
+
+	existing, err := f.upper.Lookup(upperParent.GetId(), name)
+	switch err {
+	case nil:
+	case syscall.ENOENT:
+		return upperParent, false, nil
+	default:
+		return nil, false, err
+	}
+
+	err = f.upper.RemoveFile(existing.GetId())
+	if err != nil {
+		return nil, false, err
+	}
+
+	return upperParent, true, nil
+}
+
+// whiteout hides the lower entry name from the merged view
+func (f *FileSystem) whiteout(upperParent interfaces.Node, name string) error {
+	err := f.upper.Touch(upperParent.GetId(), name)
+	if err != nil {
+		return err
+	}
+
+	node, err := f.upper.Lookup(upperParent.GetId(), name)
+	if err != nil {
+		return err
+	}
+
+	return f.upper.SetXattr(node.GetId(), WhiteoutAttribute, "y")
+}
+
+func (f *FileSystem) upperParent(e *entry) (interfaces.Node, error) {
+	parent, err := f.lookup(path.Dir(e.path))
+	if err != nil {
+		return nil, err
+	}
+
+	return f.copyUp(parent)
+}
+
+func (f *FileSystem) MkDir(parentId uint64, name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	upperParent, replaced, err := f.prepare(parentId, name)
+	if err != nil {
+		return err
+	}
+
+	err = f.upper.MkDir(upperParent.GetId(), name)
+	if err != nil || !replaced {
+		return err
+	}
+
+	node, err := f.upper.Lookup(upperParent.GetId(), name)
+	if err != nil {
+		return err
+	}
+
+	return f.upper.SetXattr(node.GetId(), OpaqueAttribute, "y")
+}
+
+func (f *FileSystem) Touch(parentId uint64, name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	upperParent, _, err := f.prepare(parentId, name)
+	if err != nil {
+		return err
+	}
+
+	return f.upper.Touch(upperParent.GetId(), name)
+}
+
+func (f *FileSystem) WriteFile(id uint64, content []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	e, err := f.byId(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if !e.node().GetMode().IsRegular() {
+		return 0, syscall.EINVAL
+	}
+
+	upper, err := f.copyUp(e)
+	if err != nil {
+		return 0, err
+	}
+
+	return f.upper.WriteFile(upper.GetId(), content)
+}
+
+func (f *FileSystem) RemoveFile(id uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	e, err := f.byId(id)
+	if err != nil {
+		return err
+	}
+
+	if e.node().GetMode().IsDir() {
+		return syscall.EISDIR
+	}
+
+	return f.remove(e)
+}
+
+func (f *FileSystem) RmDir(id uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	e, err := f.byId(id)
+	if err != nil {
+		return err
+	}
+
+	if !e.node().GetMode().IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	if e.path == "/" {
+		return syscall.EBUSY
+	}
+
+	children, err := f.ReadDir(id)
+	if err != nil {
+		return err
+	}
+
+	if len(children) > 0 {
+		return syscall.ENOTEMPTY
+	}
+
+	return f.remove(e)
+}
+
+// remove drops the upper entry and leaves a whiteout where the lower layer has one
+func (f *FileSystem) remove(e *entry) error {
+	upperParent, err := f.upperParent(e)
+	if err != nil {
+		return err
+	}
+
+	if e.upper != nil {
+		err = removeTree(f.upper, e.upper)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !e.inLower {
+		return nil
+	}
+
+	return f.whiteout(upperParent, path.Base(e.path))
+}
+
+// Rename moves an entry within the merged view. Like overlayfs without
+// redirects, directories the lower layer has cannot be renamed and report EXDEV.
+func (f *FileSystem) Rename(id uint64, newName string, newParentId uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	e, err := f.byId(id)
+	if err != nil {
+		return err
+	}
+
+	if e.path == "/" {
+		return syscall.EBUSY
+	}
+
+	if e.node().GetMode().IsDir() && e.inLower {
+		return syscall.EXDEV
+	}
+
+	upper, err := f.copyUp(e)
+	if err != nil {
+		return err
+	}
+
+	oldParent, err := f.upperParent(e)
+	if err != nil {
+		return err
+	}
+
+	newParent, replaced, err := f.prepare(newParentId, newName)
+	if err != nil {
+		return err
+	}
+
+	err = f.upper.Rename(upper.GetId(), newName, newParent.GetId())
+	if err != nil {
+		return err
+	}
+
+	if replaced && upper.GetMode().IsDir() {
+		err = f.upper.SetXattr(upper.GetId(), OpaqueAttribute, "y")
+		if err != nil {
+			return err
+		}
+	}
+
+	if !e.inLower {
+		return nil
+	}
+
+	return f.whiteout(oldParent, path.Base(e.path))
+}
+
+func (f *FileSystem) Link(id uint64, name string, parentId uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	e, err := f.byId(id)
+	if err != nil {
+		return err
+	}
+
+	upper, err := f.copyUp(e)
+	if err != nil {
+		return err
+	}
+
+	upperParent, _, err := f.prepare(parentId, name)
+	if err != nil {
+		return err
+	}
+
+	return f.upper.Link(upper.GetId(), name, upperParent.GetId())
+}
+
+// Save stores the mode, owner and times of node, copying it up first
+func (f *FileSystem) Save(changed interfaces.Node) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	e, err := f.byId(changed.GetId())
+	if err != nil {
+		return err
+	}
+
+	upper, err := f.copyUp(e)
+	if err != nil {
+		return err
+	}
+
+	upper.SetMode(uint32(changed.GetMode()))
+	upper.SetUid(changed.GetUid())
+	upper.SetGid(changed.GetGid())
+	upper.SetModTime(changed.GetModTime())
+	upper.SetCreateTime(changed.GetCreateTime())
+	upper.SetAccessTime(changed.GetAccessTime())
+
+	return f.upper.Save(upper)
+}
+
+func (f *FileSystem) SetXattr(id uint64, key string, value string) error {
+	if strings.HasPrefix(key, attributePrefix) {
+		return syscall.EPERM
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	e, err := f.byId(id)
+	if err != nil {
+		return err
+	}
+
+	upper, err := f.copyUp(e)
+	if err != nil {
+		return err
+	}
+
+	return f.upper.SetXattr(upper.GetId(), key, value)
+}
+
+func (f *FileSystem) RemoveXattr(id uint64, key string) error {
+	if strings.HasPrefix(key, attributePrefix) {
+		return syscall.EPERM
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	e, err := f.byId(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.layer(e).GetXattr(e.node().GetId(), key)
+	if err != nil {
+		return err
+	}
+
+	upper, err := f.copyUp(e)
+	if err != nil {
+		return err
+	}
+
+	return f.upper.RemoveXattr(upper.GetId(), key)
+}
+
+// copyContent streams the content of a regular file from one VFS into another
+func copyContent(from *filesystem.FileSystem, source interfaces.Node, to *filesystem.FileSystem, target interfaces.Node) error {
+	reader, err := from.OpenFile(source.GetId())
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := to.OpenFile(target.GetId())
+	if err != nil {
+		return err
+	}
+
+	err = writer.Truncate(0)
+	if err == nil {
+		_, err = io.Copy(writer, reader)
+	}
+
+	closeErr := writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// copyMetadata gives target the mode, owner, times and attributes of source,
+// the overlay bookkeeping attributes are left alone on both sides
+func copyMetadata(from *filesystem.FileSystem, source interfaces.Node, to *filesystem.FileSystem, target interfaces.Node) error {
+	target.SetMode(uint32(source.GetMode()))
+	target.SetUid(source.GetUid())
+	target.SetGid(source.GetGid())
+	target.SetModTime(source.GetModTime())
+	target.SetCreateTime(source.GetCreateTime())
+	target.SetAccessTime(source.GetAccessTime())
+
+	err := to.Save(target)
+	if err != nil {
+		return err
+	}
+
+	keys, err := listXattr(from, source)
+	if err != nil {
+		return err
+	}
+
+	existing, err := listXattr(to, target)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+
+		value, err := from.GetXattr(source.GetId(), key)
+		if err != nil {
+			return err
+		}
+
+		err = to.SetXattr(target.GetId(), key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range existing {
+		if wanted[key] {
+			continue
+		}
+
+		err = to.RemoveXattr(target.GetId(), key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeTree deletes a node of a layer together with everything below it
+func removeTree(layer *filesystem.FileSystem, layerNode interfaces.Node) error {
+	if !layerNode.GetMode().IsDir() {
+		return layer.RemoveFile(layerNode.GetId())
+	}
+
+	children, err := layer.ReadDir(layerNode.GetId())
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		err = removeTree(layer, child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return layer.RmDir(layerNode.GetId())
+}
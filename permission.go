@@ -0,0 +1,478 @@
+package filesystem
+
+import (
+	"io/fs"
+	gopath "path"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// Cred is who a view made with As acts as
+type Cred struct {
+	Uid    int
+	Gid    int
+	Groups []int
+	// Umask is cleared from the permissions of nodes created through the view
+	Umask fs.FileMode
+}
+
+// Access masks, the values of R_OK, W_OK and X_OK
+const (
+	AccessExists  uint32 = 0
+	AccessExecute uint32 = 1
+	AccessWrite   uint32 = 2
+	AccessRead    uint32 = 4
+)
+
+// As returns a view of the VFS that checks every call against the
+// permissions of cred: search permission on each directory above the nodes
+// it touches, read, write and execute bits, the sticky bit on directories
+// and ownership for changes to mode and owner. Failed checks return EACCES,
+// or EPERM where only the owner or root may act. Nodes created through the
+// view are owned by cred, directories with the setgid bit hand down their
+// group instead.
+//
+// Uid 0 passes every check like root does. The view shares everything with
+// f, closing either closes both. Calls on f itself are not checked.
+func (f *FileSystem) As(cred Cred) *FileSystem {
+	cred.Groups = slices.Clone(cred.Groups)
+
+	view := *f
+	view.cred = &cred
+
+	return &view
+}
+
+// Access checks whether the caller may access id as mask, see the Access
+// constants. Without credentials only existence is checked.
+func (f *FileSystem) Access(id uint64, mask uint32) error {
+	node, err := f.Open(id)
+	if err != nil {
+		return err
+	}
+
+	return f.require(node, mask)
+}
+
+// Chmod sets the permission bits, setuid, setgid and sticky of id
+func (f *FileSystem) Chmod(id uint64, mode fs.FileMode) error {
+	node, err := f.Open(id)
+	if err != nil {
+		return err
+	}
+
+	changeable := fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+	node.SetMode(uint32(node.GetMode()&^changeable | mode&changeable))
+
+	err = f.Save(node)
+	if err != nil {
+		return err
+	}
+
+	return f.chmodACL(node)
+}
+
+// Chown sets the owner of id, -1 leaves uid or gid as it is
+func (f *FileSystem) Chown(id uint64, uid int, gid int) error {
+	node, err := f.Open(id)
+	if err != nil {
+		return err
+	}
+
+	if uid != -1 {
+		node.SetUid(uid)
+	}
+
+	if gid != -1 {
+		node.SetGid(gid)
+	}
+
+	return f.Save(node)
+}
+
+func (f *FileSystem) isRoot() bool {
+	return f.cred == nil || f.cred.Uid == 0
+}
+
+func (f *FileSystem) inGroup(gid int) bool {
+	return f.cred.Gid == gid || slices.Contains(f.cred.Groups, gid)
+}
+
+// permits tells whether the caller may access node as mask
+func (f *FileSystem) permits(node interfaces.Node, mask uint32) (bool, error) {
+	if f.cred == nil {
+		return true, nil
+	}
+
+	mode := node.GetMode()
+
+	if f.cred.Uid == 0 {
+		// Root may do anything but execute files nobody may execute
+		return mask&AccessExecute == 0 || mode.IsDir() || mode.Perm()&0111 != 0, nil
+	}
+
+	acl, err := f.storedACL(node, ACLAccess)
+	if err != nil {
+		return false, err
+	}
+
+	if acl != nil {
+		return acl.permits(node, f, mask), nil
+	}
+
+	perm := uint32(mode.Perm())
+
+	var granted uint32
+	switch {
+	case node.GetUid() == f.cred.Uid:
+		granted = perm >> 6
+	case f.inGroup(node.GetGid()):
+		granted = perm >> 3
+	default:
+		granted = perm
+	}
+
+	return granted&mask == mask, nil
+}
+
+// require fails with EACCES unless the caller may access node as mask
+func (f *FileSystem) require(node interfaces.Node, mask uint32) error {
+	permitted, err := f.permits(node, mask)
+	if err != nil {
+		return err
+	}
+
+	if !permitted {
+		return syscall.EACCES
+	}
+
+	return nil
+}
+
+// requireRoot fails with EPERM for callers other than root
+func (f *FileSystem) requireRoot() error {
+	if !f.isRoot() {
+		return syscall.EPERM
+	}
+
+	return nil
+}
+
+// requireOwner fails with EPERM for callers other than root and the owner of node
+func (f *FileSystem) requireOwner(node interfaces.Node) error {
+	if !f.isRoot() && node.GetUid() != f.cred.Uid {
+		return syscall.EPERM
+	}
+
+	return nil
+}
+
+// ancestorPaths lists the directories above path, from the root down
+func ancestorPaths(path string) []string {
+	var paths []string
+
+	for path != "/" {
+		path = gopath.Dir(path)
+		paths = append(paths, path)
+	}
+
+	slices.Reverse(paths)
+
+	return paths
+}
+
+// ancestorsPermit tells whether every stored directory above path grants
+// mask. Verdicts are kept in cache by directory path so callers checking
+// many nodes of the same tree look each directory up once.
+func (f *FileSystem) ancestorsPermit(path string, mask uint32, cache map[string]bool) (bool, error) {
+	if f.isRoot() {
+		return true, nil
+	}
+
+	ancestors := ancestorPaths(path)
+
+	var missing []string
+	for _, ancestor := range ancestors {
+		if _, ok := cache[ancestor]; !ok {
+			missing = append(missing, ancestor)
+		}
+	}
+
+	nodes, err := f.nodeRepository.GetByPaths(missing)
+	if err != nil {
+		return false, err
+	}
+
+	byPath := make(map[string]interfaces.Node, len(nodes))
+	for _, node := range nodes {
+		byPath[node.GetPath()] = node
+	}
+
+	permitted := true
+	for _, ancestor := range ancestors {
+		if verdict, ok := cache[ancestor]; ok {
+			permitted = verdict
+			continue
+		}
+
+		// Directories within mounts are not stored, the mount point above them is checked
+		if node, ok := byPath[ancestor]; ok && permitted {
+			permitted, err = f.permits(node, mask)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		cache[ancestor] = permitted
+	}
+
+	return permitted, nil
+}
+
+// traverse fails with EACCES unless the caller may search every directory above path
+func (f *FileSystem) traverse(path string) error {
+	permitted, err := f.ancestorsPermit(path, AccessExecute, make(map[string]bool))
+	if err != nil {
+		return err
+	}
+
+	if !permitted {
+		return syscall.EACCES
+	}
+
+	return nil
+}
+
+// visibility returns a filter for nodes the caller could find by listing
+// directories, which takes read and search permission on all above them
+func (f *FileSystem) visibility() func(node interfaces.Node) (bool, error) {
+	cache := make(map[string]bool)
+
+	return func(node interfaces.Node) (bool, error) {
+		return f.ancestorsPermit(node.GetPath(), AccessRead|AccessExecute, cache)
+	}
+}
+
+// checkAccess opens id and requires mask on it
+func (f *FileSystem) checkAccess(id uint64, mask uint32) error {
+	if f.cred == nil {
+		return nil
+	}
+
+	return f.Access(id, mask)
+}
+
+// checkCreate requires write and search permission on the directory parentId
+func (f *FileSystem) checkCreate(parentId uint64) error {
+	_, err := f.checkFlags(parentId, FlagImmutable)
+	if err != nil {
+		return err
+	}
+
+	return f.checkAccess(parentId, AccessWrite|AccessExecute)
+}
+
+// checkRemove requires write and search permission on the directory of id
+// and, when it is sticky, ownership of either
+func (f *FileSystem) checkRemove(id uint64) error {
+	node, err := f.checkFlags(id, FlagImmutable|FlagAppend)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.checkFlags(node.GetParentId(), FlagImmutable|FlagAppend)
+	if err != nil {
+		return err
+	}
+
+	if f.cred == nil {
+		return nil
+	}
+
+	node, err = f.Open(id)
+	if err != nil {
+		return err
+	}
+
+	parent, err := f.getNode(node.GetParentId())
+	if err != nil {
+		return err
+	}
+
+	err = f.require(parent, AccessWrite|AccessExecute)
+	if err != nil {
+		return err
+	}
+
+	if parent.GetMode()&fs.ModeSticky != 0 && !f.isRoot() && node.GetUid() != f.cred.Uid && parent.GetUid() != f.cred.Uid {
+		return syscall.EPERM
+	}
+
+	return nil
+}
+
+// checkMove checks removing id from its directory and adding it to
+// newParentId. A directory changing parents also needs to be writable as
+// its ".." changes along.
+func (f *FileSystem) checkMove(id uint64, newParentId uint64) error {
+	err := f.checkRemove(id)
+	if err != nil {
+		return err
+	}
+
+	err = f.checkCreate(newParentId)
+	if err != nil {
+		return err
+	}
+
+	if f.cred == nil {
+		return nil
+	}
+
+	node, err := f.getNode(id)
+	if err != nil {
+		return err
+	}
+
+	if node.GetMode().IsDir() && node.GetParentId() != newParentId {
+		return f.require(node, AccessWrite)
+	}
+
+	return nil
+}
+
+// checkSave holds the changes Save makes to a node against what the caller
+// may change: only root gives nodes away, the owner may change the mode and
+// move the node to a group it is in, times may be set by whoever may write
+func (f *FileSystem) checkSave(node interfaces.Node) error {
+	_, err := f.checkFlags(node.GetId(), FlagImmutable|FlagAppend)
+	if err != nil {
+		return err
+	}
+
+	if f.cred == nil {
+		return nil
+	}
+
+	stored, err := f.Open(node.GetId())
+	if err != nil {
+		return err
+	}
+
+	if node.GetName() != stored.GetName() || node.GetParentId() != stored.GetParentId() || node.GetPath() != stored.GetPath() {
+		err = f.checkMove(node.GetId(), node.GetParentId())
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.isRoot() {
+		return nil
+	}
+
+	if node.GetUid() != stored.GetUid() {
+		return syscall.EPERM
+	}
+
+	if node.GetGid() != stored.GetGid() {
+		if node.GetUid() != f.cred.Uid || !f.inGroup(node.GetGid()) {
+			return syscall.EPERM
+		}
+
+		// Handing a file to another group drops the privileges it carried
+		if !node.GetMode().IsDir() {
+			node.SetMode(uint32(node.GetMode() &^ (fs.ModeSetuid | fs.ModeSetgid)))
+		}
+	}
+
+	if node.GetMode() != stored.GetMode() {
+		err = f.requireOwner(stored)
+		if err != nil {
+			return err
+		}
+
+		if node.GetMode().Type() != stored.GetMode().Type() {
+			return syscall.EPERM
+		}
+
+		if !f.inGroup(node.GetGid()) {
+			node.SetMode(uint32(node.GetMode() &^ fs.ModeSetgid))
+		}
+	}
+
+	if node.GetModTime() != stored.GetModTime() || node.GetAccessTime() != stored.GetAccessTime() || node.GetCreateTime() != stored.GetCreateTime() {
+		if f.requireOwner(stored) != nil {
+			return f.require(stored, AccessWrite)
+		}
+	}
+
+	return nil
+}
+
+// checkXattr checks reading or writing key on id. The trusted namespace is
+// for root alone, security attributes are written by root, system ones like
+// ACLs by the owner, everything else follows the permissions of the node.
+func (f *FileSystem) checkXattr(id uint64, key string, write bool) error {
+	if write {
+		_, err := f.checkFlags(id, FlagImmutable|FlagAppend)
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.cred == nil {
+		return nil
+	}
+
+	node, err := f.Open(id)
+	if err != nil {
+		return err
+	}
+
+	namespace, _, _ := strings.Cut(key, ".")
+
+	switch {
+	case namespace == "trusted", namespace == "security" && write:
+		return f.requireRoot()
+	case namespace == "system" && write:
+		return f.requireOwner(node)
+	case namespace == "system":
+		return nil
+	case write:
+		return f.require(node, AccessWrite)
+	default:
+		return f.require(node, AccessRead)
+	}
+}
+
+// newNodeOwner returns the mode, uid and gid of a node of type mode created
+// in parent. Without credentials nodes are created as they always were.
+func (f *FileSystem) newNodeOwner(parent interfaces.Node, mode fs.FileMode) (uint32, int, int) {
+	if f.cred == nil {
+		return uint32(mode), 0, 0
+	}
+
+	uid, gid := f.cred.Uid, f.cred.Gid
+
+	if parent.GetMode()&fs.ModeSetgid != 0 {
+		gid = parent.GetGid()
+
+		if mode.IsDir() {
+			mode |= fs.ModeSetgid
+		}
+	}
+
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		mode |= 0777
+	case mode.IsDir():
+		mode |= 0777 &^ f.cred.Umask.Perm()
+	default:
+		mode |= 0666 &^ f.cred.Umask.Perm()
+	}
+
+	return uint32(mode), uid, gid
+}
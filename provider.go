@@ -0,0 +1,182 @@
+package filesystem
+
+import (
+	"database/sql"
+	"io"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/cache"
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// Attributes that make a regular file provider backed, its content is then
+// never stored and read from the named provider on demand instead
+const (
+	ProviderAttribute    = "trusted.vfs.provider"
+	ProviderKeyAttribute = "trusted.vfs.provider_key"
+)
+
+type providerTable struct {
+	mutex     sync.RWMutex
+	providers map[string]interfaces.ContentProvider
+}
+
+func newProviderTable() *providerTable {
+	return &providerTable{providers: make(map[string]interfaces.ContentProvider)}
+}
+
+// RegisterProvider makes provider available to files naming it, registering
+// a name again replaces the provider behind it
+func (f *FileSystem) RegisterProvider(name string, provider interfaces.ContentProvider) {
+	f.providers.mutex.Lock()
+	defer f.providers.mutex.Unlock()
+
+	f.providers.providers[name] = provider
+}
+
+// RegisterCachedProvider registers provider behind a block cache, ReadFile
+// and handles then read its files through the cache. The cache is returned
+// for its metrics and to invalidate keys.
+func (f *FileSystem) RegisterCachedProvider(name string, provider interfaces.ContentProvider, options cache.Options) (*cache.Cache, error) {
+	cached, err := cache.New(provider, options)
+	if err != nil {
+		return nil, err
+	}
+
+	f.RegisterProvider(name, cached)
+
+	return cached, nil
+}
+
+func (f *FileSystem) provider(name string) (interfaces.ContentProvider, error) {
+	f.providers.mutex.RLock()
+	defer f.providers.mutex.RUnlock()
+
+	provider, ok := f.providers.providers[name]
+	if !ok {
+		return nil, syscall.ENXIO
+	}
+
+	return provider, nil
+}
+
+// TouchRemote creates a regular file whose content comes from key of the
+// registered provider, its modification time is taken from the provider.
+// The file is created along with its provider and key, it is never seen
+// without them. Mounted directories cannot hold one and fail with EROFS.
+func (f *FileSystem) TouchRemote(parentId uint64, name string, providerName string, key string) error {
+	provider, err := f.provider(providerName)
+	if err != nil {
+		return err
+	}
+
+	info, err := provider.Stat(key)
+	if err != nil {
+		return err
+	}
+
+	err = f.touch(parentId, name, map[string]string{
+		ProviderAttribute:    providerName,
+		ProviderKeyAttribute: key,
+	})
+	if err != nil {
+		return err
+	}
+
+	node, err := f.Lookup(parentId, name)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime.IsZero() {
+		return nil
+	}
+
+	node.SetModTime(info.ModTime.UTC().Format(TimeFormat))
+
+	return f.Save(node)
+}
+
+// StatRemote asks the provider of a provider backed file about its content
+func (f *FileSystem) StatRemote(id uint64) (interfaces.ContentInfo, error) {
+	node, err := f.getNode(id)
+	if err != nil {
+		return interfaces.ContentInfo{}, err
+	}
+
+	provider, key, err := f.contentProvider(node)
+	if err != nil {
+		return interfaces.ContentInfo{}, err
+	}
+
+	if provider == nil {
+		return interfaces.ContentInfo{}, syscall.EINVAL
+	}
+
+	return provider.Stat(key)
+}
+
+// contentProvider returns the provider and key of a provider backed file,
+// the provider is nil for files with stored content
+func (f *FileSystem) contentProvider(node interfaces.Node) (interfaces.ContentProvider, string, error) {
+	if isGeneratedId(node.GetId()) {
+		return &generatedProvider{fileSystem: f}, strconv.FormatUint(node.GetId(), 10), nil
+	}
+
+	if !node.GetMode().IsRegular() || isMountedId(node.GetId()) {
+		return nil, "", nil
+	}
+
+	name, err := f.nodeAttributeRepository.GetByNodeAndKey(node, ProviderAttribute)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
+	}
+
+	if name == nil {
+		return nil, "", nil
+	}
+
+	key, err := f.nodeAttributeRepository.GetByNodeAndKey(node, ProviderKeyAttribute)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
+	}
+
+	if key == nil {
+		return nil, "", syscall.EIO
+	}
+
+	provider, err := f.provider(name.GetValue())
+	if err != nil {
+		return nil, "", err
+	}
+
+	return provider, key.GetValue(), nil
+}
+
+// providerReader reads one key of a provider as an io.ReaderAt
+type providerReader struct {
+	provider interfaces.ContentProvider
+	key      string
+}
+
+func (r *providerReader) ReadAt(p []byte, offset int64) (int, error) {
+	return r.provider.ReadAt(r.key, p, offset)
+}
+
+func readProvided(provider interfaces.ContentProvider, key string) ([]byte, error) {
+	size, err := provider.Size(key)
+	if err != nil {
+		return nil, err
+	}
+
+	content := make([]byte, size)
+
+	n, err := io.ReadFull(io.NewSectionReader(&providerReader{provider: provider, key: key}, 0, size), content)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return content[:n], nil
+}
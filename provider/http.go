@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// HTTP serves objects below a base URL with range requests, keys are
+// appended to it. The server has to support HEAD and byte ranges.
+type HTTP struct {
+	base   string
+	client *http.Client
+}
+
+var _ interfaces.ContentProvider = &HTTP{}
+
+// NewHTTP uses http.DefaultClient when client is nil
+func NewHTTP(base string, client *http.Client) *HTTP {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTP{
+		base:   strings.TrimSuffix(base, "/"),
+		client: client,
+	}
+}
+
+func (h *HTTP) url(key string) string {
+	parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	for index, part := range parts {
+		parts[index] = url.PathEscape(part)
+	}
+
+	return h.base + "/" + strings.Join(parts, "/")
+}
+
+// statusError maps the response status onto the errno the VFS reports
+func statusError(response *http.Response) error {
+	switch response.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return syscall.ENOENT
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return syscall.EACCES
+	default:
+		return fmt.Errorf("provider: %s", response.Status)
+	}
+}
+
+func (h *HTTP) Size(key string) (int64, error) {
+	info, err := h.Stat(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size, nil
+}
+
+func (h *HTTP) ReadAt(key string, p []byte, offset int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	request, err := http.NewRequest(http.MethodGet, h.url(key), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(p))-1))
+
+	response, err := h.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	default:
+		return 0, statusError(response)
+	}
+
+	n, err := io.ReadFull(response.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+func (h *HTTP) Stat(key string) (interfaces.ContentInfo, error) {
+	response, err := h.client.Head(h.url(key))
+	if err != nil {
+		return interfaces.ContentInfo{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return interfaces.ContentInfo{}, statusError(response)
+	}
+
+	if response.ContentLength < 0 {
+		return interfaces.ContentInfo{}, fmt.Errorf("provider: no content length for %s", key)
+	}
+
+	modTime, _ := time.Parse(http.TimeFormat, response.Header.Get("Last-Modified"))
+
+	return interfaces.ContentInfo{
+		Size:    response.ContentLength,
+		ModTime: modTime,
+		Version: response.Header.Get("ETag"),
+	}, nil
+}
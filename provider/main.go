@@ -0,0 +1,79 @@
+// Package provider holds reference implementations of interfaces.ContentProvider
+package provider
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// Directory serves files below a host directory, keys are slash separated
+// paths relative to it
+type Directory struct {
+	root string
+}
+
+var _ interfaces.ContentProvider = &Directory{}
+
+func NewDirectory(root string) *Directory {
+	return &Directory{root: root}
+}
+
+func (d *Directory) path(key string) (string, error) {
+	if key == "" || strings.Contains("/"+key+"/", "/../") {
+		return "", syscall.EINVAL
+	}
+
+	return filepath.Join(d.root, filepath.FromSlash(path.Clean("/"+key))), nil
+}
+
+func (d *Directory) Size(key string) (int64, error) {
+	info, err := d.Stat(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size, nil
+}
+
+func (d *Directory) ReadAt(key string, p []byte, offset int64) (int, error) {
+	name, err := d.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return file.ReadAt(p, offset)
+}
+
+func (d *Directory) Stat(key string) (interfaces.ContentInfo, error) {
+	name, err := d.path(key)
+	if err != nil {
+		return interfaces.ContentInfo{}, err
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return interfaces.ContentInfo{}, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return interfaces.ContentInfo{}, syscall.EINVAL
+	}
+
+	return interfaces.ContentInfo{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Version: fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+	}, nil
+}
@@ -0,0 +1,336 @@
+package filesystem
+
+import (
+	"database/sql"
+	"strings"
+	"syscall"
+	"time"
+
+	database_interfaces "github.com/sushydev/vfs_go/internal/database/interfaces"
+)
+
+type QuotaKind string
+
+const (
+	// QuotaDirectory caps a directory and everything below it
+	QuotaDirectory QuotaKind = "directory"
+	// QuotaUid and QuotaGid cap the nodes owned by a user or group
+	QuotaUid QuotaKind = "uid"
+	QuotaGid QuotaKind = "gid"
+)
+
+// Quota limits the bytes and nodes of a directory tree or an owner, limits
+// of 0 do not apply. Going over a hard limit fails with EDQUOT right away,
+// usage may stay above a soft limit for the grace period before that limit
+// is enforced the same way.
+type Quota struct {
+	Kind QuotaKind
+	// Subject is the id of the directory, or the uid or gid
+	Subject   uint64
+	SoftBytes int64
+	HardBytes int64
+	SoftNodes int64
+	HardNodes int64
+	Grace     time.Duration
+}
+
+type QuotaUsage struct {
+	Quota
+	Usage
+
+	// SoftExceeded is when usage went above a soft limit, zero while it is within them
+	SoftExceeded time.Time
+}
+
+// GraceExpired tells whether the soft limits are enforced at now
+func (u QuotaUsage) GraceExpired(now time.Time) bool {
+	return !u.SoftExceeded.IsZero() && now.Sub(u.SoftExceeded) >= u.Grace
+}
+
+// quotaCharge is what an operation adds to the usage around path. Moves
+// within the VFS come from somewhere, quotas already counting them there
+// are not charged again.
+type quotaCharge struct {
+	path  string
+	from  string
+	uid   int
+	gid   int
+	nodes int64
+	bytes int64
+}
+
+// SetQuota defines or replaces the quota of a directory, uid or gid. Its
+// usage is added up here and kept along with it from then on, so writes are
+// charged without going over the tree.
+func (f *FileSystem) SetQuota(quota Quota) error {
+	err := f.requireWritable()
+	if err != nil {
+		return err
+	}
+
+	err = f.requireRoot()
+	if err != nil {
+		return err
+	}
+
+	if quota.SoftBytes < 0 || quota.HardBytes < 0 || quota.SoftNodes < 0 || quota.HardNodes < 0 || quota.Grace < 0 {
+		return syscall.EINVAL
+	}
+
+	switch quota.Kind {
+	case QuotaUid, QuotaGid:
+	case QuotaDirectory:
+		if isMountedId(quota.Subject) || isGeneratedId(quota.Subject) {
+			return syscall.EINVAL
+		}
+
+		node, err := f.nodeRepository.Get(quota.Subject)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if node == nil {
+			return syscall.ENOENT
+		}
+
+		if !node.GetMode().IsDir() {
+			return syscall.ENOTDIR
+		}
+	default:
+		return syscall.EINVAL
+	}
+
+	err = f.database.SaveQuota(
+		string(quota.Kind),
+		int64(quota.Subject),
+		quota.SoftBytes,
+		quota.HardBytes,
+		quota.SoftNodes,
+		quota.HardNodes,
+		int64(quota.Grace/time.Second),
+	)
+	return err
+}
+
+func (f *FileSystem) RemoveQuota(kind QuotaKind, subject uint64) error {
+	err := f.requireWritable()
+	if err != nil {
+		return err
+	}
+
+	err = f.requireRoot()
+	if err != nil {
+		return err
+	}
+
+	deleted, err := f.database.DeleteQuota(string(kind), int64(subject))
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return syscall.ENOENT
+	}
+
+	return nil
+}
+
+// GetQuotaUsage reports a quota along with the usage it applies to
+func (f *FileSystem) GetQuotaUsage(kind QuotaKind, subject uint64) (QuotaUsage, error) {
+	entity, err := f.database.GetQuota(string(kind), int64(subject))
+	if err == sql.ErrNoRows {
+		return QuotaUsage{}, syscall.ENOENT
+	}
+
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	return f.quotaUsage(entity)
+}
+
+// QuotaReport reports every quota by kind and subject
+func (f *FileSystem) QuotaReport() ([]QuotaUsage, error) {
+	entities, err := f.database.GetQuotas()
+	if err != nil {
+		return nil, err
+	}
+
+	report := make([]QuotaUsage, 0, len(entities))
+	for _, entity := range entities {
+		usage, err := f.quotaUsage(entity)
+		if err != nil {
+			return nil, err
+		}
+
+		report = append(report, usage)
+	}
+
+	return report, nil
+}
+
+// quotaUsage reports entity with its usage added up from the nodes, which
+// tells files and directories apart as well
+func (f *FileSystem) quotaUsage(entity database_interfaces.Quota) (QuotaUsage, error) {
+	if QuotaKind(entity.GetKind()) != QuotaDirectory {
+		usage, err := f.database.GetOwnerUsage(entity.GetKind(), int(entity.GetSubject()))
+		if err != nil {
+			return QuotaUsage{}, err
+		}
+
+		return quotaWith(entity, Usage(usage))
+	}
+
+	node, err := f.nodeRepository.Get(uint64(entity.GetSubject()))
+	if err == sql.ErrNoRows {
+		return quotaWith(entity, Usage{})
+	}
+
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	usage, err := f.database.GetNodeUsage(node.GetPath())
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	return quotaWith(entity, Usage(usage))
+}
+
+// quotaWith reports entity along with usage
+func quotaWith(entity database_interfaces.Quota, usage Usage) (QuotaUsage, error) {
+	quotaUsage := QuotaUsage{
+		Quota: Quota{
+			Kind:      QuotaKind(entity.GetKind()),
+			Subject:   uint64(entity.GetSubject()),
+			SoftBytes: entity.GetSoftBytes(),
+			HardBytes: entity.GetHardBytes(),
+			SoftNodes: entity.GetSoftNodes(),
+			HardNodes: entity.GetHardNodes(),
+			Grace:     time.Duration(entity.GetGrace()) * time.Second,
+		},
+		Usage: usage,
+	}
+
+	if entity.GetSoftExceeded() != "" {
+		softExceeded, err := time.Parse(TimeFormat, entity.GetSoftExceeded())
+		if err != nil {
+			return quotaUsage, err
+		}
+
+		quotaUsage.SoftExceeded = softExceeded
+	}
+
+	// Usage may have dropped below the soft limits since they were exceeded
+	if !overLimit(quotaUsage.Nodes, quotaUsage.SoftNodes) && !overLimit(quotaUsage.Bytes, quotaUsage.SoftBytes) {
+		quotaUsage.SoftExceeded = time.Time{}
+	}
+
+	return quotaUsage, nil
+}
+
+// chargeQuota fails with EDQUOT when charge would take usage over a hard
+// limit, or over a soft limit whose grace period has run out. It keeps
+// track of when soft limits are first exceeded on the way.
+func (f *FileSystem) chargeQuota(charge quotaCharge) error {
+	if charge.nodes <= 0 && charge.bytes <= 0 {
+		return nil
+	}
+
+	// Quotas are read on every charge, other processes may have set them
+	entities, err := f.database.GetQuotas()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, entity := range entities {
+		applies, err := f.quotaApplies(entity, charge)
+		if err != nil {
+			return err
+		}
+
+		if !applies {
+			continue
+		}
+
+		// The usage kept with the quota, adding it up would read the whole tree
+		usage, err := quotaWith(entity, Usage{Nodes: entity.GetUsedNodes(), Bytes: entity.GetUsedBytes()})
+		if err != nil {
+			return err
+		}
+
+		nodes, bytes := usage.Nodes+charge.nodes, usage.Bytes+charge.bytes
+
+		if charge.nodes > 0 && overLimit(nodes, usage.HardNodes) || charge.bytes > 0 && overLimit(bytes, usage.HardBytes) {
+			return syscall.EDQUOT
+		}
+
+		softNodes, softBytes := overLimit(nodes, usage.SoftNodes), overLimit(bytes, usage.SoftBytes)
+
+		switch {
+		case (softNodes || softBytes) && usage.SoftExceeded.IsZero():
+			entity.SetSoftExceeded(now.UTC().Format(TimeFormat))
+		case !softNodes && !softBytes && entity.GetSoftExceeded() != "":
+			entity.SetSoftExceeded("")
+		case usage.GraceExpired(now) && (softNodes && charge.nodes > 0 || softBytes && charge.bytes > 0):
+			return syscall.EDQUOT
+		default:
+			continue
+		}
+
+		err = f.database.SetQuotaSoftExceeded(entity)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *FileSystem) quotaApplies(entity database_interfaces.Quota, charge quotaCharge) (bool, error) {
+	switch QuotaKind(entity.GetKind()) {
+	case QuotaUid:
+		return charge.from == "" && int64(charge.uid) == entity.GetSubject(), nil
+	case QuotaGid:
+		return charge.from == "" && int64(charge.gid) == entity.GetSubject(), nil
+	case QuotaDirectory:
+		node, err := f.nodeRepository.Get(uint64(entity.GetSubject()))
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		return pathWithin(charge.path, node.GetPath()) && (charge.from == "" || !pathWithin(charge.from, node.GetPath())), nil
+	}
+
+	return false, nil
+}
+
+func overLimit(value int64, limit int64) bool {
+	return limit > 0 && value > limit
+}
+
+func pathWithin(path string, directory string) bool {
+	return directory == "/" || path == directory || strings.HasPrefix(path, directory+"/")
+}
+
+// chargeMove charges the quotas a subtree moves into with its usage
+func (f *FileSystem) chargeMove(from string, to string) error {
+	active, err := f.database.HasQuotas()
+	if err != nil || !active {
+		return err
+	}
+
+	usage, err := f.database.GetNodeUsage(from)
+	if err != nil {
+		return err
+	}
+
+	return f.chargeQuota(quotaCharge{path: to, from: from, nodes: usage.Nodes, bytes: usage.Bytes})
+}
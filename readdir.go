@@ -0,0 +1,133 @@
+package filesystem
+
+import (
+	"database/sql"
+	"iter"
+	"sort"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+// readDirBatchSize is the number of entries ReadDirSeq fetches per page
+const readDirBatchSize = 256
+
+// ReadDirPage returns up to limit entries of a directory in name order,
+// starting after cursor. Pass an empty cursor for the first page and the
+// returned cursor for the next one, an empty cursor is returned after the
+// last page. The cursor is the name of the last entry returned, so like a
+// getdents offset it stays valid while the directory changes: entries
+// created or removed behind it do not show up or shift the listing, those
+// ahead of it show up or disappear as they are when their page is read.
+func (f *FileSystem) ReadDirPage(id uint64, cursor string, limit int) ([]interfaces.Node, string, error) {
+	if limit <= 0 {
+		return nil, "", syscall.EINVAL
+	}
+
+	err := f.checkAccess(id, AccessRead)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, _, ok := f.mounts.resolve(id); ok {
+		nodes, err := f.ReadDir(id)
+		if err != nil {
+			return nil, "", err
+		}
+
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].GetName() < nodes[j].GetName() })
+
+		start := sort.Search(len(nodes), func(i int) bool { return nodes[i].GetName() > cursor })
+
+		return page(nodes[start:], limit, false)
+	}
+
+	parentNode, err := f.nodeRepository.Get(id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
+	}
+
+	if parentNode == nil {
+		return nil, "", syscall.ENOENT
+	}
+
+	if !parentNode.GetMode().IsDir() {
+		return nil, "", syscall.ENOTDIR
+	}
+
+	children, err := f.nodeRepository.GetChildrenAfter(parentNode, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// A full page may have more stored entries after it, generated ones
+	// beyond its last name belong to a later page
+	full := len(children) == limit
+
+	stored := make(map[string]bool, len(children))
+	for _, child := range children {
+		stored[child.GetName()] = true
+	}
+
+	for _, instance := range f.generators.list(parentNode) {
+		if instance.name <= cursor || stored[instance.name] {
+			continue
+		}
+
+		if full && instance.name > children[len(children)-1].GetName() {
+			break
+		}
+
+		node, err := f.generatedNode(instance)
+		if err != nil {
+			return nil, "", err
+		}
+
+		children = append(children, node)
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].GetName() < children[j].GetName() })
+
+	return page(children, limit, full)
+}
+
+// page cuts nodes down to limit and works out the cursor that follows
+func page(nodes []interfaces.Node, limit int, more bool) ([]interfaces.Node, string, error) {
+	if len(nodes) > limit {
+		nodes, more = nodes[:limit], true
+	}
+
+	if !more || len(nodes) == 0 {
+		return nodes, "", nil
+	}
+
+	return nodes, nodes[len(nodes)-1].GetName(), nil
+}
+
+// ReadDirSeq streams the entries of a directory in name order, reading it
+// page by page as ReadDirPage does
+func (f *FileSystem) ReadDirSeq(id uint64) iter.Seq2[interfaces.Node, error] {
+	return func(yield func(interfaces.Node, error) bool) {
+		cursor := ""
+
+		for {
+			nodes, next, err := f.ReadDirPage(id, cursor, readDirBatchSize)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, node := range nodes {
+				if !yield(node, nil) {
+					return
+				}
+			}
+
+			if next == "" {
+				return
+			}
+
+			cursor = next
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package filesystem
+
+import (
+	"database/sql"
+	"mime"
+	"net/http"
+	gopath "path"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/sushydev/vfs_go/interfaces"
+	"github.com/sushydev/vfs_go/internal/filesystem/node"
+)
+
+// SearchOptions decide which files the full text index takes in
+type SearchOptions struct {
+	// MimeTypes are the media types indexed, "text/*" matches the whole family
+	MimeTypes []string
+	// MaxSize is the size in bytes above which content is not indexed
+	MaxSize int64
+}
+
+// DefaultSearchOptions are used when a VFS with a search index is opened
+var DefaultSearchOptions = SearchOptions{
+	MimeTypes: []string{"text/*", "application/json", "application/xml", "application/javascript", "application/x-sh"},
+	MaxSize:   1 << 20,
+}
+
+type SearchResult struct {
+	Node interfaces.Node
+	// Snippet is the best matching part of the content with matches in [brackets]
+	Snippet string
+	// Rank orders results, lower is better
+	Rank float64
+}
+
+type searchIndex struct {
+	mutex   sync.RWMutex
+	enabled bool
+	options SearchOptions
+}
+
+// EnableSearch creates the full text index if it does not exist yet and
+// indexes the stored files accepted by options. Writes and removals keep it
+// current from then on, also in later sessions, which index by the options
+// stored along with it. Enabling it again replaces those options.
+// The index covers every namespace, Search only finds nodes of its own.
+func (f *FileSystem) EnableSearch(options SearchOptions) error {
+	err := f.requireWritable()
+	if err != nil {
+		return err
+	}
+
+	err = f.requireRoot()
+	if err != nil {
+		return err
+	}
+
+	err = f.database.CreateSearchIndex(options.MimeTypes, options.MaxSize)
+	if err != nil {
+		return err
+	}
+
+	f.search.mutex.Lock()
+	f.search.enabled, f.search.options = true, options
+	f.search.mutex.Unlock()
+
+	entities, err := f.database.GetSearchableNodes(options.MaxSize)
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range entities {
+		node, err := node.New(entity)
+		if err != nil {
+			return err
+		}
+
+		err = f.indexStored(node)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DisableSearch drops the full text index
+func (f *FileSystem) DisableSearch() error {
+	err := f.requireWritable()
+	if err != nil {
+		return err
+	}
+
+	err = f.requireRoot()
+	if err != nil {
+		return err
+	}
+
+	f.search.mutex.Lock()
+	f.search.enabled = false
+	f.search.mutex.Unlock()
+
+	return f.database.DropSearchIndex()
+}
+
+// restoreSearch picks the index up again when an earlier session enabled
+// it, with the options it was enabled with. Indexes made before those were
+// stored go by DefaultSearchOptions.
+func (f *FileSystem) restoreSearch() error {
+	exists, err := f.database.HasSearchIndex()
+	if err != nil || !exists {
+		return err
+	}
+
+	options := DefaultSearchOptions
+
+	mimeTypes, maxSize, err := f.database.GetSearchOptions()
+	switch err {
+	case nil:
+		options = SearchOptions{MimeTypes: mimeTypes, MaxSize: maxSize}
+	case sql.ErrNoRows:
+	default:
+		return err
+	}
+
+	f.search.enabled, f.search.options = true, options
+
+	return nil
+}
+
+// Search runs an FTS5 query over the indexed files below subtreeId, best matches first
+func (f *FileSystem) Search(query string, subtreeId uint64, limit int) ([]SearchResult, error) {
+	f.search.mutex.RLock()
+	enabled := f.search.enabled
+	f.search.mutex.RUnlock()
+
+	if !enabled {
+		return nil, syscall.ENOTSUP
+	}
+
+	subtree, err := f.Open(subtreeId)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	hits, err := f.database.SearchNodes(query, subtree.GetPath(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := f.visibility()
+
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		node, err := node.New(hit.Node)
+		if err != nil {
+			return nil, err
+		}
+
+		// Snippets give content away, so hits the caller may not read are left out
+		permitted, err := visible(node)
+		if err != nil {
+			return nil, err
+		}
+
+		readable, err := f.permits(node, AccessRead)
+		if err != nil {
+			return nil, err
+		}
+
+		if !permitted || !readable {
+			continue
+		}
+
+		results = append(results, SearchResult{Node: node, Snippet: hit.Snippet, Rank: hit.Rank})
+	}
+
+	return results, nil
+}
+
+func (o *SearchOptions) accepts(name string, content []byte) bool {
+	if int64(len(content)) > o.MaxSize || !utf8.Valid(content) {
+		return false
+	}
+
+	mediaType := mimeType(name, content)
+	for _, accepted := range o.MimeTypes {
+		if accepted == mediaType || strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mimeType goes by the extension and sniffs the content when that is unknown
+func mimeType(name string, content []byte) string {
+	detected := mime.TypeByExtension(gopath.Ext(name))
+	if detected == "" {
+		detected = http.DetectContentType(content[:min(len(content), 512)])
+	}
+
+	mediaType, _, err := mime.ParseMediaType(detected)
+	if err != nil {
+		return detected
+	}
+
+	return mediaType
+}
+
+// index brings the index entry of a file in line with content
+func (f *FileSystem) index(node interfaces.Node, content []byte) error {
+	f.search.mutex.RLock()
+	enabled, options := f.search.enabled, f.search.options
+	f.search.mutex.RUnlock()
+
+	if !enabled {
+		return nil
+	}
+
+	if !options.accepts(node.GetName(), content) {
+		return f.database.DeleteNodeSearch(node.GetEntity())
+	}
+
+	return f.database.IndexNodeContent(node.GetEntity(), string(content))
+}
+
+// indexStored indexes the stored content of a file without loading files too large to index
+func (f *FileSystem) indexStored(node interfaces.Node) error {
+	f.search.mutex.RLock()
+	enabled, maxSize := f.search.enabled, f.search.options.MaxSize
+	f.search.mutex.RUnlock()
+
+	if !enabled {
+		return nil
+	}
+
+	size, err := f.database.GetNodeContentSize(node.GetEntity())
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if size > maxSize {
+		return f.database.DeleteNodeSearch(node.GetEntity())
+	}
+
+	content, err := f.database.ReadNodeContentAt(node.GetEntity(), 0, size)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	return f.index(node, content)
+}
+
+func (f *FileSystem) unindex(node interfaces.Node) error {
+	f.search.mutex.RLock()
+	enabled := f.search.enabled
+	f.search.mutex.RUnlock()
+
+	if !enabled {
+		return nil
+	}
+
+	return f.database.DeleteNodeSearch(node.GetEntity())
+}
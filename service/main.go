@@ -73,62 +73,32 @@ func FindDirectory(fileSystem *filesystem.FileSystem, name string) (interfaces.N
 	return node, nil
 }
 
+// FindOrCreateFile returns the file name in parentId, creating it when it
+// does not exist. Concurrent callers all get the same file.
 func FindOrCreateFile(fileSystem *filesystem.FileSystem, parentId uint64, name string) (interfaces.Node, error) {
-	existingNode, err := fileSystem.Lookup(parentId, name)
-	switch err {
-	case nil:
-		if !existingNode.GetMode().IsRegular() {
-			return nil, fmt.Errorf("node %s is not a file", name)
-		}
-
-		return existingNode, nil
-	case syscall.ENOENT:
-		err := fileSystem.Touch(parentId, name)
-		if err != nil {
-			return nil, err
-		}
-
-		node, err := fileSystem.Lookup(parentId, name)
-		if err != nil {
-			return nil, err
-		}
-
-		if !node.GetMode().IsRegular() {
-			return nil, syscall.EISDIR
-		}
-
-		return node, nil
-	default:
+	node, _, err := fileSystem.LookupOrTouch(parentId, name)
+	if err != nil {
 		return nil, err
 	}
+
+	if !node.GetMode().IsRegular() {
+		return nil, fmt.Errorf("node %s is not a file", name)
+	}
+
+	return node, nil
 }
 
+// FindOrCreateDirectory returns the directory name in parentId, creating it
+// when it does not exist. Concurrent callers all get the same directory.
 func FindOrCreateDirectory(fileSystem *filesystem.FileSystem, parentId uint64, name string) (interfaces.Node, error) {
-	existingNode, err := fileSystem.Lookup(parentId, name)
-	switch err {
-	case nil:
-		if !existingNode.GetMode().IsDir() {
-			return nil, fmt.Errorf("node %s is not a directory", name)
-		}
-
-		return existingNode, nil
-	case syscall.ENOENT:
-		err := fileSystem.MkDir(parentId, name)
-		if err != nil {
-			return nil, err
-		}
-
-		node, err := fileSystem.Lookup(parentId, name)
-		if err != nil {
-			return nil, err
-		}
-
-		if !node.GetMode().IsDir() {
-			return nil, syscall.ENOTDIR
-		}
-
-		return node, nil
-	default:
+	node, _, err := fileSystem.LookupOrMkDir(parentId, name)
+	if err != nil {
 		return nil, err
 	}
+
+	if !node.GetMode().IsDir() {
+		return nil, fmt.Errorf("node %s is not a directory", name)
+	}
+
+	return node, nil
 }
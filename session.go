@@ -0,0 +1,181 @@
+package filesystem
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sushydev/vfs_go/internal/database"
+)
+
+// The locks and open files of a VFS outlast it by up to sessionLease when it
+// stops without being closed, when its process dies for one. The lease is
+// renewed well before.
+const sessionLease = 15 * time.Second
+
+// sessionState is the session the locks and open files of a VFS and its
+// views are recorded in, started with the first of them. Other processes
+// using the database see them through it.
+type sessionState struct {
+	mutex     sync.Mutex
+	sessionId int64
+	database  *database.Database
+	stop      chan struct{}
+	done      chan struct{}
+	// handles numbers the handles that took locks
+	handles int64
+	// open counts the handles open per node, the session records the node
+	// open while there are any
+	open map[int64]int
+}
+
+// openNode records that handle opened its node, in the session. It fails
+// with ENOENT when the node was removed in the meantime.
+func (s *sessionState) openNode(database *database.Database, handle *Handle) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessionId, err := s.startLocked(database)
+	if err != nil {
+		return err
+	}
+
+	nodeId := int64(handle.node.GetId())
+
+	if s.open[nodeId] == 0 {
+		err = database.OpenNode(sessionId, nodeId)
+		if err != nil {
+			return constraintError(err)
+		}
+	}
+
+	s.open[nodeId]++
+	handle.sessionId = sessionId
+
+	return nil
+}
+
+// closeNode records that handle closed its node. When it was the last one
+// open anywhere of a node that was removed, that deletes the node.
+func (s *sessionState) closeNode(handle *Handle) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// A handle opened in a session that since ended is not counted in this one
+	if handle.sessionId == 0 || handle.sessionId != s.sessionId {
+		return nil
+	}
+
+	nodeId := int64(handle.node.GetId())
+
+	s.open[nodeId]--
+	if s.open[nodeId] > 0 {
+		return nil
+	}
+
+	delete(s.open, nodeId)
+
+	_, err := s.database.CloseNode(s.sessionId, nodeId, time.Now().UnixMilli())
+
+	return err
+}
+
+// start returns the session, starting it and the renewal of its lease the
+// first time
+func (s *sessionState) start(database *database.Database) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.startLocked(database)
+}
+
+// startLocked is start with the mutex held
+func (s *sessionState) startLocked(database *database.Database) (int64, error) {
+	if s.sessionId != 0 {
+		return s.sessionId, nil
+	}
+
+	sessionId, err := database.CreateSession(os.Getpid(), time.Now().Add(sessionLease).UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	s.sessionId, s.database = sessionId, database
+	s.open = map[int64]int{}
+
+	if s.stop == nil {
+		s.stop, s.done = make(chan struct{}), make(chan struct{})
+
+		go s.renew(s.stop, s.done)
+	}
+
+	return sessionId, nil
+}
+
+// renew keeps the lease of the session until stop is closed. A session that
+// expired anyway, with the process stalled for longer than the lease, has
+// lost its locks and open files to whoever purged it, a new one is started
+// for the next.
+func (s *sessionState) renew(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(sessionLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mutex.Lock()
+		sessionId, database := s.sessionId, s.database
+		s.mutex.Unlock()
+
+		if sessionId == 0 {
+			continue
+		}
+
+		renewed, err := database.RenewSession(sessionId, time.Now().Add(sessionLease).UnixMilli())
+		if err != nil || renewed {
+			continue
+		}
+
+		s.mutex.Lock()
+		if s.sessionId == sessionId {
+			s.sessionId = 0
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// close ends the session, releasing every lock held in it, and deletes the
+// removed files only it still had open
+func (s *sessionState) close() error {
+	s.mutex.Lock()
+	stop, done, sessionId, database := s.stop, s.done, s.sessionId, s.database
+	s.stop, s.sessionId, s.open = nil, 0, nil
+	s.mutex.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+
+	// renew takes the mutex, it is not held here
+	close(stop)
+	<-done
+
+	if sessionId == 0 {
+		return nil
+	}
+
+	err := database.DeleteSession(sessionId)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.ReclaimUnlinkedNodes(time.Now().UnixMilli())
+
+	return err
+}
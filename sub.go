@@ -0,0 +1,315 @@
+package filesystem
+
+import (
+	gopath "path"
+	"strings"
+	"syscall"
+
+	"github.com/sushydev/vfs_go/interfaces"
+	database_node "github.com/sushydev/vfs_go/internal/database/node"
+	"github.com/sushydev/vfs_go/internal/filesystem/node"
+)
+
+// SubFileSystem is the part of a VFS below one directory, presented as a
+// file system of its own. Ids are those of the VFS, paths start at the
+// directory, which is its root and its own parent.
+type SubFileSystem struct {
+	fileSystem *FileSystem
+	rootId     uint64
+}
+
+var _ interfaces.FileSystem = &SubFileSystem{}
+
+// Sub returns the directory id and everything below it as a file system.
+// Nodes outside of it are not found through it, symlinks pointing out of it
+// read as dangling. The view goes through f, so the credentials of f apply.
+func (f *FileSystem) Sub(id uint64) (*SubFileSystem, error) {
+	root, err := f.Open(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !root.GetMode().IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+
+	return &SubFileSystem{fileSystem: f, rootId: id}, nil
+}
+
+// root returns the directory as the VFS has it now, it may have been moved since Sub
+func (s *SubFileSystem) root() (interfaces.Node, error) {
+	return s.fileSystem.Open(s.rootId)
+}
+
+// open returns the node id of the VFS, refusing nodes outside of the view
+func (s *SubFileSystem) open(id uint64) (interfaces.Node, interfaces.Node, error) {
+	root, err := s.root()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	node, err := s.fileSystem.Open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !isWithin(node, root) {
+		return nil, nil, syscall.ENOENT
+	}
+
+	return node, root, nil
+}
+
+// check fails with ENOENT unless every id is within the view
+func (s *SubFileSystem) check(ids ...uint64) error {
+	for _, id := range ids {
+		_, _, err := s.open(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// translate presents a node of the VFS with its path within the view
+func (s *SubFileSystem) translate(local interfaces.Node, root interfaces.Node) (interfaces.Node, error) {
+	parentId := local.GetParentId()
+	if local.GetId() == root.GetId() {
+		parentId = root.GetId()
+	}
+
+	entity, err := database_node.New(
+		int64(local.GetId()),
+		local.GetName(),
+		int64(parentId),
+		s.path(local.GetPath(), root),
+		int64(uint32(local.GetMode())),
+		local.GetUid(),
+		local.GetGid(),
+		local.GetModTime(),
+		local.GetCreateTime(),
+		local.GetAccessTime(),
+		local.GetSize(),
+		int64(local.GetFlags()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return node.New(entity)
+}
+
+// path turns a path of the VFS within root into one of the view
+func (s *SubFileSystem) path(path string, root interfaces.Node) string {
+	if root.GetPath() == "/" {
+		return path
+	}
+
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(path, root.GetPath()), "/")
+}
+
+func (s *SubFileSystem) Root() (interfaces.Node, error) {
+	root, err := s.root()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.translate(root, root)
+}
+
+func (s *SubFileSystem) Open(id uint64) (interfaces.Node, error) {
+	node, root, err := s.open(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.translate(node, root)
+}
+
+// LookupPath finds a node by its path within the view, ".." stops at its root
+func (s *SubFileSystem) LookupPath(path string) (interfaces.Node, error) {
+	root, err := s.root()
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := s.fileSystem.LookupPath(gopath.Join(root.GetPath(), gopath.Clean("/"+path)))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.translate(node, root)
+}
+
+func (s *SubFileSystem) ReadDir(parentId uint64) ([]interfaces.Node, error) {
+	_, root, err := s.open(parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := s.fileSystem.ReadDir(parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]interfaces.Node, 0, len(children))
+	for _, child := range children {
+		node, err := s.translate(child, root)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+func (s *SubFileSystem) Lookup(parentId uint64, name string) (interfaces.Node, error) {
+	_, root, err := s.open(parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := s.fileSystem.Lookup(parentId, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.translate(node, root)
+}
+
+func (s *SubFileSystem) MkDir(parentId uint64, name string) error {
+	err := s.check(parentId)
+	if err != nil {
+		return err
+	}
+
+	return s.fileSystem.MkDir(parentId, name)
+}
+
+func (s *SubFileSystem) RmDir(id uint64) error {
+	if id == s.rootId {
+		return syscall.EBUSY
+	}
+
+	err := s.check(id)
+	if err != nil {
+		return err
+	}
+
+	return s.fileSystem.RmDir(id)
+}
+
+func (s *SubFileSystem) Touch(parentId uint64, name string) error {
+	err := s.check(parentId)
+	if err != nil {
+		return err
+	}
+
+	return s.fileSystem.Touch(parentId, name)
+}
+
+func (s *SubFileSystem) ReadFile(id uint64) ([]byte, error) {
+	err := s.check(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.fileSystem.ReadFile(id)
+}
+
+func (s *SubFileSystem) WriteFile(id uint64, content []byte) (int, error) {
+	err := s.check(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.fileSystem.WriteFile(id, content)
+}
+
+func (s *SubFileSystem) RemoveFile(id uint64) error {
+	err := s.check(id)
+	if err != nil {
+		return err
+	}
+
+	return s.fileSystem.RemoveFile(id)
+}
+
+func (s *SubFileSystem) Rename(id uint64, newName string, newParentId uint64) error {
+	if id == s.rootId {
+		return syscall.EBUSY
+	}
+
+	err := s.check(id, newParentId)
+	if err != nil {
+		return err
+	}
+
+	return s.fileSystem.Rename(id, newName, newParentId)
+}
+
+// Move moves the directory id to newParentId as name, both have to be
+// within the view
+func (s *SubFileSystem) Move(id uint64, name string, newParentId uint64) error {
+	if id == s.rootId {
+		return syscall.EBUSY
+	}
+
+	err := s.check(id, newParentId)
+	if err != nil {
+		return err
+	}
+
+	return s.fileSystem.Move(id, name, newParentId)
+}
+
+// Link creates a symlink to id, which has to be within the view as well
+func (s *SubFileSystem) Link(id uint64, name string, parentId uint64) error {
+	err := s.check(id, parentId)
+	if err != nil {
+		return err
+	}
+
+	return s.fileSystem.Link(id, name, parentId)
+}
+
+// ReadLink returns the path of the target within the view. A target outside
+// of it, which another view of the VFS may have linked to, is not found.
+func (s *SubFileSystem) ReadLink(id uint64) (string, error) {
+	_, root, err := s.open(id)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := s.fileSystem.ReadLink(id)
+	if err != nil {
+		return "", err
+	}
+
+	if !pathWithin(target, root.GetPath()) {
+		return "", syscall.ENOENT
+	}
+
+	return s.path(target, root), nil
+}
+
+// Save stores the mode, owner and times of node. Names and places change
+// through Rename, the root keeps its place in the VFS.
+func (s *SubFileSystem) Save(node interfaces.Node) error {
+	stored, _, err := s.open(node.GetId())
+	if err != nil {
+		return err
+	}
+
+	stored.SetMode(uint32(node.GetMode()))
+	stored.SetUid(node.GetUid())
+	stored.SetGid(node.GetGid())
+	stored.SetModTime(node.GetModTime())
+	stored.SetCreateTime(node.GetCreateTime())
+	stored.SetAccessTime(node.GetAccessTime())
+
+	return s.fileSystem.Save(stored)
+}
@@ -0,0 +1,168 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+)
+
+const paxXattrPrefix = "SCHILY.xattr."
+
+// ExportTar streams the subtree below the directory id as a PAX tar archive,
+// xattrs are stored as SCHILY.xattr records the way GNU tar and bsdtar do
+func (f *FileSystem) ExportTar(id uint64, w io.Writer) error {
+	root, err := f.getNode(id)
+	if err != nil {
+		return err
+	}
+
+	if !root.GetMode().IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	writer := tar.NewWriter(w)
+
+	err = f.archiveEntries(root, func(entry archiveEntry) error {
+		var handle *Handle
+		info := &nodeFileInfo{node: entry.node}
+
+		if entry.node.GetMode().IsRegular() {
+			handle, err = f.OpenFile(entry.node.GetId())
+			if err != nil {
+				return err
+			}
+			defer handle.Close()
+
+			info.size, err = handle.Size()
+			if err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, entry.target)
+		if err != nil {
+			return err
+		}
+
+		header.Name = entry.name
+		if entry.node.GetMode().IsDir() {
+			header.Name += "/"
+		}
+
+		header.Format = tar.FormatPAX
+		header.Uid = entry.node.GetUid()
+		header.Gid = entry.node.GetGid()
+
+		if accessTime, ok := nodeTime(entry.node.GetAccessTime()); ok {
+			header.AccessTime = accessTime
+		}
+
+		for key, value := range entry.attributes {
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+
+			header.PAXRecords[paxXattrPrefix+key] = value
+		}
+
+		err = writer.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if handle != nil {
+			_, err = io.CopyBuffer(writer, handle, make([]byte, 1<<20))
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// ImportTar extracts a tar archive into the directory parentId. Hard links
+// are extracted as copies, symlinks whose target is missing are skipped.
+func (f *FileSystem) ImportTar(r io.Reader, parentId uint64) error {
+	archiveImport, err := f.newArchiveImport(parentId)
+	if err != nil {
+		return err
+	}
+
+	reader := tar.NewReader(r)
+
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name, err := cleanName(header.Name)
+		if err != nil {
+			return err
+		}
+
+		if name == "" {
+			continue
+		}
+
+		metadata := archiveMetadata{
+			mode:       header.FileInfo().Mode(),
+			uid:        header.Uid,
+			gid:        header.Gid,
+			modTime:    header.ModTime,
+			accessTime: header.AccessTime,
+		}
+
+		for key, value := range header.PAXRecords {
+			if !strings.HasPrefix(key, paxXattrPrefix) {
+				continue
+			}
+
+			if metadata.attributes == nil {
+				metadata.attributes = make(map[string]string)
+			}
+
+			metadata.attributes[strings.TrimPrefix(key, paxXattrPrefix)] = value
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			node, err := archiveImport.directory(name)
+			if err == nil {
+				err = archiveImport.metadata(node, metadata)
+			}
+
+			if err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			err = archiveImport.file(name, reader, metadata)
+			if err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, err := cleanName(header.Linkname)
+			if err != nil {
+				return err
+			}
+
+			err = archiveImport.hardlink(name, target, metadata)
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			archiveImport.symlink(name, header.Linkname, metadata)
+		}
+	}
+
+	return archiveImport.finish()
+}
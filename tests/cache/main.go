@@ -0,0 +1,351 @@
+// Cache checks the block cache in front of a deliberately slow provider,
+// read through a VFS the way applications read provider backed files:
+//
+//	go run ./tests/cache
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sushydev/vfs_go"
+	"github.com/sushydev/vfs_go/cache"
+	"github.com/sushydev/vfs_go/interfaces"
+)
+
+const (
+	blockSize = 4 << 10
+	delay     = 20 * time.Millisecond
+)
+
+// slowProvider serves content from memory, taking delay for every read
+type slowProvider struct {
+	mutex    sync.Mutex
+	contents map[string][]byte
+	versions map[string]int
+	reads    atomic.Int64
+}
+
+var _ interfaces.ContentProvider = &slowProvider{}
+
+func (p *slowProvider) set(key string, content []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.contents[key] = content
+	p.versions[key]++
+}
+
+func (p *slowProvider) Size(key string) (int64, error) {
+	info, err := p.Stat(key)
+
+	return info.Size, err
+}
+
+func (p *slowProvider) ReadAt(key string, b []byte, offset int64) (int, error) {
+	time.Sleep(delay)
+	p.reads.Add(1)
+
+	p.mutex.Lock()
+	content, ok := p.contents[key]
+	p.mutex.Unlock()
+
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+
+	return bytes.NewReader(content).ReadAt(b, offset)
+}
+
+func (p *slowProvider) Stat(key string) (interfaces.ContentInfo, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	content, ok := p.contents[key]
+	if !ok {
+		return interfaces.ContentInfo{}, os.ErrNotExist
+	}
+
+	return interfaces.ContentInfo{Size: int64(len(content)), Version: fmt.Sprint(p.versions[key])}, nil
+}
+
+type check struct {
+	fileSystem *filesystem.FileSystem
+	provider   *slowProvider
+	cache      *cache.Cache
+	directory  string
+	rootId     uint64
+	failures   []string
+}
+
+func main() {
+	directory, err := os.MkdirTemp("", "vfs-cache")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(directory)
+
+	blocks := filepath.Join(directory, "blocks")
+
+	err = os.MkdirAll(blocks, 0700)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(blocks, "notes.txt"), []byte("not the cache's"), 0600)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache: %v\n", err)
+		os.Exit(1)
+	}
+
+	fileSystem, err := filesystem.New(filepath.Join(directory, "vfs.db"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache: %v\n", err)
+		os.Exit(1)
+	}
+	defer fileSystem.Close()
+
+	root, err := fileSystem.Root()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache: %v\n", err)
+		os.Exit(1)
+	}
+
+	provider := &slowProvider{contents: make(map[string][]byte), versions: make(map[string]int)}
+
+	blockCache, err := fileSystem.RegisterCachedProvider("slow", provider, cache.Options{
+		BlockSize: blockSize,
+		Directory: blocks,
+		MaxSize:   16 * blockSize,
+		ReadAhead: 2,
+		InfoTTL:   50 * time.Millisecond,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache: %v\n", err)
+		os.Exit(1)
+	}
+
+	c := &check{fileSystem: fileSystem, provider: provider, cache: blockCache, directory: blocks, rootId: root.GetId()}
+
+	scenarios := []struct {
+		name string
+		run  func()
+	}{
+		{"repeated reads", c.repeatedReads},
+		{"read ahead", c.readAhead},
+		{"eviction", c.eviction},
+		{"truncated block", c.truncatedBlock},
+		{"new version", c.newVersion},
+	}
+
+	for _, scenario := range scenarios {
+		before := len(c.failures)
+		scenario.run()
+
+		status := "ok"
+		if len(c.failures) > before {
+			status = "FAIL"
+		}
+
+		fmt.Printf("%-24s %s\n", scenario.name, status)
+	}
+
+	if len(c.failures) > 0 {
+		for _, failure := range c.failures {
+			fmt.Fprintf(os.Stderr, "cache: %s\n", failure)
+		}
+
+		os.Exit(1)
+	}
+}
+
+func (c *check) fail(format string, args ...any) {
+	c.failures = append(c.failures, fmt.Sprintf(format, args...))
+}
+
+// file creates a provider backed file of key holding blocks blocks
+func (c *check) file(key string, blocks int) (uint64, []byte) {
+	content := bytes.Repeat([]byte(key[:1]), blocks*blockSize)
+	for index := range content {
+		content[index] += byte(index / blockSize)
+	}
+
+	c.provider.set(key, content)
+
+	err := c.fileSystem.TouchRemote(c.rootId, key, "slow", key)
+	if err != nil {
+		c.fail("touch remote %s: %v", key, err)
+		return 0, nil
+	}
+
+	node, err := c.fileSystem.Lookup(c.rootId, key)
+	if err != nil {
+		c.fail("lookup %s: %v", key, err)
+		return 0, nil
+	}
+
+	return node.GetId(), content
+}
+
+// read reads the file id through ReadFile and compares it with expected
+func (c *check) read(id uint64, expected []byte) {
+	content, err := c.fileSystem.ReadFile(id)
+	if err != nil {
+		c.fail("read file %d: %v", id, err)
+		return
+	}
+
+	if !bytes.Equal(content, expected) {
+		c.fail("file %d reads %d bytes that differ from the %d provided", id, len(content), len(expected))
+	}
+}
+
+// repeatedReads reads a file twice, the second read may not reach the provider
+func (c *check) repeatedReads() {
+	id, content := c.file("repeat", 4)
+
+	c.read(id, content)
+	c.cache.Wait()
+
+	reads := c.provider.reads.Load()
+	hits := c.cache.Metrics().Hits
+
+	c.read(id, content)
+
+	if c.provider.reads.Load() != reads {
+		c.fail("second read fetched %d blocks again", c.provider.reads.Load()-reads)
+	}
+
+	if c.cache.Metrics().Hits < hits+4 {
+		c.fail("second read hit %d blocks, expected 4", c.cache.Metrics().Hits-hits)
+	}
+}
+
+// readAhead reads a file sequentially through a handle in small pieces,
+// blocks have to be fetched ahead of the reads
+func (c *check) readAhead() {
+	id, content := c.file("ahead", 8)
+
+	handle, err := c.fileSystem.OpenFile(id)
+	if err != nil {
+		c.fail("open: %v", err)
+		return
+	}
+	defer handle.Close()
+
+	aheads := c.cache.Metrics().ReadAheads
+
+	read, err := io.ReadAll(io.LimitReader(handle, blockSize*2))
+	if err != nil {
+		c.fail("read: %v", err)
+		return
+	}
+
+	c.cache.Wait()
+
+	if c.cache.Metrics().ReadAheads == aheads {
+		c.fail("sequential reads fetched nothing ahead")
+	}
+
+	rest, err := io.ReadAll(handle)
+	if err != nil {
+		c.fail("read: %v", err)
+	}
+
+	if !bytes.Equal(append(read, rest...), content) {
+		c.fail("handle reads differ from the provided content")
+	}
+}
+
+// eviction reads more than fits, the cache has to stay within its size and
+// leave files it did not write alone
+func (c *check) eviction() {
+	id, content := c.file("evict", 24)
+
+	c.read(id, content)
+	c.cache.Wait()
+
+	metrics := c.cache.Metrics()
+	if metrics.Evictions == 0 {
+		c.fail("nothing evicted after reading more than fits")
+	}
+
+	if metrics.Bytes > 16*blockSize {
+		c.fail("cache holds %d bytes, more than its limit", metrics.Bytes)
+	}
+
+	entries, err := os.ReadDir(c.directory)
+	if err != nil {
+		c.fail("read cache directory: %v", err)
+		return
+	}
+
+	blocks, foreign := 0, false
+	for _, entry := range entries {
+		switch {
+		case entry.Name() == "notes.txt":
+			foreign = true
+		case strings.HasPrefix(entry.Name(), "block-"):
+			blocks++
+		}
+	}
+
+	if !foreign {
+		c.fail("eviction deleted a file the cache did not write")
+	}
+
+	if blocks != metrics.Blocks {
+		c.fail("%d block files for %d cached blocks", blocks, metrics.Blocks)
+	}
+}
+
+// truncatedBlock cuts the block files short, reads have to fetch them again
+// instead of returning short or failing
+func (c *check) truncatedBlock() {
+	id, content := c.file("truncate", 2)
+
+	c.read(id, content)
+	c.cache.Wait()
+
+	entries, err := os.ReadDir(c.directory)
+	if err != nil {
+		c.fail("read cache directory: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "block-") {
+			os.Truncate(filepath.Join(c.directory, entry.Name()), 10)
+		}
+	}
+
+	reads := c.provider.reads.Load()
+
+	c.read(id, content)
+
+	if c.provider.reads.Load() == reads {
+		c.fail("truncated blocks were not fetched again")
+	}
+}
+
+// newVersion changes the content behind a key, reads have to see it once
+// the cached info expired without anyone calling Stat
+func (c *check) newVersion() {
+	id, content := c.file("version", 2)
+
+	c.read(id, content)
+
+	changed := bytes.Repeat([]byte("n"), 3*blockSize)
+	c.provider.set("version", changed)
+
+	time.Sleep(100 * time.Millisecond)
+
+	c.read(id, changed)
+}
@@ -0,0 +1,501 @@
+// Stress runs many goroutines against one VFS and checks what the
+// concurrency model promises. Run it with the race detector:
+//
+//	go run -race ./tests/stress
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/sushydev/vfs_go"
+	"github.com/sushydev/vfs_go/service"
+)
+
+type stress struct {
+	fileSystem *filesystem.FileSystem
+	rootId     uint64
+	workers    int
+	rounds     int
+
+	mutex    sync.Mutex
+	failures []string
+}
+
+func main() {
+	databasePath := flag.String("db", "", "database to use, a new one in a temporary directory by default")
+	workers := flag.Int("workers", 16, "goroutines per scenario")
+	rounds := flag.Int("rounds", 30, "rounds per goroutine")
+	flag.Parse()
+
+	if *databasePath == "" {
+		directory, err := os.MkdirTemp("", "vfs-stress")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "stress: %v\n", err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(directory)
+
+		*databasePath = filepath.Join(directory, "vfs.db")
+	}
+
+	fileSystem, err := filesystem.New(*databasePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stress: %v\n", err)
+		os.Exit(1)
+	}
+	defer fileSystem.Close()
+
+	root, err := fileSystem.Root()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stress: %v\n", err)
+		os.Exit(1)
+	}
+
+	s := &stress{fileSystem: fileSystem, rootId: root.GetId(), workers: *workers, rounds: *rounds}
+
+	scenarios := []struct {
+		name string
+		run  func()
+	}{
+		{"create if absent", s.createIfAbsent},
+		{"service helpers", s.serviceHelpers},
+		{"remove while creating", s.removeWhileCreating},
+		{"rename", s.rename},
+		{"write", s.write},
+		{"namespaces", s.namespaces},
+		{"locks", s.locks},
+		{"remove while open", s.removeWhileOpen},
+	}
+
+	for _, scenario := range scenarios {
+		before := len(s.failures)
+		scenario.run()
+
+		status := "ok"
+		if len(s.failures) > before {
+			status = "FAIL"
+		}
+
+		fmt.Printf("%-24s %s\n", scenario.name, status)
+	}
+
+	report, err := fileSystem.Fsck(filesystem.FsckOptions{})
+	if err != nil {
+		s.fail("fsck: %v", err)
+	} else {
+		for _, finding := range report.Findings {
+			if finding.Notice {
+				continue
+			}
+
+			s.fail("fsck: %s %s: %s", finding.Kind, finding.Path, finding.Message)
+		}
+	}
+
+	if len(s.failures) > 0 {
+		for _, failure := range s.failures {
+			fmt.Fprintf(os.Stderr, "stress: %s\n", failure)
+		}
+
+		os.Exit(1)
+	}
+}
+
+func (s *stress) fail(format string, args ...any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failures = append(s.failures, fmt.Sprintf(format, args...))
+}
+
+// check records err unless it is one of the errors a racing caller may get
+func (s *stress) check(operation string, err error, expected ...error) {
+	if err == nil {
+		return
+	}
+
+	for _, expectedErr := range expected {
+		if errors.Is(err, expectedErr) {
+			return
+		}
+	}
+
+	s.fail("%s: %v", operation, err)
+}
+
+// parallel runs fn on every worker at once
+func (s *stress) parallel(fn func(worker int)) {
+	var start, done sync.WaitGroup
+	start.Add(1)
+
+	for worker := 0; worker < s.workers; worker++ {
+		done.Add(1)
+
+		go func() {
+			defer done.Done()
+
+			start.Wait()
+			fn(worker)
+		}()
+	}
+
+	start.Done()
+	done.Wait()
+}
+
+func (s *stress) directory(name string) uint64 {
+	node, _, err := s.fileSystem.LookupOrMkDir(s.rootId, name)
+	if err != nil {
+		s.fail("mkdir %s: %v", name, err)
+		return s.rootId
+	}
+
+	return node.GetId()
+}
+
+// createIfAbsent races every worker for the same names, each has to be
+// created exactly once and found by everyone
+func (s *stress) createIfAbsent() {
+	parentId := s.directory("create")
+
+	created := make([]atomic.Int32, s.rounds)
+	ids := make([]atomic.Uint64, s.rounds)
+
+	s.parallel(func(worker int) {
+		for round := 0; round < s.rounds; round++ {
+			name := fmt.Sprintf("file-%d", round)
+
+			node, wasCreated, err := s.fileSystem.LookupOrTouch(parentId, name)
+			if err != nil {
+				s.fail("lookup or touch %s: %v", name, err)
+				continue
+			}
+
+			if wasCreated {
+				created[round].Add(1)
+			}
+
+			if !ids[round].CompareAndSwap(0, node.GetId()) && ids[round].Load() != node.GetId() {
+				s.fail("lookup or touch %s: got %d and %d", name, ids[round].Load(), node.GetId())
+			}
+
+			// Plain creation of a name that is taken fails cleanly
+			s.check("touch "+name, s.fileSystem.Touch(parentId, name), syscall.EEXIST)
+		}
+	})
+
+	for round := range created {
+		if count := created[round].Load(); count != 1 {
+			s.fail("file-%d was created %d times", round, count)
+		}
+	}
+}
+
+// serviceHelpers has every worker build the same tree with the service package
+func (s *stress) serviceHelpers() {
+	parentId := s.directory("service")
+
+	s.parallel(func(worker int) {
+		for round := 0; round < s.rounds; round++ {
+			directory, err := service.FindOrCreateDirectory(s.fileSystem, parentId, fmt.Sprintf("dir-%d", round%5))
+			if err != nil {
+				s.fail("find or create directory: %v", err)
+				continue
+			}
+
+			file, err := service.FindOrCreateFile(s.fileSystem, directory.GetId(), fmt.Sprintf("file-%d", round))
+			if err != nil {
+				s.fail("find or create file: %v", err)
+				continue
+			}
+
+			_, err = service.GetFile(s.fileSystem, file.GetId())
+			s.check("get file", err)
+		}
+	})
+
+	for index := 0; index < 5; index++ {
+		directory, err := s.fileSystem.Lookup(parentId, fmt.Sprintf("dir-%d", index))
+		if err != nil {
+			s.fail("lookup dir-%d: %v", index, err)
+			continue
+		}
+
+		children, err := s.fileSystem.ReadDir(directory.GetId())
+		if err != nil {
+			s.fail("read dir-%d: %v", index, err)
+			continue
+		}
+
+		if expected := (s.rounds - index + 4) / 5; len(children) != expected {
+			s.fail("dir-%d has %d files, expected %d", index, len(children), expected)
+		}
+	}
+}
+
+// removeWhileCreating removes directories others are creating files in, no
+// file may be left behind in a removed directory
+func (s *stress) removeWhileCreating() {
+	parentId := s.directory("remove")
+
+	s.parallel(func(worker int) {
+		for round := 0; round < s.rounds; round++ {
+			name := fmt.Sprintf("dir-%d", round%3)
+
+			if worker%4 == 0 {
+				directory, err := s.fileSystem.Lookup(parentId, name)
+				if err == nil {
+					s.check("rmdir "+name, s.fileSystem.RmDir(directory.GetId()), syscall.ENOENT, syscall.ENOTEMPTY)
+				}
+
+				continue
+			}
+
+			directory, _, err := s.fileSystem.LookupOrMkDir(parentId, name)
+			if err != nil {
+				s.check("mkdir "+name, err, syscall.ENOENT)
+				continue
+			}
+
+			file := fmt.Sprintf("file-%d-%d", worker, round)
+			s.check("touch "+file, s.fileSystem.Touch(directory.GetId(), file), syscall.ENOENT)
+
+			node, err := s.fileSystem.Lookup(directory.GetId(), file)
+			if err == nil {
+				s.check("remove "+file, s.fileSystem.RemoveFile(node.GetId()), syscall.ENOENT)
+			}
+		}
+	})
+}
+
+// rename moves directories into each other from every side, paths have to
+// stay consistent and no directory may end up below itself
+func (s *stress) rename() {
+	parentId := s.directory("rename")
+
+	var ids []uint64
+	for index := 0; index < 4; index++ {
+		node, _, err := s.fileSystem.LookupOrMkDir(parentId, fmt.Sprintf("dir-%d", index))
+		if err != nil {
+			s.fail("mkdir: %v", err)
+			return
+		}
+
+		ids = append(ids, node.GetId())
+	}
+
+	s.parallel(func(worker int) {
+		for round := 0; round < s.rounds; round++ {
+			id, newParentId := ids[(worker+round)%len(ids)], ids[(worker+round+1)%len(ids)]
+			if round%3 == 0 {
+				newParentId = parentId
+			}
+
+			err := s.fileSystem.Rename(id, fmt.Sprintf("dir-%d", (worker+round)%len(ids)), newParentId)
+			s.check("rename", err, syscall.EINVAL, syscall.EEXIST)
+
+			s.check("touch", s.fileSystem.Touch(id, fmt.Sprintf("file-%d-%d", worker, round)))
+		}
+	})
+}
+
+// write has workers writing and appending to the same files through
+// WriteFile, handles and xattrs
+func (s *stress) write() {
+	parentId := s.directory("write")
+
+	s.parallel(func(worker int) {
+		for round := 0; round < s.rounds; round++ {
+			name := fmt.Sprintf("file-%d", round%4)
+
+			node, _, err := s.fileSystem.LookupOrTouch(parentId, name)
+			if err != nil {
+				s.fail("lookup or touch %s: %v", name, err)
+				continue
+			}
+
+			content := []byte(fmt.Sprintf("worker %d round %d", worker, round))
+
+			switch round % 3 {
+			case 0:
+				_, err = s.fileSystem.WriteFile(node.GetId(), content)
+				s.check("write "+name, err)
+			case 1:
+				handle, err := s.fileSystem.OpenFile(node.GetId())
+				if err != nil {
+					s.fail("open %s: %v", name, err)
+					continue
+				}
+
+				size, err := handle.Size()
+				if err == nil {
+					_, err = handle.WriteAt(content, size)
+				}
+				s.check("write at "+name, err)
+				s.check("close "+name, handle.Close())
+			case 2:
+				s.check("set xattr "+name, s.fileSystem.SetXattr(node.GetId(), "user.writer", string(content)))
+			}
+
+			_, err = s.fileSystem.ReadFile(node.GetId())
+			s.check("read "+name, err)
+		}
+	})
+
+	for index := 0; index < 4; index++ {
+		node, err := s.fileSystem.Lookup(parentId, fmt.Sprintf("file-%d", index))
+		if err != nil {
+			s.fail("lookup file-%d: %v", index, err)
+			continue
+		}
+
+		keys, err := s.fileSystem.ListXattr(node.GetId())
+		if err != nil {
+			s.fail("list xattr file-%d: %v", index, err)
+			continue
+		}
+
+		if len(keys) > 1 {
+			s.fail("file-%d has %d xattrs, expected 1", index, len(keys))
+		}
+	}
+}
+
+// namespaces opens the same namespace from every worker and works in it
+func (s *stress) namespaces() {
+	s.parallel(func(worker int) {
+		for round := 0; round < s.rounds; round++ {
+			view, err := s.fileSystem.Namespace(fmt.Sprintf("stress-%d", round%2))
+			if err != nil {
+				s.fail("namespace: %v", err)
+				continue
+			}
+
+			root, err := view.Root()
+			if err != nil {
+				s.fail("namespace root: %v", err)
+				continue
+			}
+
+			_, err = service.FindOrCreateFile(view, root.GetId(), fmt.Sprintf("file-%d", round%3))
+			s.check("namespace find or create file", err)
+		}
+	})
+}
+
+// locks has workers increment a counter kept in a file, read and written
+// under an exclusive lock. No increment may get lost.
+func (s *stress) locks() {
+	parentId := s.directory("locks")
+
+	counter, _, err := s.fileSystem.LookupOrTouch(parentId, "counter")
+	if err != nil {
+		s.fail("lookup or touch counter: %v", err)
+		return
+	}
+
+	s.parallel(func(worker int) {
+		handle, err := s.fileSystem.OpenFile(counter.GetId())
+		if err != nil {
+			s.fail("open counter: %v", err)
+			return
+		}
+		defer handle.Close()
+
+		for round := 0; round < s.rounds; round++ {
+			err := s.fileSystem.Lock(handle, filesystem.LockExclusive, filesystem.LockRange{}, true)
+			if err != nil {
+				s.fail("lock counter: %v", err)
+				return
+			}
+
+			content, err := s.fileSystem.ReadFile(counter.GetId())
+			if err != nil {
+				s.fail("read counter: %v", err)
+			}
+
+			var count int
+			fmt.Sscan(string(content), &count)
+
+			_, err = s.fileSystem.WriteFile(counter.GetId(), []byte(fmt.Sprint(count+1)))
+			s.check("write counter", err)
+
+			s.check("unlock counter", s.fileSystem.Unlock(handle, filesystem.LockRange{}))
+		}
+	})
+
+	content, err := s.fileSystem.ReadFile(counter.GetId())
+	if err != nil {
+		s.fail("read counter: %v", err)
+		return
+	}
+
+	if expected := fmt.Sprint(s.workers * s.rounds); string(content) != expected {
+		s.fail("counter is %s, expected %s", content, expected)
+	}
+}
+
+// removeWhileOpen has workers write and read back their own part of a file
+// that others keep removing. A handle has to see its data whether the file
+// was removed meanwhile or not, and a removed file is gone once every
+// handle of it is closed.
+func (s *stress) removeWhileOpen() {
+	parentId := s.directory("open")
+	removed := make([][]uint64, s.workers)
+
+	s.parallel(func(worker int) {
+		marker := []byte(fmt.Sprintf("%08d", worker))
+
+		for round := 0; round < s.rounds; round++ {
+			node, _, err := s.fileSystem.LookupOrTouch(parentId, "shared")
+			if err != nil {
+				s.check("lookup or touch shared", err, syscall.ENOENT)
+				continue
+			}
+
+			handle, err := s.fileSystem.OpenFile(node.GetId())
+			if err != nil {
+				s.check("open shared", err, syscall.ENOENT)
+				continue
+			}
+
+			_, err = handle.WriteAt(marker, int64(worker*len(marker)))
+			s.check("write shared", err)
+
+			if worker%2 == 0 {
+				err := s.fileSystem.RemoveFile(node.GetId())
+				s.check("remove shared", err, syscall.ENOENT)
+
+				if err == nil {
+					removed[worker] = append(removed[worker], node.GetId())
+				}
+			}
+
+			content := make([]byte, len(marker))
+			_, err = handle.ReadAt(content, int64(worker*len(marker)))
+			s.check("read shared", err)
+
+			if string(content) != string(marker) {
+				s.fail("read %q back from shared, wrote %q", content, marker)
+			}
+
+			s.check("close shared", handle.Close())
+		}
+	})
+
+	for _, ids := range removed {
+		for _, id := range ids {
+			_, err := s.fileSystem.Open(id)
+			if err != syscall.ENOENT {
+				s.fail("removed file %d is still there: %v", id, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package filesystem
+
+import (
+	"syscall"
+)
+
+// Usage totals a subtree of the VFS
+type Usage struct {
+	Nodes       int64
+	Files       int64
+	Directories int64
+	// Bytes is the size of the stored content of all files
+	Bytes int64
+}
+
+// Stats describes the VFS as a whole, like statfs
+type Stats struct {
+	Usage
+
+	// DatabaseBytes is the size of the database file, FreeBytes the part of
+	// it that is allocated but unused and taken up again before it grows
+	DatabaseBytes int64
+	FreeBytes     int64
+	PageSize      int64
+
+	// BytesAvailable and NodesAvailable are the headroom left by the hard
+	// limits of a quota on the root directory, -1 when there is no limit
+	BytesAvailable int64
+	NodesAvailable int64
+}
+
+// DiskUsage returns the totals of id and everything stored below it. Sizes
+// are kept per node so this is a single indexed query, however large the
+// subtree. Generated files, provider backed content and the contents of
+// mounts are not stored and do not count.
+func (f *FileSystem) DiskUsage(id uint64) (Usage, error) {
+	node, err := f.Open(id)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	if node.GetMode().IsDir() {
+		err = f.require(node, AccessRead|AccessExecute)
+		if err != nil {
+			return Usage{}, err
+		}
+	}
+
+	usage, err := f.database.GetNodeUsage(node.GetPath())
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return Usage(usage), nil
+}
+
+func (f *FileSystem) StatFS() (Stats, error) {
+	usage, err := f.database.GetNodeUsage("/")
+	if err != nil {
+		return Stats{}, err
+	}
+
+	pages, err := f.database.GetPageStats()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{
+		Usage:          Usage(usage),
+		DatabaseBytes:  pages.PageCount * pages.PageSize,
+		FreeBytes:      pages.FreePages * pages.PageSize,
+		PageSize:       pages.PageSize,
+		BytesAvailable: -1,
+		NodesAvailable: -1,
+	}
+
+	quota, err := f.GetQuotaUsage(QuotaDirectory, f.rootId)
+	if err == syscall.ENOENT {
+		return stats, nil
+	}
+
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if quota.HardBytes > 0 {
+		stats.BytesAvailable = max(quota.HardBytes-quota.Bytes, 0)
+	}
+
+	if quota.HardNodes > 0 {
+		stats.NodesAvailable = max(quota.HardNodes-quota.Nodes, 0)
+	}
+
+	return stats, nil
+}
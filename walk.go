@@ -0,0 +1,299 @@
+package filesystem
+
+import (
+	"io/fs"
+	"iter"
+	"strings"
+
+	"github.com/sushydev/vfs_go/interfaces"
+	database_interfaces "github.com/sushydev/vfs_go/internal/database/interfaces"
+	"github.com/sushydev/vfs_go/internal/filesystem/node"
+)
+
+// SkipDir and SkipAll are the io/fs values, returned from a WalkFunc they
+// skip the current directory or the rest of the walk
+var (
+	SkipDir = fs.SkipDir
+	SkipAll = fs.SkipAll
+)
+
+type WalkOrder int
+
+const (
+	// DepthFirst visits a directory and then everything below it before its next sibling
+	DepthFirst WalkOrder = iota
+	// BreadthFirst visits all nodes of one depth before those of the next
+	BreadthFirst
+)
+
+type WalkOptions struct {
+	Order WalkOrder
+	// MaxDepth limits how far below the root the walk goes, 0 means no limit
+	MaxDepth int
+}
+
+// WalkFunc is called for every node of a walk, depth is 0 for the root.
+// Returning SkipDir for a directory skips its contents, for a file it skips
+// the remaining entries of its directory. SkipAll ends the walk, any other
+// error ends it and is returned by Walk.
+type WalkFunc func(node interfaces.Node, depth int) error
+
+// WalkEntry is a node yielded by WalkSeq
+type WalkEntry struct {
+	Node  interfaces.Node
+	Depth int
+
+	skip func()
+}
+
+// SkipDir stops the walk from descending into the entry, or from visiting
+// the rest of its directory when the entry is a file, like returning SkipDir
+// from a WalkFunc. Ending the range loop works like SkipAll.
+func (e WalkEntry) SkipDir() {
+	e.skip()
+}
+
+// walkBatchSize is the number of nodes a walk reads at once
+const walkBatchSize = 256
+
+// Walk visits rootId and everything below it depth first, siblings in
+// lexical order. The tree is read a page at a time by a recursive query
+// that resumes after the last node of the page before, so a walk holds one
+// page and changes made meanwhile show up once the walk gets to them.
+// Generated files and the contents of mounts are not visited, nor are the
+// contents of directories the caller may not read and search.
+func (f *FileSystem) Walk(rootId uint64, fn WalkFunc) error {
+	return f.WalkWith(rootId, WalkOptions{}, fn)
+}
+
+// WalkWith is Walk with a choice of order and a depth limit. Breadth first
+// holds the directories of the next depth that are still to be read and
+// reads the children of a number of them at once.
+func (f *FileSystem) WalkWith(rootId uint64, options WalkOptions, fn WalkFunc) error {
+	root, err := f.Open(rootId)
+	if err != nil {
+		return err
+	}
+
+	if isMountedId(rootId) || isGeneratedId(rootId) {
+		return nil
+	}
+
+	w := &walker{fileSystem: f, rootId: rootId, maxDepth: options.MaxDepth, fn: fn}
+
+	enter, err := w.visit(root, 0)
+	if err != nil || !enter {
+		return ignoreSkip(err)
+	}
+
+	if options.Order == BreadthFirst {
+		return ignoreSkip(w.breadthFirst(root))
+	}
+
+	return ignoreSkip(w.depthFirst(root))
+}
+
+// walker is the state of one walk
+type walker struct {
+	fileSystem *FileSystem
+	rootId     uint64
+	maxDepth   int
+	fn         WalkFunc
+}
+
+// visit calls fn for node and tells whether the walk goes into it. It
+// returns SkipDir when the rest of the directory of node is skipped and
+// SkipAll when the walk ends.
+func (w *walker) visit(node interfaces.Node, depth int) (bool, error) {
+	enter := node.GetMode().IsDir() && (w.maxDepth <= 0 || depth < w.maxDepth)
+
+	// Directories the caller may not list are visited but not entered
+	if enter {
+		permitted, err := w.fileSystem.permits(node, AccessRead|AccessExecute)
+		if err != nil {
+			return false, err
+		}
+
+		enter = permitted
+	}
+
+	err := w.fn(node, depth)
+	switch {
+	case err == nil:
+		return enter, nil
+	case err == SkipDir && node.GetId() == w.rootId, err == SkipAll:
+		return false, SkipAll
+	case err == SkipDir && node.GetMode().IsDir():
+		return false, nil
+	}
+
+	return false, err
+}
+
+// depthFirst visits the nodes below root in the order WalkNodes has them.
+// What lies below a node that is not entered still comes with the page, it
+// is passed over and the next page resumes after it. Every node has one
+// parent, so a parent_id cycle can only lead back through the root, which
+// the query never enters again.
+func (w *walker) depthFirst(root interfaces.Node) error {
+	after := ""
+
+	for {
+		walkNodes, err := w.fileSystem.database.WalkNodes(root.GetEntity(), after, w.maxDepth, walkBatchSize)
+		if err != nil {
+			return err
+		}
+
+		// skipped is the key of the directory whose contents are passed over
+		skipped := ""
+
+		for _, walkNode := range walkNodes {
+			if skipped != "" && strings.HasPrefix(walkNode.Key, skipped+"\x01") {
+				continue
+			}
+
+			skipped = ""
+
+			child, err := node.New(walkNode.Node)
+			if err != nil {
+				return err
+			}
+
+			enter, err := w.visit(child, walkNode.Depth)
+			switch {
+			case err == SkipDir && walkNode.Depth == 1:
+				return nil
+			case err == SkipDir:
+				skipped = walkNode.Key[:strings.LastIndexByte(walkNode.Key, '\x01')]
+			case err != nil:
+				return err
+			case !enter && child.GetMode().IsDir():
+				skipped = walkNode.Key
+			}
+		}
+
+		if len(walkNodes) < walkBatchSize {
+			return nil
+		}
+
+		after = walkNodes[len(walkNodes)-1].Key
+		if skipped != "" {
+			after = skipped + "\x02"
+		}
+	}
+}
+
+func (w *walker) breadthFirst(root interfaces.Node) error {
+	directories := []interfaces.Node{root}
+
+	for depth := 1; len(directories) > 0; depth++ {
+		var entered []interfaces.Node
+
+		for len(directories) > 0 {
+			batch := directories[:min(len(directories), walkBatchSize)]
+			directories = directories[len(batch):]
+
+			err := w.children(batch, func(child interfaces.Node) error {
+				enter, err := w.visit(child, depth)
+				if enter {
+					entered = append(entered, child)
+				}
+
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		directories = entered
+	}
+
+	return nil
+}
+
+// children calls fn for the entries of directories a page at a time, in the
+// order of the directories and then by name. Once fn returns SkipDir the
+// rest of the directory of the entry is passed over.
+func (w *walker) children(directories []interfaces.Node, fn func(interfaces.Node) error) error {
+	parents := make([]database_interfaces.Node, len(directories))
+	positions := make(map[int64]int, len(directories))
+
+	for i, directory := range directories {
+		parents[i] = directory.GetEntity()
+		positions[int64(directory.GetId())] = i
+	}
+
+	afterParent, after := -1, ""
+	skipped := -1
+
+	for {
+		entities, err := w.fileSystem.database.GetNodesByParentsAfter(parents, afterParent, after, walkBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, entity := range entities {
+			position := positions[entity.GetParentId()]
+			afterParent, after = position, entity.GetName()
+
+			if position == skipped || uint64(entity.GetId()) == w.rootId {
+				continue
+			}
+
+			child, err := node.New(entity)
+			if err != nil {
+				return err
+			}
+
+			err = fn(child)
+			if err == SkipDir {
+				skipped = position
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if len(entities) < walkBatchSize {
+			return nil
+		}
+
+		// No name is empty, so this resumes at the start of the next directory
+		if afterParent == skipped {
+			afterParent, after = skipped+1, ""
+		}
+	}
+}
+
+// ignoreSkip turns the errors that end a walk early into its success
+func ignoreSkip(err error) error {
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+
+	return err
+}
+
+// WalkSeq is WalkWith as an iterator. The walk stops when the loop ends;
+// WalkEntry.SkipDir prunes it.
+func (f *FileSystem) WalkSeq(rootId uint64, options WalkOptions) iter.Seq2[WalkEntry, error] {
+	return func(yield func(WalkEntry, error) bool) {
+		err := f.WalkWith(rootId, options, func(node interfaces.Node, depth int) error {
+			skip := false
+			entry := WalkEntry{Node: node, Depth: depth, skip: func() { skip = true }}
+
+			if !yield(entry, nil) {
+				return SkipAll
+			}
+
+			if skip {
+				return SkipDir
+			}
+
+			return nil
+		})
+		if err != nil {
+			yield(WalkEntry{}, err)
+		}
+	}
+}
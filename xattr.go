@@ -0,0 +1,123 @@
+package filesystem
+
+import (
+	"database/sql"
+	"strings"
+	"syscall"
+)
+
+func (f *FileSystem) GetXattr(id uint64, key string) (string, error) {
+	err := f.checkXattr(id, key, false)
+	if err != nil {
+		return "", err
+	}
+
+	node, err := f.getNode(id)
+	if err != nil {
+		return "", err
+	}
+
+	nodeAttribute, err := f.nodeAttributeRepository.GetByNodeAndKey(node, key)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	if nodeAttribute == nil {
+		return "", syscall.ENODATA
+	}
+
+	return nodeAttribute.GetValue(), nil
+}
+
+func (f *FileSystem) ListXattr(id uint64) ([]string, error) {
+	err := f.checkAccess(id, AccessExists)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := f.getNode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeAttributes, err := f.nodeAttributeRepository.GetByNode(node)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(nodeAttributes))
+	for _, nodeAttribute := range nodeAttributes {
+		// Like on Linux trusted attributes do not show up for others than root
+		if !f.isRoot() && strings.HasPrefix(nodeAttribute.GetKey(), "trusted.") {
+			continue
+		}
+
+		keys = append(keys, nodeAttribute.GetKey())
+	}
+
+	return keys, nil
+}
+
+func (f *FileSystem) SetXattr(id uint64, key string, value string) error {
+	if isMountedId(id) || isGeneratedId(id) {
+		return syscall.EROFS
+	}
+
+	if kind, ok := aclType(key); ok {
+		acl, err := ParseACL(value)
+		if err != nil {
+			return syscall.EINVAL
+		}
+
+		return f.SetACL(id, kind, acl)
+	}
+
+	err := f.checkXattr(id, key, true)
+	if err != nil {
+		return err
+	}
+
+	return f.setXattr(id, key, value)
+}
+
+// setXattr sets key without checking the caller, for attributes the VFS keeps itself
+func (f *FileSystem) setXattr(id uint64, key string, value string) error {
+	node, err := f.getNode(id)
+	if err != nil {
+		return err
+	}
+
+	if key == "" {
+		return syscall.EINVAL
+	}
+
+	// Inserting replaces the value the key has, if any
+	return constraintError(f.database.InsertNodeAttribute(node.GetEntity(), key, value))
+}
+
+func (f *FileSystem) RemoveXattr(id uint64, key string) error {
+	if isMountedId(id) || isGeneratedId(id) {
+		return syscall.EROFS
+	}
+
+	err := f.checkXattr(id, key, true)
+	if err != nil {
+		return err
+	}
+
+	node, err := f.getNode(id)
+	if err != nil {
+		return err
+	}
+
+	nodeAttribute, err := f.nodeAttributeRepository.GetByNodeAndKey(node, key)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if nodeAttribute == nil {
+		return syscall.ENODATA
+	}
+
+	return f.database.DeleteNodeAttribute(nodeAttribute.GetEntity().GetId())
+}
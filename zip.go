@@ -0,0 +1,217 @@
+package filesystem
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"syscall"
+)
+
+// Info-ZIP "new Unix" extra field holding the owner of an entry
+const zipUnixExtraId = 0x7875
+
+func zipUnixExtra(uid int, gid int) []byte {
+	extra := make([]byte, 15)
+
+	binary.LittleEndian.PutUint16(extra[0:], zipUnixExtraId)
+	binary.LittleEndian.PutUint16(extra[2:], 11)
+	extra[4] = 1
+	extra[5] = 4
+	binary.LittleEndian.PutUint32(extra[6:], uint32(uid))
+	extra[10] = 4
+	binary.LittleEndian.PutUint32(extra[11:], uint32(gid))
+
+	return extra
+}
+
+func parseZipUnixExtra(extra []byte) (int, int, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		if id != zipUnixExtraId || len(field) < 2 || field[0] != 1 {
+			continue
+		}
+
+		uidSize := int(field[1])
+		if len(field) < 2+uidSize+1 {
+			continue
+		}
+
+		gidSize := int(field[2+uidSize])
+		if len(field) < 3+uidSize+gidSize {
+			continue
+		}
+
+		return int(littleEndian(field[2 : 2+uidSize])), int(littleEndian(field[3+uidSize : 3+uidSize+gidSize])), true
+	}
+
+	return 0, 0, false
+}
+
+func littleEndian(value []byte) uint64 {
+	var result uint64
+	for index := len(value) - 1; index >= 0; index-- {
+		result = result<<8 | uint64(value[index])
+	}
+
+	return result
+}
+
+// ExportZip streams the subtree below the directory id as a zip archive. Owners
+// go into the Info-ZIP unix extra field, zip has no common place for xattrs and
+// access times so those are left out.
+func (f *FileSystem) ExportZip(id uint64, w io.Writer) error {
+	root, err := f.getNode(id)
+	if err != nil {
+		return err
+	}
+
+	if !root.GetMode().IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	writer := zip.NewWriter(w)
+
+	err = f.archiveEntries(root, func(entry archiveEntry) error {
+		var content io.Reader
+		info := &nodeFileInfo{node: entry.node}
+
+		switch {
+		case entry.node.GetMode().IsRegular():
+			handle, err := f.OpenFile(entry.node.GetId())
+			if err != nil {
+				return err
+			}
+			defer handle.Close()
+
+			info.size, err = handle.Size()
+			if err != nil {
+				return err
+			}
+
+			content = handle
+		case entry.node.GetMode()&fs.ModeSymlink != 0:
+			info.size = int64(len(entry.target))
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+
+		header.Name = entry.name
+		header.Method = zip.Store
+		header.Extra = zipUnixExtra(entry.node.GetUid(), entry.node.GetGid())
+
+		switch {
+		case entry.node.GetMode().IsDir():
+			header.Name += "/"
+		case entry.node.GetMode().IsRegular():
+			header.Method = zip.Deflate
+		}
+
+		entryWriter, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case content != nil:
+			_, err = io.CopyBuffer(entryWriter, content, make([]byte, 1<<20))
+		case entry.node.GetMode()&fs.ModeSymlink != 0:
+			_, err = io.WriteString(entryWriter, entry.target)
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// ImportZip extracts a zip archive into the directory parentId, symlinks
+// stored the Info-ZIP way with the target as content are restored
+func (f *FileSystem) ImportZip(r io.ReaderAt, size int64, parentId uint64) error {
+	archiveImport, err := f.newArchiveImport(parentId)
+	if err != nil {
+		return err
+	}
+
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reader.File {
+		name, err := cleanName(file.Name)
+		if err != nil {
+			return err
+		}
+
+		if name == "" {
+			continue
+		}
+
+		metadata := archiveMetadata{
+			mode:    file.Mode(),
+			modTime: file.Modified,
+		}
+
+		if uid, gid, ok := parseZipUnixExtra(file.Extra); ok {
+			metadata.uid, metadata.gid = uid, gid
+		}
+
+		switch {
+		case metadata.mode.IsDir():
+			node, err := archiveImport.directory(name)
+			if err == nil {
+				err = archiveImport.metadata(node, metadata)
+			}
+
+			if err != nil {
+				return err
+			}
+		case metadata.mode&fs.ModeSymlink != 0:
+			target, err := readZipFile(file, 4096)
+			if err != nil {
+				return err
+			}
+
+			archiveImport.symlink(name, string(target), metadata)
+		case metadata.mode.IsRegular():
+			content, err := file.Open()
+			if err != nil {
+				return err
+			}
+
+			err = archiveImport.file(name, content, metadata)
+			content.Close()
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return archiveImport.finish()
+}
+
+func readZipFile(file *zip.File, limit int64) ([]byte, error) {
+	content, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return io.ReadAll(io.LimitReader(content, limit))
+}